@@ -0,0 +1,384 @@
+package dsig
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	// Link in the implementation of every registered hash, so that
+	// crypto.Hash.New never panics for a registered algorithm.
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+
+	_ "golang.org/x/crypto/sha3"
+)
+
+// Signer produces the SignatureValue for a digest of the canonical SignedInfo.
+type Signer interface {
+	Sign(key crypto.Signer, hash crypto.Hash, digest []byte) ([]byte, error)
+}
+
+// Verifier checks a SignatureValue against a digest of the canonical SignedInfo.
+type Verifier interface {
+	Verify(key crypto.PublicKey, hash crypto.Hash, digest, signature []byte) error
+}
+
+// DigestAlgorithm is a DigestMethod which can be used in References.
+type DigestAlgorithm struct {
+	// URI identifies the algorithm in DigestMethod elements.
+	URI  string
+	Hash crypto.Hash
+}
+
+// SignatureAlgorithm is a SignatureMethod which can be used to sign and
+// verify SignedInfo.
+type SignatureAlgorithm struct {
+	// URI identifies the algorithm in SignatureMethod elements.
+	URI  string
+	Hash crypto.Hash
+
+	// PublicKeyAlgorithm is the type of key the algorithm signs with. It
+	// selects the default SignatureMethod for a signing key.
+	PublicKeyAlgorithm x509.PublicKeyAlgorithm
+
+	Signer   Signer
+	Verifier Verifier
+}
+
+type signatureMethodKey struct {
+	keyAlgorithm x509.PublicKeyAlgorithm
+	hash         crypto.Hash
+}
+
+var (
+	algorithmLock sync.RWMutex
+
+	digestAlgorithmsByIdentifier = map[string]DigestAlgorithm{}
+	signatureMethodsByIdentifier = map[string]SignatureAlgorithm{}
+
+	// digestAlgorithmIdentifiers and signatureMethodIdentifiers hold the
+	// identifiers used by default when signing with a given hash and key.
+	digestAlgorithmIdentifiers = map[crypto.Hash]string{}
+	signatureMethodIdentifiers = map[signatureMethodKey]string{}
+)
+
+// RegisterDigestAlgorithm makes a digest algorithm available for signing and
+// validation. The first algorithm registered for a hash becomes the default
+// DigestMethod used when signing with that hash.
+func RegisterDigestAlgorithm(alg DigestAlgorithm) {
+	algorithmLock.Lock()
+	defer algorithmLock.Unlock()
+
+	digestAlgorithmsByIdentifier[alg.URI] = alg
+	if _, ok := digestAlgorithmIdentifiers[alg.Hash]; !ok {
+		digestAlgorithmIdentifiers[alg.Hash] = alg.URI
+	}
+}
+
+// RegisterSignatureAlgorithm makes a signature algorithm available for
+// signing and validation. The first algorithm registered for a hash and
+// public key algorithm becomes the default SignatureMethod used when signing
+// with that hash and type of key.
+func RegisterSignatureAlgorithm(alg SignatureAlgorithm) {
+	algorithmLock.Lock()
+	defer algorithmLock.Unlock()
+
+	signatureMethodsByIdentifier[alg.URI] = alg
+
+	key := signatureMethodKey{keyAlgorithm: alg.PublicKeyAlgorithm, hash: alg.Hash}
+	if _, ok := signatureMethodIdentifiers[key]; !ok {
+		signatureMethodIdentifiers[key] = alg.URI
+	}
+}
+
+// LookupDigestAlgorithm returns the digest algorithm registered for uri.
+func LookupDigestAlgorithm(uri string) (DigestAlgorithm, bool) {
+	algorithmLock.RLock()
+	defer algorithmLock.RUnlock()
+
+	alg, ok := digestAlgorithmsByIdentifier[uri]
+	return alg, ok
+}
+
+// LookupSignatureAlgorithm returns the signature algorithm registered for uri.
+func LookupSignatureAlgorithm(uri string) (SignatureAlgorithm, bool) {
+	algorithmLock.RLock()
+	defer algorithmLock.RUnlock()
+
+	alg, ok := signatureMethodsByIdentifier[uri]
+	return alg, ok
+}
+
+func defaultDigestAlgorithmIdentifier(hash crypto.Hash) string {
+	algorithmLock.RLock()
+	defer algorithmLock.RUnlock()
+
+	return digestAlgorithmIdentifiers[hash]
+}
+
+func defaultSignatureMethodIdentifier(keyAlgorithm x509.PublicKeyAlgorithm, hash crypto.Hash) string {
+	algorithmLock.RLock()
+	defer algorithmLock.RUnlock()
+
+	return signatureMethodIdentifiers[signatureMethodKey{keyAlgorithm: keyAlgorithm, hash: hash}]
+}
+
+func publicKeyAlgorithm(pub crypto.PublicKey) x509.PublicKeyAlgorithm {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return x509.RSA
+	case *ecdsa.PublicKey:
+		return x509.ECDSA
+	default:
+		return x509.UnknownPublicKeyAlgorithm
+	}
+}
+
+// AlgorithmPolicy restricts which registered digest and signature algorithms
+// a SigningContext or ValidationContext may use. A nil *AlgorithmPolicy
+// permits every registered algorithm.
+type AlgorithmPolicy struct {
+	// Allowed, if not empty, lists the only algorithm URIs which may be used.
+	Allowed []string
+
+	// Disallowed lists algorithm URIs which may not be used.
+	Disallowed []string
+}
+
+// Permits reports whether the algorithm identified by uri may be used.
+func (p *AlgorithmPolicy) Permits(uri string) bool {
+	if p == nil {
+		return true
+	}
+
+	for _, disallowed := range p.Disallowed {
+		if disallowed == uri {
+			return false
+		}
+	}
+
+	if len(p.Allowed) == 0 {
+		return true
+	}
+
+	for _, allowed := range p.Allowed {
+		if allowed == uri {
+			return true
+		}
+	}
+
+	return false
+}
+
+// digestAlgorithm resolves a registered DigestMethod permitted by the policy.
+func (p *AlgorithmPolicy) digestAlgorithm(uri string) (DigestAlgorithm, error) {
+	alg, ok := LookupDigestAlgorithm(uri)
+	if !ok {
+		return alg, errors.New("Unknown digest algorithm: " + uri)
+	}
+
+	if !p.Permits(uri) {
+		return alg, errors.New("Digest algorithm not permitted: " + uri)
+	}
+
+	if !alg.Hash.Available() {
+		return alg, fmt.Errorf("Digest algorithm %s is not linked into the binary", uri)
+	}
+
+	return alg, nil
+}
+
+// signatureAlgorithm resolves a registered SignatureMethod permitted by the policy.
+func (p *AlgorithmPolicy) signatureAlgorithm(uri string) (SignatureAlgorithm, error) {
+	alg, ok := LookupSignatureAlgorithm(uri)
+	if !ok {
+		return alg, errors.New("Unknown signature method: " + uri)
+	}
+
+	if !p.Permits(uri) {
+		return alg, errors.New("Signature method not permitted: " + uri)
+	}
+
+	if !alg.Hash.Available() {
+		return alg, fmt.Errorf("Signature method %s is not linked into the binary", uri)
+	}
+
+	return alg, nil
+}
+
+// RSAPKCS1v15 signs and verifies RSASSA-PKCS1-v1_5 signatures.
+type RSAPKCS1v15 struct{}
+
+// Sign implements Signer.
+func (RSAPKCS1v15) Sign(key crypto.Signer, hash crypto.Hash, digest []byte) ([]byte, error) {
+	if _, ok := key.Public().(*rsa.PublicKey); !ok {
+		return nil, ErrNonRSAKey
+	}
+
+	return key.Sign(rand.Reader, digest, hash)
+}
+
+// Verify implements Verifier.
+func (RSAPKCS1v15) Verify(key crypto.PublicKey, hash crypto.Hash, digest, signature []byte) error {
+	pubKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("Invalid public key")
+	}
+
+	return rsa.VerifyPKCS1v15(pubKey, hash, digest, signature)
+}
+
+// RSAPSS signs and verifies RSASSA-PSS signatures with MGF1, using the
+// digest length as the salt length as specified by RFC 6931.
+type RSAPSS struct{}
+
+// Sign implements Signer.
+func (RSAPSS) Sign(key crypto.Signer, hash crypto.Hash, digest []byte) ([]byte, error) {
+	if _, ok := key.Public().(*rsa.PublicKey); !ok {
+		return nil, ErrNonRSAKey
+	}
+
+	return key.Sign(rand.Reader, digest, &rsa.PSSOptions{
+		SaltLength: hash.Size(),
+		Hash:       hash,
+	})
+}
+
+// Verify implements Verifier.
+func (RSAPSS) Verify(key crypto.PublicKey, hash crypto.Hash, digest, signature []byte) error {
+	pubKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("Invalid public key")
+	}
+
+	return rsa.VerifyPSS(pubKey, hash, digest, signature, &rsa.PSSOptions{
+		SaltLength: hash.Size(),
+		Hash:       hash,
+	})
+}
+
+// ECDSA signs and verifies ECDSA signatures, encoded as the concatenation
+// of r and s as required by XMLDSig 1.1.
+type ECDSA struct{}
+
+// Sign implements Signer.
+func (ECDSA) Sign(key crypto.Signer, hash crypto.Hash, digest []byte) ([]byte, error) {
+	pubKey, ok := key.Public().(*ecdsa.PublicKey)
+	if !ok {
+		return nil, ErrNonECDSAKey
+	}
+
+	der, err := key.Sign(rand.Reader, digest, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var sig struct {
+		R, S *big.Int
+	}
+	if _, err := asn1.Unmarshal(der, &sig); err != nil {
+		return nil, err
+	}
+
+	size := (pubKey.Curve.Params().BitSize + 7) / 8
+	signature := make([]byte, 2*size)
+	sig.R.FillBytes(signature[:size])
+	sig.S.FillBytes(signature[size:])
+
+	return signature, nil
+}
+
+// Verify implements Verifier.
+func (ECDSA) Verify(key crypto.PublicKey, hash crypto.Hash, digest, signature []byte) error {
+	pubKey, ok := key.(*ecdsa.PublicKey)
+	if !ok {
+		return errors.New("Invalid public key")
+	}
+
+	size := (pubKey.Curve.Params().BitSize + 7) / 8
+	if len(signature) != 2*size {
+		return errors.New("Invalid ECDSA signature length")
+	}
+
+	r := new(big.Int).SetBytes(signature[:size])
+	s := new(big.Int).SetBytes(signature[size:])
+	if !ecdsa.Verify(pubKey, digest, r, s) {
+		return errors.New("ECDSA verification failure")
+	}
+
+	return nil
+}
+
+func init() {
+	RegisterDigestAlgorithm(DigestAlgorithm{URI: SHA1DigestMethod, Hash: crypto.SHA1})
+	RegisterDigestAlgorithm(DigestAlgorithm{URI: SHA224DigestMethod, Hash: crypto.SHA224})
+	RegisterDigestAlgorithm(DigestAlgorithm{URI: SHA256DigestMethod, Hash: crypto.SHA256})
+	RegisterDigestAlgorithm(DigestAlgorithm{URI: SHA384DigestMethod, Hash: crypto.SHA384})
+	RegisterDigestAlgorithm(DigestAlgorithm{URI: SHA512DigestMethod, Hash: crypto.SHA512})
+	RegisterDigestAlgorithm(DigestAlgorithm{URI: SHA3_256DigestMethod, Hash: crypto.SHA3_256})
+	RegisterDigestAlgorithm(DigestAlgorithm{URI: SHA3_384DigestMethod, Hash: crypto.SHA3_384})
+	RegisterDigestAlgorithm(DigestAlgorithm{URI: SHA3_512DigestMethod, Hash: crypto.SHA3_512})
+
+	for _, alg := range []struct {
+		uri  string
+		hash crypto.Hash
+	}{
+		{RSASHA1SignatureMethod, crypto.SHA1},
+		{RSASHA224SignatureMethod, crypto.SHA224},
+		{RSASHA256SignatureMethod, crypto.SHA256},
+		{RSASHA384SignatureMethod, crypto.SHA384},
+		{RSASHA512SignatureMethod, crypto.SHA512},
+	} {
+		RegisterSignatureAlgorithm(SignatureAlgorithm{
+			URI:                alg.uri,
+			Hash:               alg.hash,
+			PublicKeyAlgorithm: x509.RSA,
+			Signer:             RSAPKCS1v15{},
+			Verifier:           RSAPKCS1v15{},
+		})
+	}
+
+	for _, alg := range []struct {
+		uri  string
+		hash crypto.Hash
+	}{
+		{SHA3_256RSAMGF1SignatureMethod, crypto.SHA3_256},
+		{SHA3_384RSAMGF1SignatureMethod, crypto.SHA3_384},
+		{SHA3_512RSAMGF1SignatureMethod, crypto.SHA3_512},
+	} {
+		RegisterSignatureAlgorithm(SignatureAlgorithm{
+			URI:                alg.uri,
+			Hash:               alg.hash,
+			PublicKeyAlgorithm: x509.RSA,
+			Signer:             RSAPSS{},
+			Verifier:           RSAPSS{},
+		})
+	}
+
+	for _, alg := range []struct {
+		uri  string
+		hash crypto.Hash
+	}{
+		{ECDSASHA1SignatureMethod, crypto.SHA1},
+		{ECDSASHA224SignatureMethod, crypto.SHA224},
+		{ECDSASHA256SignatureMethod, crypto.SHA256},
+		{ECDSASHA384SignatureMethod, crypto.SHA384},
+		{ECDSASHA512SignatureMethod, crypto.SHA512},
+	} {
+		RegisterSignatureAlgorithm(SignatureAlgorithm{
+			URI:                alg.uri,
+			Hash:               alg.hash,
+			PublicKeyAlgorithm: x509.ECDSA,
+			Signer:             ECDSA{},
+			Verifier:           ECDSA{},
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package dsig
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	customSignatureMethod = "urn:example:rsa-sha256"
+	customDigestMethod    = "urn:example:sha256"
+)
+
+func init() {
+	RegisterSignatureAlgorithm(SignatureAlgorithm{
+		URI:      customSignatureMethod,
+		Hash:     crypto.SHA256,
+		Signer:   RSAPKCS1v15{},
+		Verifier: RSAPKCS1v15{},
+	})
+	RegisterDigestAlgorithm(DigestAlgorithm{
+		URI:  customDigestMethod,
+		Hash: crypto.SHA256,
+	})
+}
+
+func TestAlgorithmPolicyPermits(t *testing.T) {
+	var policy *AlgorithmPolicy
+	require.True(t, policy.Permits(RSASHA1SignatureMethod))
+
+	policy = &AlgorithmPolicy{Disallowed: []string{RSASHA1SignatureMethod}}
+	require.False(t, policy.Permits(RSASHA1SignatureMethod))
+	require.True(t, policy.Permits(RSASHA256SignatureMethod))
+
+	policy = &AlgorithmPolicy{Allowed: []string{RSASHA256SignatureMethod}}
+	require.False(t, policy.Permits(RSASHA1SignatureMethod))
+	require.True(t, policy.Permits(RSASHA256SignatureMethod))
+}
+
+func TestRegisteredSignatureAndDigestMethods(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	// Registering an additional algorithm for a hash must not change the
+	// defaults used for that hash.
+	ctx := NewDefaultSigningContext(ks)
+	require.Equal(t, RSASHA256SignatureMethod, ctx.GetSignatureMethodIdentifier())
+	require.Equal(t, SHA256DigestMethod, ctx.GetDigestAlgorithmIdentifier())
+
+	require.NoError(t, ctx.SetSignatureMethod(customSignatureMethod))
+	require.NoError(t, ctx.SetDigestMethod(customDigestMethod))
+	require.Error(t, ctx.SetDigestMethod("urn:example:unknown"))
+
+	signed, err := ctx.SignEnveloped(etree.NewElement("Root"))
+	require.NoError(t, err)
+	require.Equal(t, customSignatureMethod,
+		signed.FindElement("./Signature/SignedInfo/SignatureMethod").SelectAttrValue(AlgorithmAttr, ""))
+	require.Equal(t, customDigestMethod,
+		signed.FindElement("./Signature/SignedInfo/Reference/DigestMethod").SelectAttrValue(AlgorithmAttr, ""))
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+
+	_, err = vc.Validate(signed)
+	require.NoError(t, err)
+
+	vc.AlgorithmPolicy = &AlgorithmPolicy{Disallowed: []string{customDigestMethod}}
+	_, err = vc.Validate(signed)
+	require.EqualError(t, err, "Digest algorithm not permitted: "+customDigestMethod)
+
+	vc.AlgorithmPolicy = &AlgorithmPolicy{Disallowed: []string{customSignatureMethod}}
+	_, err = vc.Validate(signed)
+	require.EqualError(t, err, "Signature method not permitted: "+customSignatureMethod)
+
+	ctx.AlgorithmPolicy = &AlgorithmPolicy{Allowed: []string{RSASHA256SignatureMethod, SHA256DigestMethod}}
+	_, err = ctx.SignEnveloped(etree.NewElement("Root"))
+	require.Error(t, err)
+}
+
+type signerStore struct {
+	signer crypto.Signer
+	cert   *x509.Certificate
+}
+
+func (s *signerStore) GetKeyPair() (*rsa.PrivateKey, *x509.Certificate, error) {
+	return nil, nil, ErrNonRSAKey
+}
+
+func (s *signerStore) GetSigner() (crypto.Signer, *x509.Certificate, error) {
+	return s.signer, s.cert, nil
+}
+
+func newSignerStore(t *testing.T, signer crypto.Signer) *signerStore {
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    now.Add(-5 * time.Minute),
+		NotAfter:     now.Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, signer.Public(), signer)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &signerStore{signer: signer, cert: cert}
+}
+
+func TestSignatureMethodsRoundTrip(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	require.NoError(t, err)
+
+	rsaStore := newSignerStore(t, rsaKey)
+	ecdsaStore := newSignerStore(t, ecdsaKey)
+
+	for _, tc := range []struct {
+		store           *signerStore
+		signatureMethod string
+		digestMethod    string
+	}{
+		{rsaStore, RSASHA224SignatureMethod, SHA224DigestMethod},
+		{rsaStore, RSASHA384SignatureMethod, SHA384DigestMethod},
+		{rsaStore, RSASHA512SignatureMethod, SHA512DigestMethod},
+		{rsaStore, SHA3_256RSAMGF1SignatureMethod, SHA3_256DigestMethod},
+		{rsaStore, SHA3_384RSAMGF1SignatureMethod, SHA3_384DigestMethod},
+		{rsaStore, SHA3_512RSAMGF1SignatureMethod, SHA3_512DigestMethod},
+		{ecdsaStore, ECDSASHA1SignatureMethod, SHA1DigestMethod},
+		{ecdsaStore, ECDSASHA256SignatureMethod, SHA256DigestMethod},
+		{ecdsaStore, ECDSASHA384SignatureMethod, SHA384DigestMethod},
+		{ecdsaStore, ECDSASHA512SignatureMethod, SHA512DigestMethod},
+	} {
+		ctx := NewDefaultSigningContext(tc.store)
+		require.NoError(t, ctx.SetSignatureMethod(tc.signatureMethod))
+		require.Equal(t, tc.digestMethod, ctx.GetDigestAlgorithmIdentifier())
+
+		signed, err := ctx.SignEnveloped(etree.NewElement("Root"))
+		require.NoError(t, err, tc.signatureMethod)
+		require.Equal(t, tc.signatureMethod,
+			signed.FindElement("./Signature/SignedInfo/SignatureMethod").SelectAttrValue(AlgorithmAttr, ""))
+
+		vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+			Roots: []*x509.Certificate{tc.store.cert},
+		})
+
+		_, err = vc.Validate(signed)
+		require.NoError(t, err, tc.signatureMethod)
+	}
+}
+
+func TestDefaultSignatureMethodForKey(t *testing.T) {
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	ctx := NewDefaultSigningContext(newSignerStore(t, ecdsaKey))
+	require.Equal(t, ECDSASHA256SignatureMethod, ctx.GetSignatureMethodIdentifier())
+
+	ctx = NewDefaultSigningContext(RandomKeyStoreForTest())
+	ctx.Hash = crypto.SHA384
+	require.Equal(t, RSASHA384SignatureMethod, ctx.GetSignatureMethodIdentifier())
+	require.Equal(t, SHA384DigestMethod, ctx.GetDigestAlgorithmIdentifier())
+}
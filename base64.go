@@ -0,0 +1,47 @@
+package dsig
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+// base64LineLength is the line length of wrapped base64 values, as in MIME.
+const base64LineLength = 76
+
+// dropBase64Whitespace is a mapping for strings.Map and bytes.Map which
+// removes the whitespace permitted within xs:base64Binary values.
+func dropBase64Whitespace(r rune) rune {
+	switch r {
+	case ' ', '\t', '\r', '\n':
+		return -1
+	}
+	return r
+}
+
+// decodeBase64Binary decodes an xs:base64Binary value, such as a
+// DigestValue, SignatureValue or X509Certificate. These may contain
+// whitespace anywhere, and are commonly wrapped over several lines.
+func decodeBase64Binary(s string) ([]byte, error) {
+	return base64.StdEncoding.DecodeString(strings.Map(dropBase64Whitespace, s))
+}
+
+// encodeBase64 encodes b for a base64Binary value, wrapping it over lines of
+// base64LineLength characters if the context's WrapBase64 is set.
+func (ctx *SigningContext) encodeBase64(b []byte) string {
+	encoded := base64.StdEncoding.EncodeToString(b)
+	if !ctx.WrapBase64 || len(encoded) <= base64LineLength {
+		return encoded
+	}
+
+	var wrapped strings.Builder
+	wrapped.Grow(len(encoded) + len(encoded)/base64LineLength)
+
+	for len(encoded) > base64LineLength {
+		wrapped.WriteString(encoded[:base64LineLength])
+		wrapped.WriteByte('\n')
+		encoded = encoded[base64LineLength:]
+	}
+	wrapped.WriteString(encoded)
+
+	return wrapped.String()
+}
@@ -0,0 +1,65 @@
+package dsig
+
+import (
+	"crypto/x509"
+	"strings"
+	"testing"
+
+	"github.com/beevik/etree"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeBase64BinaryWhitespace(t *testing.T) {
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(kycResp))
+
+	sig, err := NewKYCValidationContext(nil).findSignature(doc.Root())
+	require.NoError(t, err)
+
+	digest, err := decodeBase64Binary(sig.SignedInfo.References[0].DigestValue)
+	require.NoError(t, err)
+	require.Len(t, digest, 20)
+
+	signature, err := decodeBase64Binary(sig.SignatureValue.Data)
+	require.NoError(t, err)
+	require.Len(t, signature, 256)
+
+	decoded, err := decodeBase64Binary(" aGVs\r\nbG8g\td29y bGQ= \n")
+	require.NoError(t, err)
+	require.Equal(t, "hello world", string(decoded))
+
+	_, err = decodeBase64Binary("aGVs\vbG8=")
+	require.Error(t, err)
+}
+
+func TestSignWrapBase64(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	ctx := NewDefaultSigningContext(ks)
+	ctx.WrapBase64 = true
+
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(`<Root><Child/></Root>`))
+
+	signed, err := ctx.SignEnveloped(doc.Root())
+	require.NoError(t, err)
+
+	for _, tag := range []string{SignatureValueTag, X509CertificateTag} {
+		value := signed.FindElement("//" + tag).Text()
+		lines := strings.Split(value, "\n")
+		require.True(t, len(lines) > 1, tag)
+		for _, line := range lines[:len(lines)-1] {
+			require.Len(t, line, 76, tag)
+		}
+		require.True(t, len(lines[len(lines)-1]) <= 76, tag)
+	}
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+
+	_, err = vc.Validate(signed)
+	require.NoError(t, err)
+}
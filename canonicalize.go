@@ -1,10 +1,14 @@
 package dsig
 
 import (
+	"bufio"
+	"bytes"
+	"io"
 	"sort"
+	"unicode/utf8"
 
 	"github.com/beevik/etree"
-	"github.com/russellhaering/goxmldsig/etreeutils"
+	"gitlab.com/moolekkari/goxmldsig/etreeutils"
 )
 
 // Canonicalizer is an implementation of a canonicalization algorithm.
@@ -13,8 +17,37 @@ type Canonicalizer interface {
 	Algorithm() AlgorithmID
 }
 
+// StreamingCanonicalizer is implemented by Canonicalizers which can write the
+// canonical form of an element directly to an io.Writer, such as a
+// hash.Hash, without building a canonicalized copy of the tree. All of the
+// built in canonicalizers implement it.
+type StreamingCanonicalizer interface {
+	Canonicalizer
+
+	// CanonicalizeTo writes the canonical form of el to w. Unlike
+	// Canonicalize, it never modifies el.
+	CanonicalizeTo(w io.Writer, el *etree.Element) error
+}
+
+// canonicalizeTo writes the canonical form of el to w, without copying el if
+// c is a StreamingCanonicalizer.
+func canonicalizeTo(w io.Writer, c Canonicalizer, el *etree.Element) error {
+	if sc, ok := c.(StreamingCanonicalizer); ok {
+		return sc.CanonicalizeTo(w, el)
+	}
+
+	canonical, err := c.Canonicalize(el)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(canonical)
+	return err
+}
+
 type c14N10ExclusiveCanonicalizer struct {
 	prefixList string
+	comments   bool
 }
 
 // MakeC14N10ExclusiveCanonicalizerWithPrefixList constructs an exclusive Canonicalizer
@@ -25,8 +58,21 @@ func MakeC14N10ExclusiveCanonicalizerWithPrefixList(prefixList string) Canonical
 	}
 }
 
+// MakeC14N10ExclusiveWithCommentsCanonicalizerWithPrefixList constructs an exclusive
+// Canonicalizer which preserves comments, from a PrefixList in NMTOKENS format.
+func MakeC14N10ExclusiveWithCommentsCanonicalizerWithPrefixList(prefixList string) Canonicalizer {
+	return &c14N10ExclusiveCanonicalizer{
+		prefixList: prefixList,
+		comments:   true,
+	}
+}
+
 // Canonicalize transforms the input Element into a serialized XML document in canonical form.
 func (c *c14N10ExclusiveCanonicalizer) Canonicalize(el *etree.Element) ([]byte, error) {
+	if !c.comments {
+		removeComments(el)
+	}
+
 	err := etreeutils.TransformExcC14n(el, c.prefixList)
 	if err != nil {
 		return nil, err
@@ -35,24 +81,56 @@ func (c *c14N10ExclusiveCanonicalizer) Canonicalize(el *etree.Element) ([]byte,
 	return canonicalSerialize(el)
 }
 
+// CanonicalizeTo writes the input Element to w in canonical form.
+func (c *c14N10ExclusiveCanonicalizer) CanonicalizeTo(w io.Writer, el *etree.Element) error {
+	cw := newCanonicalWriter(w, c.comments)
+
+	r := etreeutils.NewExcC14nRenderer(c.prefixList)
+	err := cw.writeExclusive(r, etreeutils.DefaultNSContext, etreeutils.DefaultNSContext, el)
+	if err != nil {
+		return err
+	}
+
+	return cw.w.Flush()
+}
+
 func (c *c14N10ExclusiveCanonicalizer) Algorithm() AlgorithmID {
+	if c.comments {
+		return CanonicalXML10ExclusiveWithCommentsAlgorithmID
+	}
 	return CanonicalXML10ExclusiveAlgorithmID
 }
 
-type c14N11Canonicalizer struct{}
+type c14N11Canonicalizer struct {
+	comments bool
+}
 
 // MakeC14N11Canonicalizer constructs an inclusive canonicalizer.
 func MakeC14N11Canonicalizer() Canonicalizer {
 	return &c14N11Canonicalizer{}
 }
 
+// MakeC14N11WithCommentsCanonicalizer constructs an inclusive canonicalizer
+// which preserves comments.
+func MakeC14N11WithCommentsCanonicalizer() Canonicalizer {
+	return &c14N11Canonicalizer{comments: true}
+}
+
 // Canonicalize transforms the input Element into a serialized XML document in canonical form.
 func (c *c14N11Canonicalizer) Canonicalize(el *etree.Element) ([]byte, error) {
 	scope := make(map[string]struct{})
-	return canonicalSerialize(canonicalPrep(el, scope))
+	return canonicalSerialize(canonicalPrep(el, scope, c.comments))
+}
+
+// CanonicalizeTo writes the input Element to w in canonical form.
+func (c *c14N11Canonicalizer) CanonicalizeTo(w io.Writer, el *etree.Element) error {
+	return writeInclusive(w, el, c.comments)
 }
 
 func (c *c14N11Canonicalizer) Algorithm() AlgorithmID {
+	if c.comments {
+		return CanonicalXML11WithCommentsAlgorithmID
+	}
 	return CanonicalXML11AlgorithmID
 }
 
@@ -66,7 +144,12 @@ func MakeC14N10RecCanonicalizer() Canonicalizer {
 // Canonicalize transforms the input Element into a serialized XML document in canonical form.
 func (c *c14N10RecCanonicalizer) Canonicalize(el *etree.Element) ([]byte, error) {
 	scope := make(map[string]struct{})
-	return canonicalSerialize(canonicalPrep(el, scope))
+	return canonicalSerialize(canonicalPrep(el, scope, false))
+}
+
+// CanonicalizeTo writes the input Element to w in canonical form.
+func (c *c14N10RecCanonicalizer) CanonicalizeTo(w io.Writer, el *etree.Element) error {
+	return writeInclusive(w, el, false)
 }
 
 func (c *c14N10RecCanonicalizer) Algorithm() AlgorithmID {
@@ -75,7 +158,8 @@ func (c *c14N10RecCanonicalizer) Algorithm() AlgorithmID {
 
 type c14N10CommentCanonicalizer struct{}
 
-// MakeC14N10CommentCanonicalizer constructs an inclusive canonicalizer.
+// MakeC14N10CommentCanonicalizer constructs an inclusive canonicalizer which
+// preserves comments.
 func MakeC14N10CommentCanonicalizer() Canonicalizer {
 	return &c14N10CommentCanonicalizer{}
 }
@@ -83,7 +167,12 @@ func MakeC14N10CommentCanonicalizer() Canonicalizer {
 // Canonicalize transforms the input Element into a serialized XML document in canonical form.
 func (c *c14N10CommentCanonicalizer) Canonicalize(el *etree.Element) ([]byte, error) {
 	scope := make(map[string]struct{})
-	return canonicalSerialize(canonicalPrep(el, scope))
+	return canonicalSerialize(canonicalPrep(el, scope, true))
+}
+
+// CanonicalizeTo writes the input Element to w in canonical form.
+func (c *c14N10CommentCanonicalizer) CanonicalizeTo(w io.Writer, el *etree.Element) error {
+	return writeInclusive(w, el, true)
 }
 
 func (c *c14N10CommentCanonicalizer) Algorithm() AlgorithmID {
@@ -111,12 +200,13 @@ const nsSpace = "xmlns"
 //
 // 1. Stripping re-declarations of namespaces
 // 2. Sorting attributes into canonical order
+// 3. Stripping comments, unless comments is set
 //
 // Inclusive canonicalization does not strip unused namespaces.
 //
 // TODO(russell_h): This is very similar to excCanonicalPrep - perhaps they should
 // be unified into one parameterized function?
-func canonicalPrep(el *etree.Element, seenSoFar map[string]struct{}) *etree.Element {
+func canonicalPrep(el *etree.Element, seenSoFar map[string]struct{}, comments bool) *etree.Element {
 	_seenSoFar := make(map[string]struct{})
 	for k, v := range seenSoFar {
 		_seenSoFar[k] = v
@@ -138,16 +228,34 @@ func canonicalPrep(el *etree.Element, seenSoFar map[string]struct{}) *etree.Elem
 		}
 	}
 
+	if !comments {
+		removeComments(ne)
+	}
+
 	for i, token := range ne.Child {
 		childElement, ok := token.(*etree.Element)
 		if ok {
-			ne.Child[i] = canonicalPrep(childElement, _seenSoFar)
+			ne.Child[i] = canonicalPrep(childElement, _seenSoFar, comments)
 		}
 	}
 
 	return ne
 }
 
+// removeComments strips all comments from el and its descendants in place.
+func removeComments(el *etree.Element) {
+	for i := 0; i < len(el.Child); {
+		switch token := el.Child[i].(type) {
+		case *etree.Comment:
+			el.RemoveChildAt(i)
+			continue
+		case *etree.Element:
+			removeComments(token)
+		}
+		i++
+	}
+}
+
 func canonicalSerialize(el *etree.Element) ([]byte, error) {
 	doc := etree.NewDocument()
 	doc.SetRoot(el.Copy())
@@ -160,3 +268,385 @@ func canonicalSerialize(el *etree.Element) ([]byte, error) {
 
 	return doc.WriteToBytes()
 }
+
+// canonicalWriter serializes element trees in canonical form, producing the
+// same output as canonicalSerialize would for a tree prepared by
+// canonicalPrep or etreeutils.TransformExcC14n.
+type canonicalWriter struct {
+	w        *bufio.Writer
+	comments bool
+
+	// attrs is scratch space for the attributes of the element being
+	// written. It is reused for every element, as an element's attributes
+	// are written before any of its children are visited.
+	attrs []etree.Attr
+}
+
+func newCanonicalWriter(w io.Writer, comments bool) *canonicalWriter {
+	return &canonicalWriter{
+		w:        bufio.NewWriter(w),
+		comments: comments,
+	}
+}
+
+// writeInclusive writes el to w in inclusive canonical form.
+func writeInclusive(w io.Writer, el *etree.Element, comments bool) error {
+	cw := newCanonicalWriter(w, comments)
+	cw.writeInclusive(el, map[string]struct{}{})
+	return cw.w.Flush()
+}
+
+// writeInclusive writes el in inclusive canonical form. seen holds the
+// prefixes whose declarations have been written by el's ancestors;
+// redeclarations of those prefixes are dropped, as by canonicalPrep.
+func (cw *canonicalWriter) writeInclusive(el *etree.Element, seen map[string]struct{}) {
+	declared := cw.startInclusive(el, seen, nil)
+
+	for _, token := range el.Child {
+		if child, ok := token.(*etree.Element); ok {
+			cw.writeInclusive(child, seen)
+			continue
+		}
+		cw.writeToken(token)
+	}
+
+	cw.writeEndTag(el)
+
+	for _, prefix := range declared {
+		delete(seen, prefix)
+	}
+}
+
+// startInclusive writes the start tag of el in inclusive canonical form. The
+// prefixes it adds to seen are appended to declared and returned; they must
+// be removed from seen once el ends.
+func (cw *canonicalWriter) startInclusive(el *etree.Element, seen map[string]struct{}, declared []string) []string {
+	attrs := append(cw.attrs[:0], el.Attr...)
+	if len(attrs) > 1 {
+		sort.Sort(etreeutils.SortedAttrs(attrs))
+	}
+	cw.attrs = attrs
+
+	cw.w.WriteByte('<')
+	cw.writeName(el.Space, el.Tag)
+	for _, attr := range attrs {
+		if attr.Space == nsSpace {
+			if _, ok := seen[attr.Key]; ok {
+				continue
+			}
+			seen[attr.Key] = struct{}{}
+			declared = append(declared, attr.Key)
+		}
+		cw.writeAttr(attr)
+	}
+	cw.w.WriteByte('>')
+
+	return declared
+}
+
+// writeExclusive writes el in exclusive canonical form, with the namespace
+// contexts described by etreeutils.ExcC14nRenderer.
+func (cw *canonicalWriter) writeExclusive(r *etreeutils.ExcC14nRenderer, ctx, declared etreeutils.NSContext, el *etree.Element) error {
+	scope, declared, err := cw.startExclusive(r, ctx, declared, el)
+	if err != nil {
+		return err
+	}
+
+	for _, token := range el.Child {
+		if child, ok := token.(*etree.Element); ok {
+			err := cw.writeExclusive(r, scope, declared, child)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		cw.writeToken(token)
+	}
+
+	cw.writeEndTag(el)
+
+	return nil
+}
+
+// startExclusive writes the start tag of el in exclusive canonical form, and
+// returns the contexts for its children.
+func (cw *canonicalWriter) startExclusive(r *etreeutils.ExcC14nRenderer, ctx, declared etreeutils.NSContext, el *etree.Element) (etreeutils.NSContext, etreeutils.NSContext, error) {
+	attrs, scope, declared, err := r.Attrs(cw.attrs[:0], ctx, declared, el)
+	if err != nil {
+		return ctx, declared, err
+	}
+	cw.attrs = attrs
+
+	cw.w.WriteByte('<')
+	cw.writeName(el.Space, el.Tag)
+	for _, attr := range attrs {
+		cw.writeAttr(attr)
+	}
+	cw.w.WriteByte('>')
+
+	return scope, declared, nil
+}
+
+func (cw *canonicalWriter) writeAttr(attr etree.Attr) {
+	cw.w.WriteByte(' ')
+	cw.writeName(attr.Space, attr.Key)
+	cw.w.WriteString(`="`)
+	cw.writeEscaped(attr.Value, true)
+	cw.w.WriteByte('"')
+}
+
+func (cw *canonicalWriter) writeEndTag(el *etree.Element) {
+	cw.w.WriteString("</")
+	cw.writeName(el.Space, el.Tag)
+	cw.w.WriteByte('>')
+}
+
+// writeName writes a possibly prefixed name, without building the composed
+// string as FullTag and FullKey do.
+func (cw *canonicalWriter) writeName(space, local string) {
+	if space != "" {
+		cw.w.WriteString(space)
+		cw.w.WriteByte(':')
+	}
+	cw.w.WriteString(local)
+}
+
+// writeToken writes a token other than an element.
+func (cw *canonicalWriter) writeToken(token etree.Token) {
+	switch token := token.(type) {
+	case *etree.CharData:
+		if token.IsCData() {
+			cw.w.WriteString("<![CDATA[")
+			cw.w.WriteString(token.Data)
+			cw.w.WriteString("]]>")
+		} else {
+			cw.writeEscaped(token.Data, false)
+		}
+
+	case *etree.Comment:
+		if cw.comments {
+			cw.w.WriteString("<!--")
+			cw.w.WriteString(token.Data)
+			cw.w.WriteString("-->")
+		}
+
+	case *etree.ProcInst:
+		cw.w.WriteString("<?")
+		cw.w.WriteString(token.Target)
+		if token.Inst != "" {
+			cw.w.WriteByte(' ')
+			cw.w.WriteString(token.Inst)
+		}
+		cw.w.WriteString("?>")
+
+	case *etree.Directive:
+		cw.w.WriteString("<!")
+		cw.w.WriteString(token.Data)
+		cw.w.WriteByte('>')
+	}
+}
+
+// writeEscaped writes s with the character references used by etree's
+// canonical attribute value or text output.
+func (cw *canonicalWriter) writeEscaped(s string, attr bool) {
+	last := 0
+	for i := 0; i < len(s); {
+		r, width := utf8.DecodeRuneInString(s[i:])
+		i += width
+
+		var esc string
+		switch r {
+		case '&':
+			esc = "&amp;"
+		case '<':
+			esc = "&lt;"
+		case '>':
+			if attr {
+				continue
+			}
+			esc = "&gt;"
+		case '"':
+			if !attr {
+				continue
+			}
+			esc = "&quot;"
+		case '\t':
+			if !attr {
+				continue
+			}
+			esc = "&#x9;"
+		case '\n':
+			if !attr {
+				continue
+			}
+			esc = "&#xA;"
+		case '\r':
+			esc = "&#xD;"
+		default:
+			if !isInCharacterRange(r) || (r == utf8.RuneError && width == 1) {
+				esc = "\uFFFD"
+				break
+			}
+			continue
+		}
+
+		cw.w.WriteString(s[last : i-width])
+		cw.w.WriteString(esc)
+		last = i
+	}
+	cw.w.WriteString(s[last:])
+}
+
+func isInCharacterRange(r rune) bool {
+	return r == 0x09 ||
+		r == 0x0A ||
+		r == 0x0D ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0x10FFFF
+}
+
+// preservesComments reports whether c is one of the #WithComments
+// canonicalization algorithms.
+func preservesComments(c Canonicalizer) bool {
+	switch c.Algorithm() {
+	case CanonicalXML10ExclusiveWithCommentsAlgorithmID,
+		CanonicalXML11WithCommentsAlgorithmID,
+		CanonicalXML10CommentAlgorithmID:
+		return true
+	}
+	return false
+}
+
+// canonicalizeData returns the octets making up data: an octet stream as is,
+// or the canonical form of a node-set.
+func canonicalizeData(c Canonicalizer, data *TransformData) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeCanonicalData(&buf, c, data); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeCanonicalData writes the octets making up data to w.
+func writeCanonicalData(w io.Writer, c Canonicalizer, data *TransformData) error {
+	if data.IsOctetStream() {
+		_, err := w.Write(data.Octets)
+		return err
+	}
+
+	return writeCanonicalNodeSet(w, c, data.NodeSet)
+}
+
+// canonicalizeNodeSet canonicalizes a node-set.
+func canonicalizeNodeSet(c Canonicalizer, ns *etreeutils.NodeSet) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := writeCanonicalNodeSet(&buf, c, ns); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeCanonicalNodeSet writes the canonical form of a node-set to w.
+// Node-sets which are a single subtree are passed to the Canonicalizer as
+// is. Otherwise each of the top level nodes of the set is canonicalized in
+// turn, and the results are concatenated, as for a document subset.
+func writeCanonicalNodeSet(w io.Writer, c Canonicalizer, ns *etreeutils.NodeSet) error {
+	if ns.Root == nil {
+		return nil
+	}
+
+	if ns.IsSubtree() {
+		return canonicalizeTo(w, c, ns.Root)
+	}
+
+	nsCtx, err := etreeutils.NSBuildParentContext(ns.Root)
+	if err != nil {
+		return err
+	}
+
+	cw := newCanonicalWriter(w, preservesComments(c))
+
+	for _, token := range nodeSetTokens(ns.Root, ns.Omitted) {
+		if el, ok := token.(*etree.Element); ok {
+			detached, err := etreeutils.NSDetatch(nsCtx, el)
+			if err != nil {
+				return err
+			}
+
+			// Flush the text written so far, as the element is written
+			// to w directly.
+			if err := cw.w.Flush(); err != nil {
+				return err
+			}
+
+			if err := canonicalizeTo(w, c, detached); err != nil {
+				return err
+			}
+			continue
+		}
+
+		cw.writeToken(token)
+	}
+
+	return cw.w.Flush()
+}
+
+// nodeSetTokens returns copies of the nodes of the node-set rooted at el.
+// Omitted elements are replaced by their content, which inherits their
+// namespace declarations.
+func nodeSetTokens(el *etree.Element, omitted map[*etree.Element]bool) []etree.Token {
+	var children []etree.Token
+
+	for _, token := range el.Child {
+		switch token := token.(type) {
+		case *etree.Element:
+			children = append(children, nodeSetTokens(token, omitted)...)
+		case *etree.CharData:
+			if token.IsCData() {
+				children = append(children, etree.NewCData(token.Data))
+			} else {
+				children = append(children, etree.NewText(token.Data))
+			}
+		case *etree.Comment:
+			children = append(children, etree.NewComment(token.Data))
+		case *etree.ProcInst:
+			children = append(children, etree.NewProcInst(token.Target, token.Inst))
+		}
+	}
+
+	if !omitted[el] {
+		ne := etree.NewElement(el.Tag)
+		ne.Space = el.Space
+		ne.Attr = append([]etree.Attr(nil), el.Attr...)
+		for _, child := range children {
+			ne.AddChild(child)
+		}
+		return []etree.Token{ne}
+	}
+
+	for _, child := range children {
+		if childElement, ok := child.(*etree.Element); ok {
+			inheritNamespaceDeclarations(childElement, el)
+		}
+	}
+
+	return children
+}
+
+// inheritNamespaceDeclarations copies the namespace declarations of parent
+// which are not overridden by el onto el.
+func inheritNamespaceDeclarations(el, parent *etree.Element) {
+	for _, attr := range parent.Attr {
+		isDeclaration := attr.Space == nsSpace || (attr.Space == "" && attr.Key == nsSpace)
+		if !isDeclaration {
+			continue
+		}
+
+		if el.SelectAttr(composeAttr(attr.Space, attr.Key)) == nil {
+			el.Attr = append(el.Attr, attr)
+		}
+	}
+}
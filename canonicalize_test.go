@@ -1,6 +1,10 @@
 package dsig
 
 import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
 	"testing"
 
 	"github.com/beevik/etree"
@@ -27,6 +31,16 @@ func runCanonicalizationTest(t *testing.T, canonicalizer Canonicalizer, xmlstr s
 	canonicalized, err := canonicalizer.Canonicalize(raw.Root())
 	require.NoError(t, err)
 	require.Equal(t, canonicalXmlstr, string(canonicalized))
+
+	// The streaming path must produce the same output.
+	raw = etree.NewDocument()
+	err = raw.ReadFromString(xmlstr)
+	require.NoError(t, err)
+
+	var buf bytes.Buffer
+	err = canonicalizer.(StreamingCanonicalizer).CanonicalizeTo(&buf, raw.Root())
+	require.NoError(t, err)
+	require.Equal(t, canonicalXmlstr, buf.String())
 }
 
 func TestExcC14N10(t *testing.T) {
@@ -64,3 +78,115 @@ func TestExcC14nRedeclareDefaultNamespace(t *testing.T) {
 	canonicalizer := MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
 	runCanonicalizationTest(t, canonicalizer, input, expected)
 }
+
+const (
+	commentedXmldoc                 = `<Foo xmlns="urn:foo"><!-- first --><Bar>baz<!-- second --></Bar></Foo>`
+	commentedXmldocWithoutComments  = `<Foo xmlns="urn:foo"><Bar>baz</Bar></Foo>`
+	commentedXmldocWithCommentsKept = `<Foo xmlns="urn:foo"><!-- first --><Bar>baz<!-- second --></Bar></Foo>`
+)
+
+func TestCanonicalizationComments(t *testing.T) {
+	runCanonicalizationTest(t, MakeC14N10ExclusiveCanonicalizerWithPrefixList(""), commentedXmldoc, commentedXmldocWithoutComments)
+	runCanonicalizationTest(t, MakeC14N10ExclusiveWithCommentsCanonicalizerWithPrefixList(""), commentedXmldoc, commentedXmldocWithCommentsKept)
+	runCanonicalizationTest(t, MakeC14N11Canonicalizer(), commentedXmldoc, commentedXmldocWithoutComments)
+	runCanonicalizationTest(t, MakeC14N11WithCommentsCanonicalizer(), commentedXmldoc, commentedXmldocWithCommentsKept)
+	runCanonicalizationTest(t, MakeC14N10RecCanonicalizer(), commentedXmldoc, commentedXmldocWithoutComments)
+	runCanonicalizationTest(t, MakeC14N10CommentCanonicalizer(), commentedXmldoc, commentedXmldocWithCommentsKept)
+}
+
+func TestCanonicalizeToEscaping(t *testing.T) {
+	input := "<a:Root xmlns:a=\"urn:a\" xmlns:b=\"urn:b\" z=\"&lt;&gt;&amp;&quot;'&#9;&#10;&#13;\" b:y=\"1\">" +
+		"<a:Child xmlns:a=\"urn:other\">text &lt;&gt;&amp;\"' &#13;</a:Child>" +
+		"<![CDATA[<raw>]]><?pi data?><!-- comment --><b:Empty/></a:Root>"
+
+	for _, canonicalizer := range []Canonicalizer{
+		MakeC14N10ExclusiveCanonicalizerWithPrefixList(""),
+		MakeC14N10ExclusiveCanonicalizerWithPrefixList("b"),
+		MakeC14N10ExclusiveWithCommentsCanonicalizerWithPrefixList(""),
+		MakeC14N11Canonicalizer(),
+		MakeC14N11WithCommentsCanonicalizer(),
+		MakeC14N10RecCanonicalizer(),
+		MakeC14N10CommentCanonicalizer(),
+	} {
+		raw := etree.NewDocument()
+		require.NoError(t, raw.ReadFromString(input))
+
+		var buf bytes.Buffer
+		err := canonicalizer.(StreamingCanonicalizer).CanonicalizeTo(&buf, raw.Root())
+		require.NoError(t, err)
+
+		// CanonicalizeTo leaves the element unmodified.
+		unmodified, err := raw.WriteToString()
+		require.NoError(t, err)
+
+		expected, err := canonicalizer.Canonicalize(raw.Root())
+		require.NoError(t, err)
+		require.Equal(t, string(expected), buf.String(), canonicalizer.Algorithm())
+
+		reparsed := etree.NewDocument()
+		require.NoError(t, reparsed.ReadFromString(input))
+		original, err := reparsed.WriteToString()
+		require.NoError(t, err)
+		require.Equal(t, original, unmodified)
+	}
+}
+
+// largeDocument builds a document with n records, similar in shape to a
+// bulk KYC upload.
+func largeDocument(n int) *etree.Element {
+	var b strings.Builder
+	b.WriteString(`<b:Batch xmlns:b="urn:batch" xmlns:k="urn:kyc">`)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&b, `<k:Record ID="r%d" k:type="individual"><k:Name>Name %d</k:Name><k:Address>%d Main Street &amp; Co</k:Address><k:Document k:kind="PAN">ABCDE%04dF</k:Document></k:Record>`, i, i, i, i)
+	}
+	b.WriteString(`</b:Batch>`)
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(b.String()); err != nil {
+		panic(err)
+	}
+
+	return doc.Root()
+}
+
+func benchmarkCanonicalizers() map[string]Canonicalizer {
+	return map[string]Canonicalizer{
+		"ExcC14N10": MakeC14N10ExclusiveCanonicalizerWithPrefixList(""),
+		"C14N11":    MakeC14N11Canonicalizer(),
+	}
+}
+
+func BenchmarkCanonicalize(b *testing.B) {
+	el := largeDocument(5000)
+
+	for name, canonicalizer := range benchmarkCanonicalizers() {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// Canonicalize may modify its input.
+				_, err := canonicalizer.Canonicalize(el.Copy())
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCanonicalizeTo(b *testing.B) {
+	el := largeDocument(5000)
+
+	for name, canonicalizer := range benchmarkCanonicalizers() {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				err := canonicalizer.(StreamingCanonicalizer).CanonicalizeTo(io.Discard, el)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
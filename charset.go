@@ -0,0 +1,263 @@
+package dsig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrUnsupportedEncoding indicates that a document declares an encoding
+// which cannot be converted to UTF-8 for canonicalization.
+type ErrUnsupportedEncoding struct {
+	Encoding string
+}
+
+func (e ErrUnsupportedEncoding) Error() string {
+	return "Unsupported document encoding: " + e.Encoding
+}
+
+// charset maps each byte of a single byte encoding to the character it
+// encodes.
+type charset [256]rune
+
+var latin1 = func() *charset {
+	var cs charset
+	for b := range cs {
+		cs[b] = rune(b)
+	}
+	return &cs
+}()
+
+// windows1252 differs from ISO-8859-1 in the range 0x80-0x9F. Its five
+// unassigned bytes map to the C1 control characters, as in the WHATWG
+// Encoding Standard.
+var windows1252 = func() *charset {
+	cs := *latin1
+	for b, r := range map[byte]rune{
+		0x80: '€', 0x82: '‚', 0x83: 'ƒ', 0x84: '„',
+		0x85: '…', 0x86: '†', 0x87: '‡', 0x88: 'ˆ',
+		0x89: '‰', 0x8A: 'Š', 0x8B: '‹', 0x8C: 'Œ',
+		0x8E: 'Ž', 0x91: '‘', 0x92: '’', 0x93: '“',
+		0x94: '”', 0x95: '•', 0x96: '–', 0x97: '—',
+		0x98: '˜', 0x99: '™', 0x9A: 'š', 0x9B: '›',
+		0x9C: 'œ', 0x9E: 'ž', 0x9F: 'Ÿ',
+	} {
+		cs[b] = r
+	}
+	return &cs
+}()
+
+// lookupCharset returns the charset for an encoding label, or nil for UTF-8
+// and its subsets, which need no conversion.
+func lookupCharset(label string) (*charset, error) {
+	switch strings.ToLower(label) {
+	case "", "utf-8", "utf8", "us-ascii", "ascii":
+		return nil, nil
+	case "iso-8859-1", "iso8859-1", "iso_8859-1", "latin1", "l1":
+		return latin1, nil
+	case "windows-1252", "cp1252", "x-cp1252":
+		return windows1252, nil
+	}
+	return nil, ErrUnsupportedEncoding{Encoding: label}
+}
+
+// charsetReader converts documents declaring the passed encoding to UTF-8,
+// for use as the CharsetReader of an xml.Decoder.
+func charsetReader(label string, input io.Reader) (io.Reader, error) {
+	cs, err := lookupCharset(label)
+	if err != nil {
+		return nil, err
+	}
+
+	if cs == nil {
+		return input, nil
+	}
+
+	return &charsetDecoder{r: input, cs: cs}, nil
+}
+
+// charsetDecoder reads text in a single byte encoding as UTF-8.
+type charsetDecoder struct {
+	r       io.Reader
+	cs      *charset
+	raw     [1024]byte
+	buf     []byte
+	pending []byte
+	err     error
+}
+
+func (d *charsetDecoder) Read(p []byte) (int, error) {
+	if len(d.pending) == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+
+		n, err := d.r.Read(d.raw[:])
+		d.err = err
+
+		d.buf = d.buf[:0]
+		for _, b := range d.raw[:n] {
+			d.buf = utf8.AppendRune(d.buf, d.cs[b])
+		}
+		d.pending = d.buf
+
+		if n == 0 {
+			return 0, err
+		}
+	}
+
+	n := copy(p, d.pending)
+	d.pending = d.pending[n:]
+	return n, nil
+}
+
+var encodingDeclaration = regexp.MustCompile(`^<\?xml[ \t\r\n][^?]*?encoding[ \t\r\n]*=[ \t\r\n]*["']([A-Za-z][A-Za-z0-9._-]*)["']`)
+
+// wideEncodings maps the byte order marks of UTF-16 and UTF-32, and the
+// encoded start of a document without one, to the name of the encoding. As
+// these encodings are not ASCII compatible, their XML declaration can not
+// be read as that of the others.
+var wideEncodings = []struct {
+	prefix   string
+	encoding string
+}{
+	{"\x00\x00\xfe\xff", "UTF-32"},
+	{"\xff\xfe\x00\x00", "UTF-32"},
+	{"\x00\x00\x00<", "UTF-32"},
+	{"<\x00\x00\x00", "UTF-32"},
+	{"\xfe\xff", "UTF-16"},
+	{"\xff\xfe", "UTF-16"},
+	{"\x00<\x00?", "UTF-16"},
+	{"<\x00?\x00", "UTF-16"},
+}
+
+// documentCharset returns the charset of the encoding named by the XML
+// declaration of data, or nil if data is in UTF-8.
+func documentCharset(data []byte) (*charset, error) {
+	for _, wide := range wideEncodings {
+		if bytes.HasPrefix(data, []byte(wide.prefix)) {
+			return nil, ErrUnsupportedEncoding{Encoding: wide.encoding}
+		}
+	}
+
+	var label string
+	if match := encodingDeclaration.FindSubmatch(data); match != nil {
+		label = string(match[1])
+	}
+
+	return lookupCharset(label)
+}
+
+// transcodeDocument converts data to UTF-8 according to the encoding named
+// by its XML declaration. cs is the charset data was converted from, in
+// which case every byte of data became one character of the result, or nil
+// if data is in UTF-8.
+func transcodeDocument(data []byte) (utf8Data []byte, cs *charset, err error) {
+	cs, err = documentCharset(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if cs == nil {
+		return data, nil, nil
+	}
+
+	utf8Data = make([]byte, 0, len(data)+len(data)/4)
+	for _, b := range data {
+		utf8Data = utf8.AppendRune(utf8Data, cs[b])
+	}
+
+	return utf8Data, cs, nil
+}
+
+// ErrUnrepresentableCharacter indicates that markup to be inserted into a
+// document contains a character which the encoding of the document can not
+// represent where character references are not allowed, such as in an
+// element name or a comment.
+var ErrUnrepresentableCharacter = errors.New("Character can not be represented in the document encoding")
+
+// encode converts data, well-formed XML content in UTF-8, to cs. Characters
+// which cs can not represent are written as character references within
+// text and attribute values, and fail with ErrUnrepresentableCharacter
+// elsewhere.
+func (cs *charset) encode(data []byte) ([]byte, error) {
+	bytesOf := make(map[rune]byte, len(cs))
+	for b := len(cs) - 1; b >= 0; b-- {
+		bytesOf[cs[b]] = byte(b)
+	}
+
+	const (
+		inText = iota
+		inTag
+		inAttrValue
+		inOther
+	)
+
+	var (
+		state = inText
+		quote rune
+		end   string
+	)
+
+	out := make([]byte, 0, len(data))
+	for i := 0; i < len(data); {
+		r, size := utf8.DecodeRune(data[i:])
+		rest := data[i:]
+
+		switch state {
+		case inText:
+			if r == '<' {
+				state = inTag
+				for _, markup := range []struct{ start, end string }{
+					{"<!--", "-->"},
+					{"<![CDATA[", "]]>"},
+					{"<?", "?>"},
+				} {
+					if bytes.HasPrefix(rest, []byte(markup.start)) {
+						state, end = inOther, markup.end
+						break
+					}
+				}
+			}
+		case inTag:
+			switch r {
+			case '"', '\'':
+				state, quote = inAttrValue, r
+			case '>':
+				state = inText
+			}
+		case inAttrValue:
+			if r == quote {
+				state = inTag
+			}
+		case inOther:
+			if bytes.HasPrefix(rest, []byte(end)) {
+				out = append(out, end...)
+				i += len(end)
+				state = inText
+				continue
+			}
+		}
+
+		if b, ok := bytesOf[r]; ok {
+			out = append(out, b)
+		} else if state == inText || state == inAttrValue {
+			out = append(out, fmt.Sprintf("&#x%X;", r)...)
+		} else {
+			return nil, ErrUnrepresentableCharacter
+		}
+		i += size
+	}
+
+	return out, nil
+}
+
+// utf8CharsetReader accepts any declared encoding, for decoding documents
+// which transcodeDocument has already converted to UTF-8.
+func utf8CharsetReader(label string, input io.Reader) (io.Reader, error) {
+	return input, nil
+}
@@ -0,0 +1,166 @@
+package dsig
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/beevik/etree"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSingleByteEncodings(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+
+	for _, test := range []struct {
+		encoding string
+		text     []byte
+		decoded  string
+	}{
+		{"ISO-8859-1", []byte("Caf\xe9 \xa9"), "Café ©"},
+		{"windows-1252", []byte("Caf\xe9 \x80\x96"), "Café €–"},
+	} {
+		document := []byte(`<?xml version="1.0" encoding="` + test.encoding + `"?>` + "\n" +
+			`<Root Name="` + string(test.text) + `"><Text>` + string(test.text) + `</Text></Root>`)
+
+		el, signed, err := NewDefaultSigningContext(ks).SignBytes(document)
+		require.NoError(t, err, test.encoding)
+		require.Equal(t, test.decoded, el.SelectElement("Text").Text())
+
+		// The signed document remains in its original encoding.
+		require.True(t, bytes.HasPrefix(signed, document[:len(document)-len("</Root>")]), test.encoding)
+
+		verified, data, err := vc.ValidateBytes(signed)
+		require.NoError(t, err, test.encoding)
+		require.Equal(t, signed, data)
+		require.Equal(t, test.decoded, verified.SelectElement("Text").Text())
+		require.Equal(t, test.decoded, verified.SelectAttrValue("Name", ""))
+
+		_, err = vc.ValidateStream(bytes.NewReader(signed))
+		require.NoError(t, err, test.encoding)
+
+		// The signature covers the characters, not their encoding, so it
+		// holds for the same document converted to UTF-8.
+		utf8Signed, cs, err := transcodeDocument(signed)
+		require.NoError(t, err)
+		require.NotNil(t, cs)
+		utf8Signed = bytes.Replace(utf8Signed, []byte(test.encoding), []byte("UTF-8"), 1)
+
+		_, _, err = vc.ValidateBytes(utf8Signed)
+		require.NoError(t, err, test.encoding)
+	}
+}
+
+func TestUnsupportedEncoding(t *testing.T) {
+	document := []byte(`<?xml version="1.0" encoding="EBCDIC-US"?><Root/>`)
+
+	_, _, err := NewDefaultSigningContext(RandomKeyStoreForTest()).SignBytes(document)
+	require.Equal(t, ErrUnsupportedEncoding{Encoding: "EBCDIC-US"}, err)
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{})
+
+	_, _, err = vc.ValidateBytes(document)
+	require.Equal(t, ErrUnsupportedEncoding{Encoding: "EBCDIC-US"}, err)
+
+	_, err = vc.ValidateStream(bytes.NewReader(document))
+	require.True(t, errors.As(err, &ErrUnsupportedEncoding{}), err)
+}
+
+func TestWideEncodings(t *testing.T) {
+	utf16Document := func(order binary.AppendByteOrder, bom bool) []byte {
+		var document []byte
+		if bom {
+			document = order.AppendUint16(document, 0xfeff)
+		}
+		for _, c := range utf16.Encode([]rune(`<?xml version="1.0" encoding="UTF-16"?><Root/>`)) {
+			document = order.AppendUint16(document, c)
+		}
+		return document
+	}
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{})
+
+	for _, test := range []struct {
+		document []byte
+		encoding string
+	}{
+		{utf16Document(binary.LittleEndian, true), "UTF-16"},
+		{utf16Document(binary.BigEndian, true), "UTF-16"},
+		{utf16Document(binary.LittleEndian, false), "UTF-16"},
+		{utf16Document(binary.BigEndian, false), "UTF-16"},
+		{[]byte("\xff\xfe\x00\x00<\x00\x00\x00"), "UTF-32"},
+		{[]byte("\x00\x00\x00<"), "UTF-32"},
+	} {
+		_, _, err := NewDefaultSigningContext(RandomKeyStoreForTest()).SignBytes(test.document)
+		require.Equal(t, ErrUnsupportedEncoding{Encoding: test.encoding}, err)
+
+		_, _, err = vc.ValidateBytes(test.document)
+		require.Equal(t, ErrUnsupportedEncoding{Encoding: test.encoding}, err)
+	}
+}
+
+func TestSignBytesEncodesSignature(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+
+	ctx := NewDefaultSigningContext(ks)
+	ctx.SignatureProperties = []SignatureProperty{
+		NewSignatureProperty("urn:x", "Place", "Zürich"),
+		NewSignatureProperty("urn:x", "City", "東京 €"),
+	}
+
+	vc := NewDefaultValidationContext(certificateStoreForTest(t, ks))
+
+	for _, encoding := range []string{"ISO-8859-1", "windows-1252"} {
+		document := []byte(`<?xml version="1.0" encoding="` + encoding + `"?><Root ID="a">caf` + "\xe9" + `</Root>`)
+
+		_, signed, err := ctx.SignBytes(document)
+		require.NoError(t, err, encoding)
+
+		// Characters the encoding can represent are encoded, the others
+		// are written as character references.
+		require.Contains(t, string(signed), "Z\xfcrich", encoding)
+		require.Contains(t, string(signed), "&#x6771;&#x4EAC;", encoding)
+
+		_, _, err = vc.ValidateBytes(signed)
+		require.NoError(t, err, encoding)
+
+		_, err = vc.ValidateStream(bytes.NewReader(signed))
+		require.NoError(t, err, encoding)
+
+		utf8Signed, _, err := transcodeDocument(signed)
+		require.NoError(t, err)
+
+		doc := etree.NewDocument()
+		require.NoError(t, doc.ReadFromBytes(utf8Signed))
+
+		result, err := vc.ValidateWithSignatureProperties(doc.Root())
+		require.NoError(t, err, encoding)
+
+		place, _ := result.SignaturePropertyValue("urn:x", "Place")
+		require.Equal(t, "Zürich", place)
+
+		city, _ := result.SignaturePropertyValue("urn:x", "City")
+		require.Equal(t, "東京 €", city)
+	}
+
+	// Names can not hold character references.
+	ctx.SignatureProperties = []SignatureProperty{NewSignatureProperty("urn:x", "場所", "Zürich")}
+	_, _, err := ctx.SignBytes([]byte(`<?xml version="1.0" encoding="ISO-8859-1"?><Root/>`))
+	require.Equal(t, ErrUnrepresentableCharacter, err)
+
+	_, err = latin1.encode([]byte(`<Root><!-- € --></Root>`))
+	require.Equal(t, ErrUnrepresentableCharacter, err)
+
+	encoded, err := latin1.encode([]byte(`<Root a="€ é" b='€'>€ é<?pi é?><!-- é --></Root>`))
+	require.NoError(t, err)
+	require.Equal(t, "<Root a=\"&#x20AC; \xe9\" b='&#x20AC;'>&#x20AC; \xe9<?pi \xe9?><!-- \xe9 --></Root>", string(encoded))
+}
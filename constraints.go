@@ -0,0 +1,123 @@
+package dsig
+
+import (
+	"crypto/x509"
+)
+
+// ErrSignerConstraint indicates that the certificate a signature was made
+// with is trusted, but does not satisfy the SignerConstraints in effect.
+type ErrSignerConstraint struct {
+	// Constraint is the name of the unsatisfied SignerConstraints field.
+	Constraint string
+}
+
+func (e ErrSignerConstraint) Error() string {
+	return "Signer certificate does not satisfy the " + e.Constraint + " constraint"
+}
+
+// SignerConstraints restricts which trusted certificates signatures are
+// accepted from. They are checked once the certificate is trusted. Empty
+// constraints are not checked.
+type SignerConstraints struct {
+	// Subjects are the acceptable subjects of the certificate, as formatted
+	// by pkix.Name.String.
+	Subjects []string
+
+	// SubjectAltNames are the acceptable subject alternative names. The
+	// certificate must carry at least one of them as a DNS name, email
+	// address, IP address or URI.
+	SubjectAltNames []string
+
+	// Organizations are the acceptable organizations of the certificate's
+	// subject.
+	Organizations []string
+
+	// KeyUsage holds the key usages the certificate must permit, such as
+	// x509.KeyUsageDigitalSignature or x509.KeyUsageContentCommitment
+	// (nonRepudiation).
+	KeyUsage x509.KeyUsage
+
+	// ExtKeyUsage holds the extended key usages the certificate must
+	// permit. A certificate permitting x509.ExtKeyUsageAny permits them all.
+	ExtKeyUsage []x509.ExtKeyUsage
+}
+
+// check checks cert against the constraints. A nil SignerConstraints accepts
+// every certificate.
+func (c *SignerConstraints) check(cert *x509.Certificate) error {
+	if c == nil {
+		return nil
+	}
+
+	if len(c.Subjects) != 0 && !containsString(c.Subjects, cert.Subject.String()) {
+		return ErrSignerConstraint{Constraint: "Subjects"}
+	}
+
+	if len(c.SubjectAltNames) != 0 && !hasSubjectAltName(cert, c.SubjectAltNames) {
+		return ErrSignerConstraint{Constraint: "SubjectAltNames"}
+	}
+
+	if len(c.Organizations) != 0 && !anyString(cert.Subject.Organization, c.Organizations) {
+		return ErrSignerConstraint{Constraint: "Organizations"}
+	}
+
+	if cert.KeyUsage&c.KeyUsage != c.KeyUsage {
+		return ErrSignerConstraint{Constraint: "KeyUsage"}
+	}
+
+	for _, usage := range c.ExtKeyUsage {
+		if !hasExtKeyUsage(cert, usage) {
+			return ErrSignerConstraint{Constraint: "ExtKeyUsage"}
+		}
+	}
+
+	return nil
+}
+
+func hasSubjectAltName(cert *x509.Certificate, names []string) bool {
+	if anyString(cert.DNSNames, names) || anyString(cert.EmailAddresses, names) {
+		return true
+	}
+
+	for _, ip := range cert.IPAddresses {
+		if containsString(names, ip.String()) {
+			return true
+		}
+	}
+
+	for _, uri := range cert.URIs {
+		if containsString(names, uri.String()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasExtKeyUsage(cert *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	for _, permitted := range cert.ExtKeyUsage {
+		if permitted == usage || permitted == x509.ExtKeyUsageAny {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// anyString reports whether any of values is among acceptable.
+func anyString(values, acceptable []string) bool {
+	for _, value := range values {
+		if containsString(acceptable, value) {
+			return true
+		}
+	}
+	return false
+}
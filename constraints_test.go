@@ -0,0 +1,58 @@
+package dsig
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignerConstraints(t *testing.T) {
+	ks := keyStoreWithTemplateForTest(t, &x509.Certificate{
+		Subject: pkix.Name{
+			CommonName:   "kyc-signer",
+			Organization: []string{"Example Bank"},
+		},
+		DNSNames:    []string{"kyc.example.com"},
+		KeyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	})
+
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	signed := signDocumentForTest(t, NewDefaultSigningContext(ks), `<Root><Child/></Root>`).Root()
+
+	for _, test := range []struct {
+		constraints SignerConstraints
+		failed      string
+	}{
+		{SignerConstraints{
+			Subjects:        []string{"CN=kyc-signer,O=Example Bank"},
+			SubjectAltNames: []string{"other.example.com", "kyc.example.com"},
+			Organizations:   []string{"Example Bank"},
+			KeyUsage:        x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment,
+			ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, ""},
+		{SignerConstraints{Subjects: []string{"CN=someone-else"}}, "Subjects"},
+		{SignerConstraints{SubjectAltNames: []string{"other.example.com"}}, "SubjectAltNames"},
+		{SignerConstraints{Organizations: []string{"Other Bank"}}, "Organizations"},
+		{SignerConstraints{KeyUsage: x509.KeyUsageCertSign}, "KeyUsage"},
+		{SignerConstraints{ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning}}, "ExtKeyUsage"},
+	} {
+		constraints := test.constraints
+
+		vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+			Roots: []*x509.Certificate{cert},
+		})
+		vc.SignerConstraints = &constraints
+
+		_, err := vc.Validate(signed)
+		if test.failed == "" {
+			require.NoError(t, err)
+		} else {
+			require.Equal(t, ErrSignerConstraint{Constraint: test.failed}, err)
+		}
+	}
+}
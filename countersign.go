@@ -0,0 +1,347 @@
+package dsig
+
+import (
+	"crypto/x509"
+	"errors"
+
+	"github.com/beevik/etree"
+	"gitlab.com/moolekkari/goxmldsig/etreeutils"
+	"gitlab.com/moolekkari/goxmldsig/types"
+)
+
+// CountersignedSignatureType is the Type of the Reference from a
+// counter-signature to the SignatureValue it countersigns.
+const CountersignedSignatureType = "http://uri.etsi.org/01903#CountersignedSignature"
+
+// CounterSignature is a verified counter-signature.
+type CounterSignature struct {
+	// Certificate is the certificate of the countersigner.
+	Certificate *x509.Certificate
+
+	// XAdES holds the signed qualifying properties of a XAdES
+	// counter-signature, and is nil for others.
+	XAdES *XAdESProperties
+}
+
+// CounterSign constructs a counter-signature of sig, an existing Signature
+// element, whose Reference targets the SignatureValue of sig by its Id. The
+// SignatureValue is given an Id if it has none, which invalidates any
+// existing timestamp of it. The counter-signature is returned for the caller
+// to place in the document, outside the content sig signs, such as in a
+// ds:Object of sig.
+func (ctx *SigningContext) CounterSign(sig *etree.Element) (*etree.Element, error) {
+	signatureValue, id, err := countersignedSignatureValue(sig)
+	if err != nil {
+		return nil, err
+	}
+
+	detached, err := detachedCopy(signatureValue)
+	if err != nil {
+		return nil, err
+	}
+
+	return ctx.counterSigningContext().constructSignature(detached, false, &signatureContent{
+		uri:           "#" + id,
+		referenceType: CountersignedSignatureType,
+	})
+}
+
+// AddCounterSignature countersigns sig, an existing XAdES Signature
+// element, in place, with a XAdES signature held in a CounterSignature
+// unsigned property of sig, which it returns. As with CounterSign, its
+// Reference targets the SignatureValue of sig.
+func (ctx *XAdESSigningContext) AddCounterSignature(sig *etree.Element) (*etree.Element, error) {
+	extensionCtx, _, unsignedSignatureProperties, err := ctx.extensionContext(sig)
+	if err != nil {
+		return nil, err
+	}
+
+	signatureValue, id, err := countersignedSignatureValue(sig)
+	if err != nil {
+		return nil, err
+	}
+
+	detached, err := detachedCopy(signatureValue)
+	if err != nil {
+		return nil, err
+	}
+
+	counterCtx := *ctx
+	counterCtx.SigningContext = ctx.counterSigningContext()
+
+	counterSig, err := counterCtx.constructXAdESSignature(detached, false, &signatureContent{
+		uri:           "#" + id,
+		referenceType: CountersignedSignatureType,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	extensionCtx.createXAdESElement(unsignedSignatureProperties, CounterSignatureTag).AddChild(counterSig)
+
+	return counterSig, nil
+}
+
+// counterSigningContext returns a copy of ctx for counter-signing. It
+// canonicalizes exclusively, which keeps counter-signatures valid wherever
+// they are placed, and applies no additional Transforms, Manifest or
+// SignatureProperties.
+func (ctx *SigningContext) counterSigningContext() *SigningContext {
+	counterCtx := *ctx
+	counterCtx.Canonicalizer = MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+	counterCtx.Transforms = nil
+	counterCtx.ManifestIDs = nil
+	counterCtx.SignatureProperties = nil
+	return &counterCtx
+}
+
+// countersignedSignatureValue returns the SignatureValue of sig along with
+// its Id, which it is given if it has none.
+func countersignedSignatureValue(sig *etree.Element) (*etree.Element, string, error) {
+	nsCtx, err := etreeutils.NSBuildParentContext(sig)
+	if err != nil {
+		return nil, "", err
+	}
+
+	signatureValue, err := etreeutils.NSFindOneChildCtx(nsCtx, sig, Namespace, SignatureValueTag)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if signatureValue == nil {
+		return nil, "", errors.New("Missing SignatureValue")
+	}
+
+	id := signatureValue.SelectAttrValue(IDAttr, "")
+	if id != "" {
+		return signatureValue, id, nil
+	}
+
+	if sigID := sig.SelectAttrValue(IDAttr, ""); sigID != "" {
+		id = sigID + "-SignatureValue"
+	} else {
+		id, err = randomID("xmldsig-")
+		if err != nil {
+			return nil, "", err
+		}
+		id += "-SignatureValue"
+	}
+
+	signatureValue.CreateAttr(IDAttr, id)
+
+	return signatureValue, id, nil
+}
+
+// isCounterSignature reports whether sig, held in el, countersigns another
+// signature: it is nested within another Signature, or has a Reference of
+// the CountersignedSignatureType.
+func isCounterSignature(el *etree.Element, sig *types.Signature) (bool, error) {
+	if sig.SignedInfo != nil {
+		for _, ref := range sig.SignedInfo.References {
+			if ref.Type == CountersignedSignatureType {
+				return true, nil
+			}
+		}
+	}
+
+	for ancestor := el.Parent(); ancestor != nil; ancestor = ancestor.Parent() {
+		if ancestor.Tag != SignatureTag {
+			continue
+		}
+
+		nsCtx, err := etreeutils.NSBuildParentContext(ancestor)
+		if err != nil {
+			return false, err
+		}
+
+		nsCtx, err = nsCtx.SubContext(ancestor)
+		if err != nil {
+			return false, err
+		}
+
+		namespace, err := nsCtx.LookupPrefix(ancestor.Space)
+		if err != nil {
+			return false, err
+		}
+
+		if namespace == Namespace {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// ValidateWithCounterSignatures verifies the signature of el as ValidateData
+// does, along with its counter-signatures: the Signatures within el with a
+// Reference to its SignatureValue, whether they are held in a XAdES
+// CounterSignature property or elsewhere. Each countersigner's certificate
+// is verified as the signer's is, and any invalid counter-signature fails
+// the validation. The References of any Manifests are checked as
+// ValidateWithManifests does, and any SignatureProperties are returned as
+// ValidateWithSignatureProperties does.
+func (ctx *ValidationContext) ValidateWithCounterSignatures(el *etree.Element) (*ValidationResult, error) {
+	el, sig, store, err := ctx.findValidationSignature(el)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := ctx.verifyCertificate(sig, store)
+	if err != nil {
+		return nil, err
+	}
+
+	transformed, err := ctx.validateSignature(el, sig, cert)
+	if err != nil {
+		return nil, err
+	}
+
+	counterSignatures, err := ctx.verifyCounterSignatures(el, sig, store)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, err := ctx.verifyManifests(el, sig)
+	if err != nil {
+		return nil, err
+	}
+
+	properties, err := ctx.verifySignatureProperties(el, sig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ValidationResult{
+		Content:             transformed,
+		Certificate:         cert,
+		CounterSignatures:   counterSignatures,
+		Manifests:           manifests,
+		SignatureProperties: properties,
+	}, nil
+}
+
+// verifyCounterSignatures verifies the counter-signatures of sig within el,
+// with certificates from store.
+func (ctx *ValidationContext) verifyCounterSignatures(el *etree.Element, sig *types.Signature, store X509CertificateStore) ([]*CounterSignature, error) {
+	sigElement := sig.UnderlyingElement()
+
+	nsCtx, err := etreeutils.NSBuildParentContext(sigElement)
+	if err != nil {
+		return nil, err
+	}
+
+	signatureValue, err := etreeutils.NSFindOneChildCtx(nsCtx, sigElement, Namespace, SignatureValueTag)
+	if err != nil {
+		return nil, err
+	}
+
+	// Without an Id, the SignatureValue can not be referred to.
+	if signatureValue == nil || signatureValue.SelectAttrValue(IDAttr, "") == "" {
+		return nil, nil
+	}
+
+	uri := "#" + signatureValue.SelectAttrValue(IDAttr, "")
+
+	var counterSignatures []*CounterSignature
+
+	err = etreeutils.NSFindIterate(el, Namespace, SignatureTag, func(nsCtx etreeutils.NSContext, counterSigElement *etree.Element) error {
+		if counterSigElement == sigElement {
+			return nil
+		}
+
+		counterSig := &types.Signature{}
+		err := etreeutils.NSUnmarshalElement(nsCtx, counterSigElement, counterSig)
+		if err != nil {
+			return err
+		}
+
+		if counterSig.SignedInfo == nil || !hasReference(counterSig, uri) {
+			return nil
+		}
+
+		err = bindTransformElements(nsCtx, counterSigElement, counterSig)
+		if err != nil {
+			return err
+		}
+
+		err = ctx.limits().checkSignature(counterSig)
+		if err != nil {
+			return err
+		}
+
+		counterSignature, err := ctx.verifyCounterSignature(el, counterSig, store)
+		if err != nil {
+			return err
+		}
+
+		counterSignatures = append(counterSignatures, counterSignature)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return counterSignatures, nil
+}
+
+// verifyCounterSignature verifies counterSig, a counter-signature within
+// el, whose References are all dereferenced within el.
+func (ctx *ValidationContext) verifyCounterSignature(el *etree.Element, counterSig *types.Signature, store X509CertificateStore) (*CounterSignature, error) {
+	cert, err := ctx.verifyCertificate(counterSig, store)
+	if err != nil {
+		return nil, err
+	}
+
+	xades := false
+	for i := range counterSig.SignedInfo.References {
+		ref := &counterSig.SignedInfo.References[i]
+		xades = xades || ref.Type == XAdESSignedPropertiesType
+
+		target, err := dereference(el, ref.URI)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = ctx.verifyReference(target, counterSig, ref)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = ctx.verifySignatureValue(counterSig, cert)
+	if err != nil {
+		return nil, err
+	}
+
+	counterSignature := &CounterSignature{Certificate: cert}
+	if !xades {
+		return counterSignature, nil
+	}
+
+	signedProperties, _, err := findSignedProperties(el, counterSig)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.checkSigningCertificate(signedProperties, cert)
+	if err != nil {
+		return nil, err
+	}
+
+	counterSignature.XAdES, err = parseXAdESProperties(signedProperties)
+	if err != nil {
+		return nil, err
+	}
+
+	return counterSignature, nil
+}
+
+// hasReference reports whether sig has a Reference to uri.
+func hasReference(sig *types.Signature, uri string) bool {
+	for _, ref := range sig.SignedInfo.References {
+		if ref.URI == uri {
+			return true
+		}
+	}
+	return false
+}
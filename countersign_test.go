@@ -0,0 +1,157 @@
+package dsig
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounterSign(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	counterKS := RandomKeyStoreForTest()
+	_, counterCert, err := counterKS.GetKeyPair()
+	require.NoError(t, err)
+
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(`<Approval xmlns="urn:approval" ID="approval-1"><Amount>100</Amount></Approval>`))
+
+	signed, err := NewDefaultSigningContext(ks).SignEnveloped(doc.Root())
+	require.NoError(t, err)
+
+	sig := signed.FindElement("./ds:Signature")
+	require.NotNil(t, sig)
+
+	counterSig, err := NewDefaultSigningContext(counterKS).CounterSign(sig)
+	require.NoError(t, err)
+
+	signatureValueID := sig.FindElement("./ds:SignatureValue").SelectAttrValue(IDAttr, "")
+	require.NotEmpty(t, signatureValueID)
+
+	reference := counterSig.FindElement("./ds:SignedInfo/ds:Reference")
+	require.Equal(t, "#"+signatureValueID, reference.SelectAttrValue(URIAttr, ""))
+	require.Equal(t, CountersignedSignatureType, reference.SelectAttrValue(TypeAttr, ""))
+
+	// The counter-signature is placed where sig does not sign it.
+	object := sig.CreateElement(ObjectTag)
+	object.Space = sig.Space
+	object.AddChild(counterSig)
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert, counterCert},
+	})
+
+	// The countersigned signature is still the one validated.
+	_, err = vc.Validate(signed)
+	require.NoError(t, err)
+
+	result, err := vc.ValidateWithCounterSignatures(signed)
+	require.NoError(t, err)
+	require.True(t, cert.Equal(result.Certificate))
+	require.Len(t, result.CounterSignatures, 1)
+	require.True(t, counterCert.Equal(result.CounterSignatures[0].Certificate))
+	require.Nil(t, result.CounterSignatures[0].XAdES)
+
+	// The countersigner must be trusted as the signer is.
+	untrusted := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+	_, err = untrusted.ValidateWithCounterSignatures(signed)
+	require.EqualError(t, err, "Could not verify certificate against trusted certs")
+
+	// The counter-signature signs the SignatureValue.
+	tampered := signed.Copy()
+	tampered.FindElement("./ds:Signature/ds:SignatureValue").CreateAttr("Extra", "1")
+	_, err = vc.ValidateWithCounterSignatures(tampered)
+	require.EqualError(t, err, "Signature could not be verified")
+
+	tampered = signed.Copy()
+	counterSignatureValue := tampered.FindElement("./ds:Signature/ds:Object/ds:Signature/ds:SignatureValue")
+	decoded, err := base64.StdEncoding.DecodeString(counterSignatureValue.Text())
+	require.NoError(t, err)
+	decoded[0] ^= 1
+	counterSignatureValue.SetText(base64.StdEncoding.EncodeToString(decoded))
+	_, err = vc.ValidateWithCounterSignatures(tampered)
+	require.Error(t, err)
+}
+
+func TestXAdESCounterSignature(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	counterKS := RandomKeyStoreForTest()
+	_, counterCert, err := counterKS.GetKeyPair()
+	require.NoError(t, err)
+
+	tsa, err := newFakeTimestampAuthority()
+	require.NoError(t, err)
+	tsa.Clock = NewFakeClockAt(time.Now().Add(-time.Minute).UTC().Truncate(time.Second))
+
+	ctx := NewXAdESSigningContext(NewDefaultSigningContext(ks))
+	ctx.SignatureID = "sig-1"
+	ctx.SigningTime = tsa.Clock.Now().Add(-time.Minute)
+	ctx.TimestampAuthority = tsa
+
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(`<Invoice xmlns="urn:invoice"><Total>100</Total></Invoice>`))
+
+	signed, err := ctx.SignEnveloped(doc.Root())
+	require.NoError(t, err)
+
+	sig := signed.FindElement("./ds:Signature")
+	require.Equal(t, "sig-1-SignatureValue", sig.FindElement("./ds:SignatureValue").SelectAttrValue(IDAttr, ""))
+
+	counterCtx := NewXAdESSigningContext(NewDefaultSigningContext(counterKS))
+	counterCtx.SignatureID = "countersig-1"
+
+	counterSig, err := counterCtx.AddCounterSignature(sig)
+	require.NoError(t, err)
+	require.Equal(t, "countersig-1", counterSig.SelectAttrValue(IDAttr, ""))
+	require.Equal(t, counterSig, signed.FindElement("./ds:Signature/ds:Object/xades:QualifyingProperties/xades:UnsignedProperties/xades:UnsignedSignatureProperties/xades:CounterSignature/ds:Signature"))
+
+	references := counterSig.FindElements("./ds:SignedInfo/ds:Reference")
+	require.Len(t, references, 2)
+	require.Equal(t, "#sig-1-SignatureValue", references[0].SelectAttrValue(URIAttr, ""))
+	require.Equal(t, CountersignedSignatureType, references[0].SelectAttrValue(TypeAttr, ""))
+
+	// The counter-signature survives serialization.
+	doc = etree.NewDocument()
+	doc.SetRoot(signed.Copy())
+
+	serialized, err := doc.WriteToString()
+	require.NoError(t, err)
+
+	doc = etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(serialized))
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert, counterCert},
+	})
+	vc.TimestampCertificateStore = &MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{tsa.Certificate()},
+	}
+
+	result, err := vc.ValidateXAdES(doc.Root())
+	require.NoError(t, err)
+	require.True(t, cert.Equal(result.Certificate))
+	require.Len(t, result.XAdES.SignatureTimestamps, 1)
+	require.Len(t, result.CounterSignatures, 1)
+	require.True(t, counterCert.Equal(result.CounterSignatures[0].Certificate))
+	require.NotNil(t, result.CounterSignatures[0].XAdES)
+	require.False(t, result.CounterSignatures[0].XAdES.SigningTime.IsZero())
+
+	// An invalid counter-signature fails the validation.
+	vc.CertificateStore = &MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	}
+
+	_, err = vc.ValidateXAdES(doc.Root())
+	require.EqualError(t, err, "Could not verify certificate against trusted certs")
+}
@@ -0,0 +1,233 @@
+package dsig
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"unicode/utf8"
+
+	"github.com/beevik/etree"
+)
+
+// ValidateBytes parses data as a serialized document and verifies its
+// enveloped signature, as Validate does for a parsed document. Parsing is
+// strict, does not expand entities, and is subject to the context's
+// Limits. Documents declaring an encoding other than UTF-8 are converted to
+// it first. Along with the signed content, it returns data, which should be
+// passed on in place of any re-serialization of the content so that the
+// signature remains valid.
+func (ctx *ValidationContext) ValidateBytes(data []byte) (*etree.Element, []byte, error) {
+	utf8Data, _, err := transcodeDocument(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = scanDocument(utf8Data, ctx.limits())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	doc, err := parseDocument(utf8Data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	el, err := ctx.Validate(doc.Root())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return el, data, nil
+}
+
+// ValidateReader reads a serialized document from r and validates it as
+// ValidateBytes does, returning the signed content and the bytes read.
+func (ctx *ValidationContext) ValidateReader(r io.Reader) (*etree.Element, []byte, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ctx.ValidateBytes(data)
+}
+
+// SignBytes parses data as a serialized document and signs its root element
+// as SignEnveloped does. Along with the signed element, it returns the
+// signed document, which is data with the Signature inserted as the last
+// child of the root element; everything else, including the XML declaration
+// and whitespace, is preserved byte for byte. Documents declaring an
+// encoding other than UTF-8 are signed as if converted to it, but are
+// returned in their own encoding, with any characters of the Signature it
+// can not represent written as character references.
+func (ctx *SigningContext) SignBytes(data []byte) (*etree.Element, []byte, error) {
+	utf8Data, cs, err := transcodeDocument(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// A DTD could change the content the signature is computed over once
+	// the document is parsed by someone else, so it is rejected here too.
+	root, err := scanDocument(utf8Data, &ValidationLimits{})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if cs != nil {
+		// Every byte of data is a character of utf8Data.
+		root.end = int64(utf8.RuneCount(utf8Data[:root.end]))
+	}
+
+	doc, err := parseDocument(utf8Data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signed, err := ctx.SignEnveloped(doc.Root())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	children := signed.ChildElements()
+	sigDoc := etree.NewDocument()
+	sigDoc.SetRoot(children[len(children)-1].Copy())
+
+	sig, err := sigDoc.WriteToBytes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The Signature may hold characters from names in the certificate or
+	// from SignatureProperties, which must be in the encoding of data.
+	if cs != nil {
+		sig, err = cs.encode(sig)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var out bytes.Buffer
+	out.Grow(len(data) + len(sig) + len(root.name) + 3)
+
+	if root.selfClosing {
+		// Open up <Root/> to make room for the Signature.
+		out.Write(data[:root.end-int64(len("/>"))])
+		out.WriteString(">")
+		out.Write(sig)
+		out.WriteString("</" + root.name + ">")
+	} else {
+		out.Write(data[:root.end])
+		out.Write(sig)
+	}
+	out.Write(data[root.end:])
+
+	return signed, out.Bytes(), nil
+}
+
+// rootElementEnd locates the end of the root element of a serialized
+// document.
+type rootElementEnd struct {
+	// name is the qualified name of the root element.
+	name string
+
+	// end is the offset of the root element's end tag, or, if the root
+	// element is self-closing, the offset just past its start tag.
+	end int64
+
+	selfClosing bool
+}
+
+// scanDocument checks that data, a document transcoded to UTF-8, is
+// well-formed and within limits, before parseDocument builds a tree from
+// it, and locates the end of its root element.
+func scanDocument(data []byte, limits *ValidationLimits) (*rootElementEnd, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.CharsetReader = utf8CharsetReader
+
+	var (
+		open     []string
+		started  int
+		root     *rootElementEnd
+		startEnd int64
+	)
+
+	for {
+		offset := dec.InputOffset()
+
+		t, err := dec.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			if root != nil {
+				return nil, errors.New("Document has more than one root element")
+			}
+
+			started++
+			if err := limits.checkElement(len(open)+1, started); err != nil {
+				return nil, err
+			}
+
+			open = append(open, fullName(t.Name))
+			startEnd = dec.InputOffset()
+
+		case xml.EndElement:
+			name := fullName(t.Name)
+			if len(open) == 0 || open[len(open)-1] != name {
+				return nil, fmt.Errorf("unexpected end element </%s>", name)
+			}
+			open = open[:len(open)-1]
+
+			if len(open) == 0 {
+				root = &rootElementEnd{name: name, end: offset}
+
+				// A self-closing element's end is reported without
+				// consuming any input.
+				if offset == startEnd && bytes.HasSuffix(data[:offset], []byte("/>")) {
+					root.selfClosing = true
+				}
+			}
+
+		case xml.CharData:
+			if len(open) == 0 && len(bytes.TrimSpace(t)) != 0 {
+				return nil, errors.New("Document has content outside the root element")
+			}
+
+		case xml.Directive:
+			if !limits.AllowDTD {
+				return nil, ErrDTDNotAllowed
+			}
+		}
+	}
+
+	if len(open) != 0 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	if root == nil {
+		return nil, errors.New("Document has no root element")
+	}
+
+	return root, nil
+}
+
+// parseDocument parses data, which scanDocument has accepted, into a tree.
+func parseDocument(data []byte) (*etree.Document, error) {
+	doc := etree.NewDocument()
+	doc.ReadSettings = etree.ReadSettings{
+		CharsetReader: utf8CharsetReader,
+	}
+
+	err := doc.ReadFromBytes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
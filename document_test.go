@@ -0,0 +1,74 @@
+package dsig
+
+import (
+	"bytes"
+	"crypto/x509"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignBytesPreservesSerialization(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+
+	for _, test := range []struct {
+		document string
+		prefix   string
+		suffix   string
+	}{
+		{
+			document: "<?xml version='1.0' encoding='utf-8'?>\n<!-- header -->\n<Root  b = 'single' a=\"double\">\n    <Child>text &#65; &amp; more</Child>\n</Root>\n",
+			prefix:   "<?xml version='1.0' encoding='utf-8'?>\n<!-- header -->\n<Root  b = 'single' a=\"double\">\n    <Child>text &#65; &amp; more</Child>\n<",
+			suffix:   "></Root>\n",
+		},
+		{
+			document: `<Root attr="value"/>`,
+			prefix:   `<Root attr="value"><`,
+			suffix:   `></Root>`,
+		},
+	} {
+		el, signed, err := NewDefaultSigningContext(ks).SignBytes([]byte(test.document))
+		require.NoError(t, err)
+		require.NotNil(t, el)
+
+		require.True(t, strings.HasPrefix(string(signed), test.prefix), string(signed))
+		require.True(t, strings.HasSuffix(string(signed), test.suffix), string(signed))
+
+		verified, data, err := vc.ValidateReader(bytes.NewReader(signed))
+		require.NoError(t, err)
+		require.Equal(t, "Root", verified.Tag)
+		require.Equal(t, signed, data)
+
+		_, _, err = vc.ValidateBytes(bytes.Replace(signed, []byte("Root"), []byte("Tree"), 2))
+		require.Error(t, err)
+	}
+}
+
+func TestValidateBytesRejectsMalformedDocuments(t *testing.T) {
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{})
+
+	for _, document := range []string{
+		``,
+		`<Root><Child></Root>`,
+		`<Root/><Second/>`,
+		`<Root/>trailing`,
+		`<Root>&undeclared;</Root>`,
+		`<?xml version="1.0" encoding="UTF-16"?><Root/>`,
+	} {
+		_, _, err := vc.ValidateBytes([]byte(document))
+		require.Error(t, err, document)
+	}
+
+	_, _, err := vc.ValidateBytes([]byte(`<!DOCTYPE Root><Root/>`))
+	require.Equal(t, ErrDTDNotAllowed, err)
+
+	_, _, err = NewDefaultSigningContext(RandomKeyStoreForTest()).SignBytes([]byte(`<!DOCTYPE Root><Root/>`))
+	require.Equal(t, ErrDTDNotAllowed, err)
+}
@@ -9,6 +9,27 @@ import (
 
 // TransformExcC14n transforms the passed element into xml-exc-c14n form.
 func TransformExcC14n(el *etree.Element, inclusiveNamespacesPrefixList string) error {
+	r := NewExcC14nRenderer(inclusiveNamespacesPrefixList)
+
+	err := r.transform(DefaultNSContext, DefaultNSContext, el)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ExcC14nRenderer computes the xml-exc-c14n form of the attributes of the
+// elements in a tree, without modifying the tree. It is used to serialize
+// canonical XML directly, where TransformExcC14n would require a copy of
+// the tree.
+type ExcC14nRenderer struct {
+	inclusiveNamespaces map[string]struct{}
+}
+
+// NewExcC14nRenderer constructs an ExcC14nRenderer from an InclusiveNamespaces
+// PrefixList in NMTOKENS format (a white space separated list).
+func NewExcC14nRenderer(inclusiveNamespacesPrefixList string) *ExcC14nRenderer {
 	prefixes := strings.Fields(inclusiveNamespacesPrefixList)
 	prefixSet := make(map[string]struct{}, len(prefixes))
 
@@ -16,79 +37,101 @@ func TransformExcC14n(el *etree.Element, inclusiveNamespacesPrefixList string) e
 		prefixSet[prefix] = struct{}{}
 	}
 
-	err := transformExcC14n(DefaultNSContext, DefaultNSContext, el, prefixSet)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return &ExcC14nRenderer{inclusiveNamespaces: prefixSet}
 }
 
-func transformExcC14n(ctx, declared NSContext, el *etree.Element, inclusiveNamespaces map[string]struct{}) error {
+// Attrs appends the attributes of el in xml-exc-c14n form to dst, in
+// canonical order. ctx is the namespace context surrounding el, and declared
+// holds the namespace declarations made by the canonical forms of el's
+// ancestors; both are DefaultNSContext for the top level element. The
+// returned contexts are the ones to pass for el's children.
+func (r *ExcC14nRenderer) Attrs(dst []etree.Attr, ctx, declared NSContext, el *etree.Element) ([]etree.Attr, NSContext, NSContext, error) {
 	scope, err := ctx.SubContext(el)
 	if err != nil {
-		return err
+		return nil, ctx, declared, err
 	}
 
-	visiblyUtilizedPrefixes := map[string]struct{}{
-		el.Space: struct{}{},
+	// Elements utilize few prefixes, so a slice is cheaper than a set.
+	var buf [8]string
+	visiblyUtilizedPrefixes := append(buf[:0], el.Space)
+	utilize := func(prefix string) {
+		for _, utilized := range visiblyUtilizedPrefixes {
+			if utilized == prefix {
+				return
+			}
+		}
+		visiblyUtilizedPrefixes = append(visiblyUtilizedPrefixes, prefix)
 	}
 
-	filteredAttrs := []etree.Attr{}
+	start := len(dst)
 
 	// Filter out all namespace declarations
 	for _, attr := range el.Attr {
 		switch {
 		case attr.Space == xmlnsPrefix:
-			if _, ok := inclusiveNamespaces[attr.Key]; ok {
-				visiblyUtilizedPrefixes[attr.Key] = struct{}{}
+			if _, ok := r.inclusiveNamespaces[attr.Key]; ok {
+				utilize(attr.Key)
 			}
 
 		case attr.Space == defaultPrefix && attr.Key == xmlnsPrefix:
-			if _, ok := inclusiveNamespaces[defaultPrefix]; ok {
-				visiblyUtilizedPrefixes[defaultPrefix] = struct{}{}
+			if _, ok := r.inclusiveNamespaces[defaultPrefix]; ok {
+				utilize(defaultPrefix)
 			}
 
 		default:
 			if attr.Space != defaultPrefix {
-				visiblyUtilizedPrefixes[attr.Space] = struct{}{}
+				utilize(attr.Space)
 			}
 
-			filteredAttrs = append(filteredAttrs, attr)
+			dst = append(dst, attr)
 		}
 	}
 
-	el.Attr = filteredAttrs
-
-	declared = declared.Copy()
-
 	// Declare all visibly utilized prefixes that are in-scope but haven't
 	// been declared in the canonicalized form yet. These might have been
 	// declared on this element but then filtered out above, or they might
 	// have been declared on an ancestor (before canonicalization) which
 	// didn't visibly utilize and thus had them removed.
-	for prefix := range visiblyUtilizedPrefixes {
+	for _, prefix := range visiblyUtilizedPrefixes {
 		// Skip redundant declarations - they have to already have the same
 		// value.
-		if declaredNamespace, ok := declared.prefixes[prefix]; ok {
-			if value, ok := scope.prefixes[prefix]; ok && declaredNamespace == value {
+		if declaredNamespace, ok := declared.lookup(prefix); ok {
+			if value, ok := scope.lookup(prefix); ok && declaredNamespace == value {
 				continue
 			}
 		}
 
 		namespace, err := scope.LookupPrefix(prefix)
 		if err != nil {
-			return err
+			return nil, ctx, declared, err
 		}
 
-		el.Attr = append(el.Attr, declared.declare(prefix, namespace))
+		var attr etree.Attr
+		declared, attr = declared.declare(prefix, namespace)
+		dst = append(dst, attr)
+	}
+
+	if len(dst)-start > 1 {
+		sort.Sort(SortedAttrs(dst[start:]))
 	}
 
-	sort.Sort(SortedAttrs(el.Attr))
+	return dst, scope, declared, nil
+}
+
+func (r *ExcC14nRenderer) transform(ctx, declared NSContext, el *etree.Element) error {
+	attrs, scope, declared, err := r.Attrs(nil, ctx, declared, el)
+	if err != nil {
+		return err
+	}
+
+	if attrs == nil {
+		attrs = []etree.Attr{}
+	}
+	el.Attr = attrs
 
 	// Transform child elements
 	for _, child := range el.ChildElements() {
-		err := transformExcC14n(scope, declared, child, inclusiveNamespaces)
+		err := r.transform(scope, declared, child)
 		if err != nil {
 			return err
 		}
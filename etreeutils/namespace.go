@@ -21,10 +21,12 @@ const (
 
 var (
 	DefaultNSContext = NSContext{
-		prefixes: map[string]string{
-			defaultPrefix: XMLNamespace,
-			xmlPrefix:     XMLNamespace,
-			xmlnsPrefix:   XMLNSNamespace,
+		frame: &nsFrame{
+			bindings: []nsBinding{
+				{prefix: defaultPrefix, namespace: XMLNamespace},
+				{prefix: xmlPrefix, namespace: XMLNamespace},
+				{prefix: xmlnsPrefix, namespace: XMLNSNamespace},
+			},
 		},
 	}
 
@@ -43,31 +45,64 @@ func (e ErrUndeclaredNSPrefix) Error() string {
 	return fmt.Sprintf("undeclared namespace prefix: '%s'", e.Prefix)
 }
 
+// NSContext is the set of namespace prefixes in scope at some point in a
+// document. Contexts are immutable: a context is a chain of frames, one for
+// each element which declares namespaces, linked to the frame of its nearest
+// such ancestor. Deriving the context of an element which declares nothing
+// therefore costs nothing, and contexts may be freely shared.
 type NSContext struct {
-	prefixes map[string]string
+	frame *nsFrame
 }
 
+type nsFrame struct {
+	parent   *nsFrame
+	bindings []nsBinding
+}
+
+type nsBinding struct {
+	prefix    string
+	namespace string
+}
+
+// Copy returns ctx. Contexts are immutable, so they never need to be copied;
+// Copy is kept for compatibility.
 func (ctx NSContext) Copy() NSContext {
-	prefixes := make(map[string]string, len(ctx.prefixes)+4)
-	for k, v := range ctx.prefixes {
-		prefixes[k] = v
+	return ctx
+}
+
+// lookup finds the innermost binding of prefix.
+func (ctx NSContext) lookup(prefix string) (string, bool) {
+	for f := ctx.frame; f != nil; f = f.parent {
+		// Later declarations in a frame take precedence.
+		for i := len(f.bindings) - 1; i >= 0; i-- {
+			if f.bindings[i].prefix == prefix {
+				return f.bindings[i].namespace, true
+			}
+		}
 	}
 
-	return NSContext{prefixes: prefixes}
+	return "", false
 }
 
-func (ctx NSContext) declare(prefix, namespace string) etree.Attr {
-	ctx.prefixes[prefix] = namespace
+// declare returns a context in which prefix is bound to namespace, along
+// with the attribute declaring it.
+func (ctx NSContext) declare(prefix, namespace string) (NSContext, etree.Attr) {
+	ctx = NSContext{
+		frame: &nsFrame{
+			parent:   ctx.frame,
+			bindings: []nsBinding{{prefix: prefix, namespace: namespace}},
+		},
+	}
 
 	switch prefix {
 	case defaultPrefix:
-		return etree.Attr{
+		return ctx, etree.Attr{
 			Key:   xmlnsPrefix,
 			Value: namespace,
 		}
 
 	default:
-		return etree.Attr{
+		return ctx, etree.Attr{
 			Space: xmlnsPrefix,
 			Key:   prefix,
 			Value: namespace,
@@ -76,11 +111,13 @@ func (ctx NSContext) declare(prefix, namespace string) etree.Attr {
 }
 
 func (ctx NSContext) SubContext(el *etree.Element) (NSContext, error) {
-	// The subcontext should inherit existing declared prefixes
-	newCtx := ctx.Copy()
+	// The subcontext inherits existing declared prefixes, with any new
+	// namespace declarations in a frame on top of them.
+	var frame *nsFrame
 
-	// Merge new namespace declarations on top of existing ones.
 	for _, attr := range el.Attr {
+		var binding nsBinding
+
 		if attr.Space == xmlnsPrefix {
 			// This attribute is a namespace declaration of the form "xmlns:<prefix>"
 
@@ -94,7 +131,7 @@ func (ctx NSContext) SubContext(el *etree.Element) (NSContext, error) {
 				return ctx, ErrReservedNamespace
 			}
 
-			newCtx.declare(attr.Key, attr.Value)
+			binding = nsBinding{prefix: attr.Key, namespace: attr.Value}
 		} else if attr.Space == defaultPrefix && attr.Key == xmlnsPrefix {
 			// This attribute is a default namespace declaration
 
@@ -103,18 +140,33 @@ func (ctx NSContext) SubContext(el *etree.Element) (NSContext, error) {
 				return ctx, ErrInvalidDefaultNamespace
 			}
 
-			newCtx.declare(defaultPrefix, attr.Value)
+			binding = nsBinding{prefix: defaultPrefix, namespace: attr.Value}
+		} else {
+			continue
+		}
+
+		if frame == nil {
+			frame = &nsFrame{parent: ctx.frame}
 		}
+		frame.bindings = append(frame.bindings, binding)
 	}
 
-	return newCtx, nil
+	if frame == nil {
+		return ctx, nil
+	}
+
+	return NSContext{frame: frame}, nil
 }
 
 // Prefixes returns a copy of this context's prefix map.
 func (ctx NSContext) Prefixes() map[string]string {
-	prefixes := make(map[string]string, len(ctx.prefixes))
-	for k, v := range ctx.prefixes {
-		prefixes[k] = v
+	prefixes := make(map[string]string)
+	for f := ctx.frame; f != nil; f = f.parent {
+		for i := len(f.bindings) - 1; i >= 0; i-- {
+			if _, ok := prefixes[f.bindings[i].prefix]; !ok {
+				prefixes[f.bindings[i].prefix] = f.bindings[i].namespace
+			}
+		}
 	}
 
 	return prefixes
@@ -124,7 +176,7 @@ func (ctx NSContext) Prefixes() map[string]string {
 // is an empty string this will be the default namespace for this context. If the prefix is
 // undeclared in this context an ErrUndeclaredNSPrefix will be returned.
 func (ctx NSContext) LookupPrefix(prefix string) (string, error) {
-	if namespace, ok := ctx.prefixes[prefix]; ok {
+	if namespace, ok := ctx.lookup(prefix); ok {
 		return namespace, nil
 	}
 
@@ -151,7 +203,12 @@ func NSTraverse(ctx NSContext, el *etree.Element, handle NSIterHandler) error {
 	}
 
 	// Recursively traverse child elements.
-	for _, child := range el.ChildElements() {
+	for _, token := range el.Child {
+		child, ok := token.(*etree.Element)
+		if !ok {
+			continue
+		}
+
 		err := NSTraverse(ctx, child, handle)
 		if err != nil {
 			return err
@@ -188,7 +245,7 @@ func NSDetatch(ctx NSContext, el *etree.Element) (*etree.Element, error) {
 	}
 
 	// Append all in-context namespace declarations
-	for prefix, namespace := range ctx.prefixes {
+	for prefix, namespace := range ctx.Prefixes() {
 		// Skip the implicit "xml" and "xmlns" prefix declarations
 		if prefix == xmlnsPrefix || prefix == xmlPrefix {
 			continue
@@ -266,12 +323,9 @@ func NSFindIterate(el *etree.Element, namespace, tag string, handle NSIterHandle
 // returned by NSFindIterate.
 func NSFindIterateCtx(ctx NSContext, el *etree.Element, namespace, tag string, handle NSIterHandler) error {
 	err := NSTraverse(ctx, el, func(ctx NSContext, el *etree.Element) error {
-		_ctx, err := ctx.SubContext(el)
-		if err != nil {
-			return err
-		}
-
-		currentNS, err := _ctx.LookupPrefix(el.Space)
+		// NSTraverse passes the context of el itself, so it already
+		// includes any declaration of el's prefix.
+		currentNS, err := ctx.LookupPrefix(el.Space)
 		if err != nil {
 			return err
 		}
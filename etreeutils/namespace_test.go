@@ -0,0 +1,169 @@
+package etreeutils
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/beevik/etree"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNSContextScoping(t *testing.T) {
+	doc := etree.NewDocument()
+	err := doc.ReadFromString(`<a:Root xmlns:a="urn:a" xmlns="urn:default"><Plain><a:Inner xmlns:a="urn:inner" xmlns:b="urn:b"/></Plain><a:Sibling/></a:Root>`)
+	require.NoError(t, err)
+
+	root := doc.Root()
+	plain := root.SelectElement("Plain")
+	inner := plain.SelectElement("a:Inner")
+
+	rootCtx, err := DefaultNSContext.SubContext(root)
+	require.NoError(t, err)
+
+	// An element without declarations shares its parent's context.
+	plainCtx, err := rootCtx.SubContext(plain)
+	require.NoError(t, err)
+	require.Equal(t, rootCtx, plainCtx)
+
+	innerCtx, err := plainCtx.SubContext(inner)
+	require.NoError(t, err)
+
+	for prefix, expected := range map[string]string{"a": "urn:inner", "b": "urn:b", "": "urn:default", "xml": XMLNamespace} {
+		namespace, err := innerCtx.LookupPrefix(prefix)
+		require.NoError(t, err, prefix)
+		require.Equal(t, expected, namespace, prefix)
+	}
+
+	// Declarations on Inner do not leak into its ancestors' contexts.
+	namespace, err := rootCtx.LookupPrefix("a")
+	require.NoError(t, err)
+	require.Equal(t, "urn:a", namespace)
+
+	_, err = rootCtx.LookupPrefix("b")
+	require.Equal(t, ErrUndeclaredNSPrefix{Prefix: "b"}, err)
+
+	require.Equal(t, map[string]string{
+		"":      "urn:default",
+		"a":     "urn:inner",
+		"b":     "urn:b",
+		"xml":   XMLNamespace,
+		"xmlns": XMLNSNamespace,
+	}, innerCtx.Prefixes())
+
+	_, err = EmptyNSContext.LookupPrefix("")
+	require.Error(t, err)
+}
+
+func TestNSContextReservedDeclarations(t *testing.T) {
+	for _, attr := range []etree.Attr{
+		{Space: "xmlns", Key: "xml", Value: "urn:other"},
+		{Space: "xmlns", Key: "xmlns", Value: XMLNSNamespace},
+		{Key: "xmlns", Value: XMLNSNamespace},
+	} {
+		_, err := DefaultNSContext.SubContext(&etree.Element{Attr: []etree.Attr{attr}})
+		require.Error(t, err, attr.FullKey())
+	}
+}
+
+func TestNSFindIterate(t *testing.T) {
+	doc := etree.NewDocument()
+	err := doc.ReadFromString(`<Root xmlns:x="urn:x"><x:Item/><Nested xmlns:y="urn:x"><y:Item/><x:Item xmlns:x="urn:other"/></Nested></Root>`)
+	require.NoError(t, err)
+
+	var found []string
+	err = NSFindIterate(doc.Root(), "urn:x", "Item", func(ctx NSContext, el *etree.Element) error {
+		found = append(found, el.FullTag())
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"x:Item", "y:Item"}, found)
+}
+
+// deepDocument builds a document nested depth elements deep, with width
+// children at each level and a namespace declaration every few levels, in
+// the manner of a SAML response carrying nested assertions.
+func deepDocument(depth, width int) *etree.Element {
+	var b strings.Builder
+	for i := 0; i < depth; i++ {
+		if i%4 == 0 {
+			fmt.Fprintf(&b, `<p%d:Level xmlns:p%d="urn:level:%d">`, i, i, i)
+		} else {
+			b.WriteString(`<Level>`)
+		}
+		for j := 0; j < width; j++ {
+			fmt.Fprintf(&b, `<Leaf n="%d">text</Leaf>`, j)
+		}
+	}
+	for i := depth - 1; i >= 0; i-- {
+		if i%4 == 0 {
+			fmt.Fprintf(&b, `</p%d:Level>`, i)
+		} else {
+			b.WriteString(`</Level>`)
+		}
+	}
+
+	doc := etree.NewDocument()
+	if err := doc.ReadFromString(b.String()); err != nil {
+		panic(err)
+	}
+
+	return doc.Root()
+}
+
+func BenchmarkNSTraverse(b *testing.B) {
+	el := deepDocument(200, 50)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		err := NSTraverse(DefaultNSContext, el, func(ctx NSContext, el *etree.Element) error {
+			_, err := ctx.LookupPrefix(el.Space)
+			return err
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNSFindOne(b *testing.B) {
+	el := deepDocument(200, 50)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// The sought element is absent, so every element is visited.
+		found, err := NSFindOne(el, "urn:absent", "Level")
+		if err != nil || found != nil {
+			b.Fatal(found, err)
+		}
+	}
+}
+
+func BenchmarkExcC14nRenderer(b *testing.B) {
+	el := deepDocument(200, 50)
+	r := NewExcC14nRenderer("")
+
+	var render func(dst []etree.Attr, ctx, declared NSContext, el *etree.Element) error
+	render = func(dst []etree.Attr, ctx, declared NSContext, el *etree.Element) error {
+		dst, scope, declared, err := r.Attrs(dst[:0], ctx, declared, el)
+		if err != nil {
+			return err
+		}
+		for _, child := range el.ChildElements() {
+			if err := render(dst, scope, declared, child); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := render(nil, DefaultNSContext, DefaultNSContext, el); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
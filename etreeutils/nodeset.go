@@ -0,0 +1,163 @@
+package etreeutils
+
+import (
+	"github.com/beevik/etree"
+)
+
+// NodeSet is an XPath node-set drawn from an element tree, as produced by
+// the XPath based XMLDSig transforms. Nodes which are not in the set are
+// removed from the tree, with the exception of elements which are not in
+// the set but have descendants which are: those are kept in the tree and
+// recorded in Omitted. Namespace declarations are always kept, so that the
+// namespace context of every node in the set is preserved.
+type NodeSet struct {
+	// Root is the top level element of the tree, or nil if the set is empty.
+	Root *etree.Element
+
+	// Omitted holds the elements of the tree which are not themselves in
+	// the set.
+	Omitted map[*etree.Element]bool
+}
+
+// NewNodeSet returns a NodeSet containing root and all of its descendants.
+func NewNodeSet(root *etree.Element) *NodeSet {
+	return &NodeSet{
+		Root:    root,
+		Omitted: map[*etree.Element]bool{},
+	}
+}
+
+// IsSubtree reports whether the set is made up of Root and all of its
+// descendants, and so can be processed as a single element.
+func (ns *NodeSet) IsSubtree() bool {
+	return ns.Root != nil && len(ns.Omitted) == 0
+}
+
+// Contains reports whether n is in the set.
+func (ns *NodeSet) Contains(n XPathNode) bool {
+	switch n.kind {
+	case rootNode:
+		return false
+	case elementNode:
+		return !ns.Omitted[n.el]
+	case attributeNode:
+		return !ns.Omitted[n.el]
+	}
+	return true
+}
+
+// Retain removes every node from the set for which keep returns false. keep
+// is invoked with every node in the set before any are removed, so it may
+// evaluate XPath expressions against the unmodified tree.
+func (ns *NodeSet) Retain(keep func(XPathNode) (bool, error)) error {
+	if ns.Root == nil {
+		return nil
+	}
+
+	dropped := map[XPathNode]bool{}
+
+	var decide func(el *etree.Element) error
+	decide = func(el *etree.Element) error {
+		if !ns.Omitted[el] {
+			n := elementXPathNode(el)
+			ok, err := keep(n)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				dropped[n] = true
+			}
+
+			for i, attr := range el.Attr {
+				if isNamespaceDeclaration(attr) {
+					continue
+				}
+				n := XPathNode{kind: attributeNode, el: el, attr: i}
+				ok, err := keep(n)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					dropped[n] = true
+				}
+			}
+		}
+
+		for _, token := range el.Child {
+			if child, ok := token.(*etree.Element); ok {
+				if err := decide(child); err != nil {
+					return err
+				}
+				continue
+			}
+
+			n, ok := tokenXPathNode(token)
+			if !ok {
+				continue
+			}
+			keep, err := keep(n)
+			if err != nil {
+				return err
+			}
+			if !keep {
+				dropped[n] = true
+			}
+		}
+
+		return nil
+	}
+
+	if err := decide(ns.Root); err != nil {
+		return err
+	}
+
+	if !ns.prune(ns.Root, dropped) {
+		ns.Root = nil
+		ns.Omitted = map[*etree.Element]bool{}
+	}
+
+	return nil
+}
+
+// prune removes the dropped descendants of el, and reports whether el
+// itself remains in the tree.
+func (ns *NodeSet) prune(el *etree.Element, dropped map[XPathNode]bool) bool {
+	// Remove attributes before anything else, as attribute nodes are
+	// identified by their index.
+	attrs := el.Attr[:0]
+	for i, attr := range el.Attr {
+		if !dropped[XPathNode{kind: attributeNode, el: el, attr: i}] {
+			attrs = append(attrs, attr)
+		}
+	}
+	el.Attr = attrs
+
+	for i := 0; i < len(el.Child); {
+		token := el.Child[i]
+
+		remove := false
+		if child, ok := token.(*etree.Element); ok {
+			remove = !ns.prune(child, dropped)
+		} else if n, ok := tokenXPathNode(token); ok {
+			remove = dropped[n]
+		}
+
+		if remove {
+			el.RemoveChildAt(i)
+			continue
+		}
+		i++
+	}
+
+	if !dropped[elementXPathNode(el)] && !ns.Omitted[el] {
+		return true
+	}
+
+	if len(el.Child) == 0 {
+		delete(ns.Omitted, el)
+		return false
+	}
+
+	ns.Omitted[el] = true
+	return true
+}
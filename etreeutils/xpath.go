@@ -0,0 +1,864 @@
+package etreeutils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// XPath is a compiled XPath 1.0 expression. Only the subset of XPath needed
+// by XML signature transforms is supported: location paths over all axes
+// except namespace, predicates, the operators and the core function library
+// (excluding lang), plus the here() function defined by XMLDSig. Variable
+// references are not supported.
+type XPath struct {
+	source string
+	expr   xpathExpr
+	depth  int
+}
+
+// ErrXPathSyntax indicates that an XPath expression could not be compiled.
+type ErrXPathSyntax struct {
+	Expression string
+	Reason     string
+}
+
+func (e ErrXPathSyntax) Error() string {
+	return fmt.Sprintf("invalid XPath expression '%s': %s", e.Expression, e.Reason)
+}
+
+const (
+	// maxXPathLength and maxXPathDepth bound the size of the expressions
+	// accepted by CompileXPath.
+	maxXPathLength = 4096
+	maxXPathDepth  = 32
+)
+
+// CompileXPath compiles an XPath expression. Namespace prefixes used in
+// the expression are resolved against ctx, which is normally the context of
+// the element the expression was read from. As in XPath 1.0, unprefixed
+// names never match elements in a default namespace. Expressions which are
+// very long or deeply nested are rejected with ErrXPathTooComplex.
+func CompileXPath(ctx NSContext, expr string) (*XPath, error) {
+	if len(expr) > maxXPathLength {
+		return nil, ErrXPathTooComplex
+	}
+
+	tokens, err := lexXPath(expr)
+	if err != nil {
+		return nil, ErrXPathSyntax{Expression: expr, Reason: err.Error()}
+	}
+
+	p := &xpathParser{
+		ctx:    ctx,
+		tokens: tokens,
+	}
+
+	e, err := p.parseExpr()
+	if err == ErrXPathTooComplex {
+		return nil, err
+	} else if err != nil {
+		return nil, ErrXPathSyntax{Expression: expr, Reason: err.Error()}
+	}
+
+	if p.peek().kind != tokEOF {
+		return nil, ErrXPathSyntax{Expression: expr, Reason: fmt.Sprintf("unexpected '%s'", p.peek().value)}
+	}
+
+	return &XPath{source: expr, expr: e, depth: p.maxDepth}, nil
+}
+
+// String returns the source of the expression.
+func (x *XPath) String() string {
+	return x.source
+}
+
+// Depth returns the nesting depth of the expression: one for an expression
+// without subexpressions, plus one for each level of parentheses, function
+// arguments or predicates.
+func (x *XPath) Depth() int {
+	return x.depth
+}
+
+type xpathTokenKind int
+
+const (
+	tokEOF xpathTokenKind = iota
+	tokLParen
+	tokRParen
+	tokLBracket
+	tokRBracket
+	tokDot
+	tokDotDot
+	tokAt
+	tokComma
+	tokColonColon
+	tokSlash
+	tokDoubleSlash
+	tokPipe
+	tokPlus
+	tokMinus
+	tokEq
+	tokNeq
+	tokLt
+	tokLte
+	tokGt
+	tokGte
+	tokMultiply
+	tokOperatorName
+	tokNameTest
+	tokLiteral
+	tokNumber
+	tokVariable
+)
+
+type xpathToken struct {
+	kind  xpathTokenKind
+	value string
+}
+
+// precedesOperator reports whether a token of kind may be followed by an
+// operator. This implements the disambiguation rules of XPath 1.0 section
+// 3.7 for '*' and the operator names.
+func precedesOperator(kind xpathTokenKind) bool {
+	switch kind {
+	case tokRParen, tokRBracket, tokDot, tokDotDot, tokNameTest, tokLiteral, tokNumber, tokVariable:
+		return true
+	}
+	return false
+}
+
+func isNameStartChar(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isNameChar(r rune) bool {
+	return isNameStartChar(r) || r == '-' || r == '.' || unicode.IsDigit(r) ||
+		unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Mc, r)
+}
+
+func lexXPath(expr string) ([]xpathToken, error) {
+	var tokens []xpathToken
+
+	emit := func(kind xpathTokenKind, value string) {
+		tokens = append(tokens, xpathToken{kind: kind, value: value})
+	}
+
+	operatorContext := func() bool {
+		return len(tokens) > 0 && precedesOperator(tokens[len(tokens)-1].kind)
+	}
+
+	scanName := func(i int) int {
+		for i < len(expr) {
+			r, size := utf8.DecodeRuneInString(expr[i:])
+			if !isNameChar(r) {
+				break
+			}
+			i += size
+		}
+		return i
+	}
+
+	for i := 0; i < len(expr); {
+		r, size := utf8.DecodeRuneInString(expr[i:])
+
+		switch {
+		case r == ' ' || r == '\t' || r == '\r' || r == '\n':
+			i += size
+
+		case r == '(':
+			emit(tokLParen, "(")
+			i++
+		case r == ')':
+			emit(tokRParen, ")")
+			i++
+		case r == '[':
+			emit(tokLBracket, "[")
+			i++
+		case r == ']':
+			emit(tokRBracket, "]")
+			i++
+		case r == '@':
+			emit(tokAt, "@")
+			i++
+		case r == ',':
+			emit(tokComma, ",")
+			i++
+		case r == '|':
+			emit(tokPipe, "|")
+			i++
+		case r == '+':
+			emit(tokPlus, "+")
+			i++
+		case r == '-':
+			emit(tokMinus, "-")
+			i++
+		case r == '=':
+			emit(tokEq, "=")
+			i++
+
+		case r == '!':
+			if !strings.HasPrefix(expr[i:], "!=") {
+				return nil, fmt.Errorf("unexpected '!' at offset %d", i)
+			}
+			emit(tokNeq, "!=")
+			i += 2
+
+		case r == '<':
+			if strings.HasPrefix(expr[i:], "<=") {
+				emit(tokLte, "<=")
+				i += 2
+			} else {
+				emit(tokLt, "<")
+				i++
+			}
+
+		case r == '>':
+			if strings.HasPrefix(expr[i:], ">=") {
+				emit(tokGte, ">=")
+				i += 2
+			} else {
+				emit(tokGt, ">")
+				i++
+			}
+
+		case r == '/':
+			if strings.HasPrefix(expr[i:], "//") {
+				emit(tokDoubleSlash, "//")
+				i += 2
+			} else {
+				emit(tokSlash, "/")
+				i++
+			}
+
+		case r == ':':
+			if !strings.HasPrefix(expr[i:], "::") {
+				return nil, fmt.Errorf("unexpected ':' at offset %d", i)
+			}
+			emit(tokColonColon, "::")
+			i += 2
+
+		case r == '"' || r == '\'':
+			end := strings.IndexRune(expr[i+1:], r)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string literal at offset %d", i)
+			}
+			emit(tokLiteral, expr[i+1:i+1+end])
+			i += end + 2
+
+		case r == '.' || unicode.IsDigit(r):
+			if strings.HasPrefix(expr[i:], "..") {
+				emit(tokDotDot, "..")
+				i += 2
+				continue
+			}
+
+			j := i
+			for j < len(expr) && expr[j] >= '0' && expr[j] <= '9' {
+				j++
+			}
+			if j < len(expr) && expr[j] == '.' {
+				j++
+				for j < len(expr) && expr[j] >= '0' && expr[j] <= '9' {
+					j++
+				}
+			}
+
+			if expr[i:j] == "." {
+				emit(tokDot, ".")
+			} else {
+				emit(tokNumber, expr[i:j])
+			}
+			i = j
+
+		case r == '*':
+			if operatorContext() {
+				emit(tokMultiply, "*")
+			} else {
+				emit(tokNameTest, "*")
+			}
+			i++
+
+		case r == '$':
+			j := scanName(i + 1)
+			if j == i+1 {
+				return nil, fmt.Errorf("invalid variable reference at offset %d", i)
+			}
+			emit(tokVariable, expr[i+1:j])
+			i = j
+
+		case isNameStartChar(r):
+			j := scanName(i)
+			name := expr[i:j]
+
+			if operatorContext() {
+				switch name {
+				case "and", "or", "div", "mod":
+					emit(tokOperatorName, name)
+					i = j
+					continue
+				}
+				return nil, fmt.Errorf("unexpected name '%s' at offset %d", name, i)
+			}
+
+			// A single colon introduces the local part of a QName, or a
+			// wildcard in the form prefix:*.
+			if j < len(expr) && expr[j] == ':' && !strings.HasPrefix(expr[j:], "::") {
+				if strings.HasPrefix(expr[j+1:], "*") {
+					name += ":*"
+					j += 2
+				} else {
+					k := scanName(j + 1)
+					if k == j+1 {
+						return nil, fmt.Errorf("invalid QName at offset %d", i)
+					}
+					name = expr[i:k]
+					j = k
+				}
+			}
+
+			emit(tokNameTest, name)
+			i = j
+
+		default:
+			return nil, fmt.Errorf("unexpected '%c' at offset %d", r, i)
+		}
+	}
+
+	emit(tokEOF, "")
+
+	return tokens, nil
+}
+
+type xpathAxis int
+
+const (
+	axisAncestor xpathAxis = iota
+	axisAncestorOrSelf
+	axisAttribute
+	axisChild
+	axisDescendant
+	axisDescendantOrSelf
+	axisFollowing
+	axisFollowingSibling
+	axisParent
+	axisPreceding
+	axisPrecedingSibling
+	axisSelf
+)
+
+var xpathAxes = map[string]xpathAxis{
+	"ancestor":           axisAncestor,
+	"ancestor-or-self":   axisAncestorOrSelf,
+	"attribute":          axisAttribute,
+	"child":              axisChild,
+	"descendant":         axisDescendant,
+	"descendant-or-self": axisDescendantOrSelf,
+	"following":          axisFollowing,
+	"following-sibling":  axisFollowingSibling,
+	"parent":             axisParent,
+	"preceding":          axisPreceding,
+	"preceding-sibling":  axisPrecedingSibling,
+	"self":               axisSelf,
+}
+
+// reverse reports whether the axis is a reverse axis, whose proximity
+// positions run opposite to document order.
+func (a xpathAxis) reverse() bool {
+	switch a {
+	case axisAncestor, axisAncestorOrSelf, axisPreceding, axisPrecedingSibling:
+		return true
+	}
+	return false
+}
+
+type nodeTestKind int
+
+const (
+	testName nodeTestKind = iota
+	testNode
+	testText
+	testComment
+	testProcInst
+)
+
+// nodeTest is a compiled node test. For name tests an empty local name
+// matches any name in namespace, and anyNamespace additionally matches
+// names in every namespace.
+type nodeTest struct {
+	kind         nodeTestKind
+	namespace    string
+	local        string
+	anyNamespace bool
+}
+
+type xpathExpr interface{}
+
+type binaryExpr struct {
+	op          string
+	left, right xpathExpr
+}
+
+type negateExpr struct {
+	operand xpathExpr
+}
+
+type literalExpr string
+
+type numberExpr float64
+
+type functionCall struct {
+	name string
+	args []xpathExpr
+}
+
+type filterExpr struct {
+	primary    xpathExpr
+	predicates []xpathExpr
+}
+
+type step struct {
+	axis       xpathAxis
+	test       nodeTest
+	predicates []xpathExpr
+}
+
+// pathExpr is a location path, or a filter expression followed by a
+// relative location path when filter is set.
+type pathExpr struct {
+	filter   xpathExpr
+	absolute bool
+	steps    []*step
+}
+
+type xpathParser struct {
+	ctx      NSContext
+	tokens   []xpathToken
+	pos      int
+	depth    int
+	maxDepth int
+}
+
+func (p *xpathParser) peek() xpathToken {
+	return p.tokens[p.pos]
+}
+
+func (p *xpathParser) peekAt(offset int) xpathToken {
+	if p.pos+offset >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos+offset]
+}
+
+func (p *xpathParser) next() xpathToken {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *xpathParser) expect(kind xpathTokenKind, value string) error {
+	if t := p.next(); t.kind != kind {
+		if t.kind == tokEOF {
+			return fmt.Errorf("expected '%s' but found end of expression", value)
+		}
+		return fmt.Errorf("expected '%s' but found '%s'", value, t.value)
+	}
+	return nil
+}
+
+func (p *xpathParser) parseExpr() (xpathExpr, error) {
+	p.depth++
+	defer func() {
+		p.depth--
+	}()
+
+	if p.depth > maxXPathDepth {
+		return nil, ErrXPathTooComplex
+	}
+	if p.depth > p.maxDepth {
+		p.maxDepth = p.depth
+	}
+
+	return p.parseOr()
+}
+
+func (p *xpathParser) parseBinary(operand func() (xpathExpr, error), match func(xpathToken) bool) (xpathExpr, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+
+	for match(p.peek()) {
+		op := p.next().value
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		left = &binaryExpr{op: op, left: left, right: right}
+	}
+
+	return left, nil
+}
+
+func operatorName(name string) func(xpathToken) bool {
+	return func(t xpathToken) bool {
+		return t.kind == tokOperatorName && t.value == name
+	}
+}
+
+func tokenKinds(kinds ...xpathTokenKind) func(xpathToken) bool {
+	return func(t xpathToken) bool {
+		for _, kind := range kinds {
+			if t.kind == kind {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func (p *xpathParser) parseOr() (xpathExpr, error) {
+	return p.parseBinary(p.parseAnd, operatorName("or"))
+}
+
+func (p *xpathParser) parseAnd() (xpathExpr, error) {
+	return p.parseBinary(p.parseEquality, operatorName("and"))
+}
+
+func (p *xpathParser) parseEquality() (xpathExpr, error) {
+	return p.parseBinary(p.parseRelational, tokenKinds(tokEq, tokNeq))
+}
+
+func (p *xpathParser) parseRelational() (xpathExpr, error) {
+	return p.parseBinary(p.parseAdditive, tokenKinds(tokLt, tokLte, tokGt, tokGte))
+}
+
+func (p *xpathParser) parseAdditive() (xpathExpr, error) {
+	return p.parseBinary(p.parseMultiplicative, tokenKinds(tokPlus, tokMinus))
+}
+
+func (p *xpathParser) parseMultiplicative() (xpathExpr, error) {
+	return p.parseBinary(p.parseUnary, func(t xpathToken) bool {
+		return t.kind == tokMultiply || (t.kind == tokOperatorName && (t.value == "div" || t.value == "mod"))
+	})
+}
+
+func (p *xpathParser) parseUnary() (xpathExpr, error) {
+	if p.peek().kind == tokMinus {
+		p.next()
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &negateExpr{operand: operand}, nil
+	}
+
+	return p.parseBinary(p.parsePath, tokenKinds(tokPipe))
+}
+
+var nodeTypes = map[string]nodeTestKind{
+	"node":                   testNode,
+	"text":                   testText,
+	"comment":                testComment,
+	"processing-instruction": testProcInst,
+}
+
+// startsFilterExpr reports whether the upcoming tokens begin a
+// FilterExpr rather than a LocationPath.
+func (p *xpathParser) startsFilterExpr() bool {
+	t := p.peek()
+	switch t.kind {
+	case tokLParen, tokLiteral, tokNumber, tokVariable:
+		return true
+	case tokNameTest:
+		if p.peekAt(1).kind != tokLParen {
+			return false
+		}
+		_, isNodeType := nodeTypes[t.value]
+		return !isNodeType
+	}
+	return false
+}
+
+func (p *xpathParser) parsePath() (xpathExpr, error) {
+	if !p.startsFilterExpr() {
+		return p.parseLocationPath()
+	}
+
+	primary, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+
+	predicates, err := p.parsePredicates()
+	if err != nil {
+		return nil, err
+	}
+
+	var e xpathExpr = primary
+	if len(predicates) > 0 {
+		e = &filterExpr{primary: primary, predicates: predicates}
+	}
+
+	switch p.peek().kind {
+	case tokSlash, tokDoubleSlash:
+		path := &pathExpr{filter: e}
+		if err := p.parseRelativeLocationPath(path); err != nil {
+			return nil, err
+		}
+		return path, nil
+	}
+
+	return e, nil
+}
+
+func (p *xpathParser) parsePrimary() (xpathExpr, error) {
+	t := p.next()
+
+	switch t.kind {
+	case tokLParen:
+		e, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tokRParen, ")"); err != nil {
+			return nil, err
+		}
+		return e, nil
+
+	case tokLiteral:
+		return literalExpr(t.value), nil
+
+	case tokNumber:
+		n, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number '%s'", t.value)
+		}
+		return numberExpr(n), nil
+
+	case tokVariable:
+		return nil, fmt.Errorf("variable references are not supported: $%s", t.value)
+
+	case tokNameTest:
+		return p.parseFunctionCall(t.value)
+	}
+
+	return nil, fmt.Errorf("unexpected '%s'", t.value)
+}
+
+func (p *xpathParser) parseFunctionCall(name string) (xpathExpr, error) {
+	fn, ok := xpathFunctions[name]
+	if !ok {
+		return nil, fmt.Errorf("unsupported function '%s'", name)
+	}
+
+	if err := p.expect(tokLParen, "("); err != nil {
+		return nil, err
+	}
+
+	var args []xpathExpr
+	if p.peek().kind != tokRParen {
+		for {
+			arg, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+
+			if p.peek().kind != tokComma {
+				break
+			}
+			p.next()
+		}
+	}
+
+	if err := p.expect(tokRParen, ")"); err != nil {
+		return nil, err
+	}
+
+	if len(args) < fn.minArgs || (fn.maxArgs >= 0 && len(args) > fn.maxArgs) {
+		return nil, fmt.Errorf("wrong number of arguments to %s()", name)
+	}
+
+	return &functionCall{name: name, args: args}, nil
+}
+
+func (p *xpathParser) parsePredicates() ([]xpathExpr, error) {
+	var predicates []xpathExpr
+
+	for p.peek().kind == tokLBracket {
+		p.next()
+
+		predicate, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+
+		if err := p.expect(tokRBracket, "]"); err != nil {
+			return nil, err
+		}
+
+		predicates = append(predicates, predicate)
+	}
+
+	return predicates, nil
+}
+
+func (p *xpathParser) parseLocationPath() (xpathExpr, error) {
+	path := &pathExpr{}
+
+	switch p.peek().kind {
+	case tokSlash:
+		p.next()
+		path.absolute = true
+
+		// A lone '/' selects the root node.
+		if !p.startsStep() {
+			return path, nil
+		}
+
+	case tokDoubleSlash:
+		p.next()
+		path.absolute = true
+		path.steps = append(path.steps, descendantOrSelfStep())
+	}
+
+	s, err := p.parseStep()
+	if err != nil {
+		return nil, err
+	}
+	path.steps = append(path.steps, s)
+
+	if err := p.parseRelativeLocationPath(path); err != nil {
+		return nil, err
+	}
+
+	return path, nil
+}
+
+// parseRelativeLocationPath parses any number of steps preceded by '/' or
+// '//' and appends them to path.
+func (p *xpathParser) parseRelativeLocationPath(path *pathExpr) error {
+	for {
+		switch p.peek().kind {
+		case tokSlash:
+			p.next()
+		case tokDoubleSlash:
+			p.next()
+			path.steps = append(path.steps, descendantOrSelfStep())
+		default:
+			return nil
+		}
+
+		s, err := p.parseStep()
+		if err != nil {
+			return err
+		}
+		path.steps = append(path.steps, s)
+	}
+}
+
+func descendantOrSelfStep() *step {
+	return &step{axis: axisDescendantOrSelf, test: nodeTest{kind: testNode}}
+}
+
+func (p *xpathParser) startsStep() bool {
+	switch p.peek().kind {
+	case tokDot, tokDotDot, tokAt, tokNameTest:
+		return true
+	}
+	return false
+}
+
+func (p *xpathParser) parseStep() (*step, error) {
+	switch p.peek().kind {
+	case tokDot:
+		p.next()
+		return &step{axis: axisSelf, test: nodeTest{kind: testNode}}, nil
+	case tokDotDot:
+		p.next()
+		return &step{axis: axisParent, test: nodeTest{kind: testNode}}, nil
+	}
+
+	s := &step{axis: axisChild}
+
+	if p.peek().kind == tokAt {
+		p.next()
+		s.axis = axisAttribute
+	} else if p.peek().kind == tokNameTest && p.peekAt(1).kind == tokColonColon {
+		name := p.next().value
+		axis, ok := xpathAxes[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported axis '%s'", name)
+		}
+		p.next()
+		s.axis = axis
+	}
+
+	test, err := p.parseNodeTest()
+	if err != nil {
+		return nil, err
+	}
+	s.test = test
+
+	s.predicates, err = p.parsePredicates()
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (p *xpathParser) parseNodeTest() (nodeTest, error) {
+	t := p.next()
+	if t.kind != tokNameTest {
+		if t.kind == tokEOF {
+			return nodeTest{}, fmt.Errorf("expected a node test but found end of expression")
+		}
+		return nodeTest{}, fmt.Errorf("expected a node test but found '%s'", t.value)
+	}
+
+	if kind, ok := nodeTypes[t.value]; ok && p.peek().kind == tokLParen {
+		p.next()
+
+		// processing-instruction() may name its target, which we do not
+		// distinguish.
+		if kind == testProcInst && p.peek().kind == tokLiteral {
+			p.next()
+		}
+
+		if err := p.expect(tokRParen, ")"); err != nil {
+			return nodeTest{}, err
+		}
+
+		return nodeTest{kind: kind}, nil
+	}
+
+	if t.value == "*" {
+		return nodeTest{kind: testName, anyNamespace: true}, nil
+	}
+
+	prefix, local := "", t.value
+	if i := strings.IndexByte(t.value, ':'); i >= 0 {
+		prefix, local = t.value[:i], t.value[i+1:]
+	}
+
+	if prefix == "" {
+		return nodeTest{kind: testName, local: local}, nil
+	}
+
+	namespace, err := p.ctx.LookupPrefix(prefix)
+	if err != nil {
+		return nodeTest{}, err
+	}
+
+	if local == "*" {
+		local = ""
+	}
+
+	return nodeTest{kind: testName, namespace: namespace, local: local}, nil
+}
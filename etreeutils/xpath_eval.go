@@ -0,0 +1,1299 @@
+package etreeutils
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/beevik/etree"
+)
+
+var (
+	// ErrXPathNotNodeSet is returned when an expression which must select
+	// nodes evaluates to a string, number or boolean.
+	ErrXPathNotNodeSet = errors.New("XPath expression does not evaluate to a node-set")
+
+	// ErrXPathHereUnavailable is returned when an expression using here() is
+	// evaluated against a document for which no here node was set.
+	ErrXPathHereUnavailable = errors.New("XPath here() is not available in this context")
+
+	// ErrXPathTooComplex is returned when an expression is too large to be
+	// compiled, or when evaluating expressions against an XPathDocument
+	// exceeds its MaxWork.
+	ErrXPathTooComplex = errors.New("XPath expression exceeds the complexity limit")
+)
+
+// DefaultXPathMaxWork is the default MaxWork of an XPathDocument.
+const DefaultXPathMaxWork = 10000000
+
+type xpathNodeKind int
+
+const (
+	rootNode xpathNodeKind = iota
+	elementNode
+	attributeNode
+	textNode
+	commentNode
+	procInstNode
+)
+
+// XPathNode is a node of the XPath data model of an element tree: the root
+// node, an element, an attribute, or a text, comment or processing
+// instruction token. Namespace declarations are not modeled as attributes.
+// XPathNodes are comparable, and equal nodes refer to the same node.
+type XPathNode struct {
+	kind  xpathNodeKind
+	el    *etree.Element
+	token etree.Token
+	attr  int
+}
+
+// Element returns the element of an element node, or the element owning an
+// attribute node. It returns nil for other nodes.
+func (n XPathNode) Element() *etree.Element {
+	switch n.kind {
+	case elementNode, attributeNode:
+		return n.el
+	}
+	return nil
+}
+
+// Attr returns the attribute of an attribute node, or nil.
+func (n XPathNode) Attr() *etree.Attr {
+	if n.kind != attributeNode {
+		return nil
+	}
+	return &n.el.Attr[n.attr]
+}
+
+// Token returns the token of an element, text, comment or processing
+// instruction node, or nil for the root node and attribute nodes.
+func (n XPathNode) Token() etree.Token {
+	switch n.kind {
+	case elementNode:
+		return n.el
+	case textNode, commentNode, procInstNode:
+		return n.token
+	}
+	return nil
+}
+
+func elementXPathNode(el *etree.Element) XPathNode {
+	return XPathNode{kind: elementNode, el: el}
+}
+
+func tokenXPathNode(token etree.Token) (XPathNode, bool) {
+	switch token := token.(type) {
+	case *etree.Element:
+		return elementXPathNode(token), true
+	case *etree.CharData:
+		return XPathNode{kind: textNode, token: token}, true
+	case *etree.Comment:
+		return XPathNode{kind: commentNode, token: token}, true
+	case *etree.ProcInst:
+		return XPathNode{kind: procInstNode, token: token}, true
+	}
+	return XPathNode{}, false
+}
+
+func isNamespaceDeclaration(attr etree.Attr) bool {
+	return attr.Space == xmlnsPrefix || (attr.Space == defaultPrefix && attr.Key == xmlnsPrefix)
+}
+
+// XPathDocument indexes an element tree so that XPath expressions can be
+// evaluated against it. The element passed to NewXPathDocument is treated
+// as the document element, below the XPath root node. The tree must not be
+// modified while the XPathDocument is in use.
+type XPathDocument struct {
+	// MaxWork bounds the total work done by evaluating expressions against
+	// the document, measured in nodes visited. Once it is exceeded
+	// evaluation fails with ErrXPathTooComplex. Zero means no limit.
+	MaxWork int
+
+	root     *etree.Element
+	contexts map[*etree.Element]NSContext
+	order    map[XPathNode]int
+	extent   map[*etree.Element]int
+	nodes    []XPathNode
+	here     *XPathNode
+	work     int
+}
+
+// NewXPathDocument indexes the tree rooted at root. ctx is the namespace
+// context surrounding root.
+func NewXPathDocument(ctx NSContext, root *etree.Element) (*XPathDocument, error) {
+	d := &XPathDocument{
+		MaxWork:  DefaultXPathMaxWork,
+		root:     root,
+		contexts: map[*etree.Element]NSContext{},
+		order:    map[XPathNode]int{},
+		extent:   map[*etree.Element]int{},
+	}
+
+	err := NSTraverse(ctx, root, func(ctx NSContext, el *etree.Element) error {
+		d.contexts[el] = ctx
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	d.add(d.Root())
+	d.index(root)
+
+	return d, nil
+}
+
+func (d *XPathDocument) add(n XPathNode) {
+	d.order[n] = len(d.nodes)
+	d.nodes = append(d.nodes, n)
+}
+
+func (d *XPathDocument) index(el *etree.Element) {
+	start := len(d.nodes)
+	defer func() {
+		d.extent[el] = len(d.nodes) - start
+	}()
+
+	d.add(elementXPathNode(el))
+
+	for i, attr := range el.Attr {
+		if !isNamespaceDeclaration(attr) {
+			d.add(XPathNode{kind: attributeNode, el: el, attr: i})
+		}
+	}
+
+	for _, token := range el.Child {
+		if child, ok := token.(*etree.Element); ok {
+			d.index(child)
+		} else if n, ok := tokenXPathNode(token); ok {
+			d.add(n)
+		}
+	}
+}
+
+// Root returns the root node of the document.
+func (d *XPathDocument) Root() XPathNode {
+	return XPathNode{kind: rootNode, el: d.root}
+}
+
+// Nodes returns every node of the document other than the root node, in
+// document order.
+func (d *XPathDocument) Nodes() []XPathNode {
+	return d.nodes[1:]
+}
+
+// Contains reports whether n is a node of the document.
+func (d *XPathDocument) Contains(n XPathNode) bool {
+	_, ok := d.order[n]
+	return ok
+}
+
+// SetHere sets the node returned by the XMLDSig here() function to the
+// text content of el, which is normally the element the expression was
+// read from. It reports whether el is part of the document; if it is not,
+// here() is unavailable until SetHere is called again.
+func (d *XPathDocument) SetHere(el *etree.Element) bool {
+	d.here = nil
+
+	if _, ok := d.contexts[el]; !ok {
+		return false
+	}
+
+	here := elementXPathNode(el)
+	for _, token := range el.Child {
+		if _, ok := token.(*etree.CharData); ok {
+			here, _ = tokenXPathNode(token)
+			break
+		}
+	}
+
+	d.here = &here
+
+	return true
+}
+
+// Parent returns the parent of n. Attribute nodes have their owner element
+// as parent. The root node has no parent.
+func (d *XPathDocument) Parent(n XPathNode) (XPathNode, bool) {
+	var parent *etree.Element
+
+	switch n.kind {
+	case rootNode:
+		return XPathNode{}, false
+	case attributeNode:
+		return elementXPathNode(n.el), true
+	case elementNode:
+		if n.el == d.root {
+			return d.Root(), true
+		}
+		parent = n.el.Parent()
+	default:
+		parent = n.token.Parent()
+	}
+
+	if parent == nil {
+		return XPathNode{}, false
+	}
+
+	return elementXPathNode(parent), true
+}
+
+func (d *XPathDocument) children(n XPathNode) []XPathNode {
+	switch n.kind {
+	case rootNode:
+		return []XPathNode{elementXPathNode(d.root)}
+	case elementNode:
+		children := make([]XPathNode, 0, len(n.el.Child))
+		for _, token := range n.el.Child {
+			if child, ok := tokenXPathNode(token); ok {
+				children = append(children, child)
+			}
+		}
+		return children
+	}
+	return nil
+}
+
+func (d *XPathDocument) attributes(n XPathNode) []XPathNode {
+	if n.kind != elementNode {
+		return nil
+	}
+
+	var attrs []XPathNode
+	for i, attr := range n.el.Attr {
+		if !isNamespaceDeclaration(attr) {
+			attrs = append(attrs, XPathNode{kind: attributeNode, el: n.el, attr: i})
+		}
+	}
+	return attrs
+}
+
+func (d *XPathDocument) descendants(n XPathNode, nodes []XPathNode) []XPathNode {
+	for _, child := range d.children(n) {
+		nodes = append(nodes, child)
+		nodes = d.descendants(child, nodes)
+	}
+	return nodes
+}
+
+func (d *XPathDocument) isAncestor(ancestor, n XPathNode) bool {
+	for {
+		parent, ok := d.Parent(n)
+		if !ok {
+			return false
+		}
+		if parent == ancestor {
+			return true
+		}
+		n = parent
+	}
+}
+
+func (d *XPathDocument) siblings(n XPathNode) []XPathNode {
+	if n.kind == rootNode || n.kind == attributeNode {
+		return nil
+	}
+
+	parent, ok := d.Parent(n)
+	if !ok {
+		return nil
+	}
+
+	return d.children(parent)
+}
+
+func (d *XPathDocument) axis(axis xpathAxis, n XPathNode) []XPathNode {
+	switch axis {
+	case axisSelf:
+		return []XPathNode{n}
+
+	case axisChild:
+		return d.children(n)
+
+	case axisAttribute:
+		return d.attributes(n)
+
+	case axisDescendant:
+		return d.descendants(n, nil)
+
+	case axisDescendantOrSelf:
+		return d.descendants(n, []XPathNode{n})
+
+	case axisParent:
+		if parent, ok := d.Parent(n); ok {
+			return []XPathNode{parent}
+		}
+		return nil
+
+	case axisAncestor, axisAncestorOrSelf:
+		var nodes []XPathNode
+		if axis == axisAncestorOrSelf {
+			nodes = append(nodes, n)
+		}
+		for {
+			parent, ok := d.Parent(n)
+			if !ok {
+				return nodes
+			}
+			nodes = append(nodes, parent)
+			n = parent
+		}
+
+	case axisFollowingSibling, axisPrecedingSibling:
+		siblings := d.siblings(n)
+		for i, sibling := range siblings {
+			if sibling != n {
+				continue
+			}
+			if axis == axisFollowingSibling {
+				return siblings[i+1:]
+			}
+			preceding := make([]XPathNode, 0, i)
+			for j := i - 1; j >= 0; j-- {
+				preceding = append(preceding, siblings[j])
+			}
+			return preceding
+		}
+		return nil
+
+	case axisFollowing:
+		d.work += len(d.nodes)
+		var nodes []XPathNode
+		for _, m := range d.nodes[d.order[n]+1:] {
+			if m.kind != attributeNode && !d.isAncestor(n, m) {
+				nodes = append(nodes, m)
+			}
+		}
+		return nodes
+
+	case axisPreceding:
+		d.work += len(d.nodes)
+		var nodes []XPathNode
+		for i := d.order[n] - 1; i > 0; i-- {
+			m := d.nodes[i]
+			if m.kind != attributeNode && !d.isAncestor(m, n) {
+				nodes = append(nodes, m)
+			}
+		}
+		return nodes
+	}
+
+	return nil
+}
+
+func (d *XPathDocument) elementNamespace(el *etree.Element) string {
+	namespace, err := d.contexts[el].LookupPrefix(el.Space)
+	if err != nil {
+		return ""
+	}
+
+	// Unprefixed elements are only in a namespace if a default namespace
+	// has been declared.
+	if el.Space == defaultPrefix && namespace == XMLNamespace {
+		return ""
+	}
+
+	return namespace
+}
+
+func (d *XPathDocument) namespaceURI(n XPathNode) string {
+	switch n.kind {
+	case elementNode:
+		return d.elementNamespace(n.el)
+
+	case attributeNode:
+		attr := n.el.Attr[n.attr]
+		switch attr.Space {
+		case defaultPrefix:
+			return ""
+		case xmlPrefix:
+			return XMLNamespace
+		}
+		namespace, err := d.contexts[n.el].LookupPrefix(attr.Space)
+		if err != nil {
+			return ""
+		}
+		return namespace
+	}
+
+	return ""
+}
+
+func (d *XPathDocument) localName(n XPathNode) string {
+	switch n.kind {
+	case elementNode:
+		return n.el.Tag
+	case attributeNode:
+		return n.el.Attr[n.attr].Key
+	case procInstNode:
+		return n.token.(*etree.ProcInst).Target
+	}
+	return ""
+}
+
+func (d *XPathDocument) name(n XPathNode) string {
+	switch n.kind {
+	case elementNode:
+		return n.el.FullTag()
+	case attributeNode:
+		return n.el.Attr[n.attr].FullKey()
+	}
+	return d.localName(n)
+}
+
+func (d *XPathDocument) stringValue(n XPathNode) string {
+	switch n.kind {
+	case rootNode:
+		d.work += len(d.nodes)
+		return textContent(d.root)
+	case elementNode:
+		d.work += d.extent[n.el]
+		return textContent(n.el)
+	case attributeNode:
+		return n.el.Attr[n.attr].Value
+	case textNode:
+		return n.token.(*etree.CharData).Data
+	case commentNode:
+		return n.token.(*etree.Comment).Data
+	case procInstNode:
+		return n.token.(*etree.ProcInst).Inst
+	}
+	return ""
+}
+
+func textContent(el *etree.Element) string {
+	var b strings.Builder
+
+	var walk func(el *etree.Element)
+	walk = func(el *etree.Element) {
+		for _, token := range el.Child {
+			switch token := token.(type) {
+			case *etree.CharData:
+				b.WriteString(token.Data)
+			case *etree.Element:
+				walk(token)
+			}
+		}
+	}
+	walk(el)
+
+	return b.String()
+}
+
+func (d *XPathDocument) matches(test nodeTest, axis xpathAxis, n XPathNode) bool {
+	switch test.kind {
+	case testNode:
+		return true
+	case testText:
+		return n.kind == textNode
+	case testComment:
+		return n.kind == commentNode
+	case testProcInst:
+		return n.kind == procInstNode
+	}
+
+	// Name tests only match the principal node type of the axis.
+	principal := elementNode
+	if axis == axisAttribute {
+		principal = attributeNode
+	}
+	if n.kind != principal {
+		return false
+	}
+
+	if test.anyNamespace {
+		return true
+	}
+
+	if d.namespaceURI(n) != test.namespace {
+		return false
+	}
+
+	return test.local == "" || test.local == d.localName(n)
+}
+
+// sortNodes sorts nodes into document order and removes duplicates.
+func (d *XPathDocument) sortNodes(nodes []XPathNode) []XPathNode {
+	sort.Slice(nodes, func(i, j int) bool {
+		return d.order[nodes[i]] < d.order[nodes[j]]
+	})
+
+	unique := nodes[:0]
+	for i, n := range nodes {
+		if i == 0 || n != nodes[i-1] {
+			unique = append(unique, n)
+		}
+	}
+	return unique
+}
+
+// charge accounts for work nodes being visited, and checks that the limit
+// has not been exceeded. Work which cannot fail immediately, such as
+// computing string values, is added to d.work directly and detected by the
+// next call to charge.
+func (d *XPathDocument) charge(work int) error {
+	d.work += work
+	if d.MaxWork > 0 && d.work > d.MaxWork {
+		return ErrXPathTooComplex
+	}
+	return nil
+}
+
+// Select evaluates the expression with context as the context node, and
+// returns the selected nodes in document order. It returns
+// ErrXPathNotNodeSet if the expression does not evaluate to a node-set.
+func (x *XPath) Select(doc *XPathDocument, context XPathNode) ([]XPathNode, error) {
+	ev := &xpathEvaluator{doc: doc}
+
+	v, err := ev.evaluate(x.expr, evalContext{node: context, position: 1, size: 1})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := doc.charge(1); err != nil {
+		return nil, err
+	}
+
+	nodes, ok := v.([]XPathNode)
+	if !ok {
+		return nil, ErrXPathNotNodeSet
+	}
+
+	return nodes, nil
+}
+
+// Boolean evaluates the expression with context as the context node, and
+// converts the result to a boolean.
+func (x *XPath) Boolean(doc *XPathDocument, context XPathNode) (bool, error) {
+	ev := &xpathEvaluator{doc: doc}
+
+	v, err := ev.evaluate(x.expr, evalContext{node: context, position: 1, size: 1})
+	if err != nil {
+		return false, err
+	}
+
+	if err := doc.charge(1); err != nil {
+		return false, err
+	}
+
+	return toBoolean(v), nil
+}
+
+type evalContext struct {
+	node     XPathNode
+	position int
+	size     int
+}
+
+// xpathEvaluator evaluates compiled expressions. Values are represented as
+// []XPathNode, string, float64 or bool.
+type xpathEvaluator struct {
+	doc *XPathDocument
+}
+
+func (ev *xpathEvaluator) evaluate(e xpathExpr, c evalContext) (interface{}, error) {
+	switch e := e.(type) {
+	case literalExpr:
+		return string(e), nil
+
+	case numberExpr:
+		return float64(e), nil
+
+	case *negateExpr:
+		v, err := ev.evaluate(e.operand, c)
+		if err != nil {
+			return nil, err
+		}
+		return -ev.toNumber(v), nil
+
+	case *binaryExpr:
+		return ev.evaluateBinary(e, c)
+
+	case *functionCall:
+		if err := ev.doc.charge(1); err != nil {
+			return nil, err
+		}
+		return xpathFunctions[e.name].call(ev, c, e.args)
+
+	case *filterExpr:
+		v, err := ev.evaluate(e.primary, c)
+		if err != nil {
+			return nil, err
+		}
+		nodes, ok := v.([]XPathNode)
+		if !ok {
+			return nil, ErrXPathNotNodeSet
+		}
+		return ev.filter(e.predicates, nodes)
+
+	case *pathExpr:
+		return ev.evaluatePath(e, c)
+	}
+
+	return nil, fmt.Errorf("unsupported XPath expression %T", e)
+}
+
+func (ev *xpathEvaluator) evaluateBinary(e *binaryExpr, c evalContext) (interface{}, error) {
+	left, err := ev.evaluate(e.left, c)
+	if err != nil {
+		return nil, err
+	}
+
+	// and/or short-circuit.
+	switch e.op {
+	case "or":
+		if toBoolean(left) {
+			return true, nil
+		}
+	case "and":
+		if !toBoolean(left) {
+			return false, nil
+		}
+	}
+
+	right, err := ev.evaluate(e.right, c)
+	if err != nil {
+		return nil, err
+	}
+
+	switch e.op {
+	case "or", "and":
+		return toBoolean(right), nil
+
+	case "|":
+		leftNodes, ok := left.([]XPathNode)
+		if !ok {
+			return nil, ErrXPathNotNodeSet
+		}
+		rightNodes, ok := right.([]XPathNode)
+		if !ok {
+			return nil, ErrXPathNotNodeSet
+		}
+		union := make([]XPathNode, 0, len(leftNodes)+len(rightNodes))
+		union = append(union, leftNodes...)
+		union = append(union, rightNodes...)
+		return ev.doc.sortNodes(union), nil
+
+	case "=", "!=", "<", "<=", ">", ">=":
+		return ev.compare(e.op, left, right), nil
+	}
+
+	l, r := ev.toNumber(left), ev.toNumber(right)
+
+	switch e.op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "div":
+		return l / r, nil
+	case "mod":
+		return math.Mod(l, r), nil
+	}
+
+	return nil, fmt.Errorf("unsupported XPath operator '%s'", e.op)
+}
+
+func (ev *xpathEvaluator) evaluatePath(e *pathExpr, c evalContext) (interface{}, error) {
+	if err := ev.doc.charge(1); err != nil {
+		return nil, err
+	}
+
+	var nodes []XPathNode
+
+	switch {
+	case e.filter != nil:
+		v, err := ev.evaluate(e.filter, c)
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if nodes, ok = v.([]XPathNode); !ok {
+			return nil, ErrXPathNotNodeSet
+		}
+	case e.absolute:
+		nodes = []XPathNode{ev.doc.Root()}
+	default:
+		nodes = []XPathNode{c.node}
+	}
+
+	for _, s := range e.steps {
+		var selected []XPathNode
+
+		for _, n := range nodes {
+			axisNodes := ev.doc.axis(s.axis, n)
+			if err := ev.doc.charge(len(axisNodes) + 1); err != nil {
+				return nil, err
+			}
+
+			var candidates []XPathNode
+			for _, m := range axisNodes {
+				if ev.doc.matches(s.test, s.axis, m) {
+					candidates = append(candidates, m)
+				}
+			}
+
+			candidates, err := ev.filter(s.predicates, candidates)
+			if err != nil {
+				return nil, err
+			}
+
+			selected = append(selected, candidates...)
+		}
+
+		nodes = ev.doc.sortNodes(selected)
+	}
+
+	return nodes, nil
+}
+
+// filter filters nodes by each of predicates in turn. Proximity positions
+// follow the order of nodes.
+func (ev *xpathEvaluator) filter(predicates []xpathExpr, nodes []XPathNode) ([]XPathNode, error) {
+	for _, predicate := range predicates {
+		var kept []XPathNode
+
+		if err := ev.doc.charge(len(nodes)); err != nil {
+			return nil, err
+		}
+
+		for i, n := range nodes {
+			v, err := ev.evaluate(predicate, evalContext{node: n, position: i + 1, size: len(nodes)})
+			if err != nil {
+				return nil, err
+			}
+
+			keep := false
+			if position, ok := v.(float64); ok {
+				keep = position == float64(i+1)
+			} else {
+				keep = toBoolean(v)
+			}
+
+			if keep {
+				kept = append(kept, n)
+			}
+		}
+
+		nodes = kept
+	}
+
+	return nodes, nil
+}
+
+func (ev *xpathEvaluator) compare(op string, left, right interface{}) bool {
+	leftNodes, leftIsNodes := left.([]XPathNode)
+	rightNodes, rightIsNodes := right.([]XPathNode)
+
+	switch {
+	case leftIsNodes && rightIsNodes:
+		ev.doc.work += len(leftNodes) * len(rightNodes)
+		for _, l := range leftNodes {
+			for _, r := range rightNodes {
+				if ev.compare(op, ev.doc.stringValue(l), ev.doc.stringValue(r)) {
+					return true
+				}
+			}
+		}
+		return false
+
+	case leftIsNodes || rightIsNodes:
+		nodes, other := leftNodes, right
+		if rightIsNodes {
+			nodes, other = rightNodes, left
+		}
+
+		if b, ok := other.(bool); ok {
+			return compareValues(op, len(leftNodes) > 0 || len(rightNodes) > 0, b, leftIsNodes)
+		}
+
+		for _, n := range nodes {
+			var v interface{} = ev.doc.stringValue(n)
+			if _, ok := other.(float64); ok {
+				v = ev.toNumber(v)
+			}
+
+			if compareValues(op, v, other, leftIsNodes) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return compareValues(op, left, right, true)
+}
+
+// compareValues compares two values which are not node-sets. If
+// nodesOnLeft is false the operands have been swapped, which matters for
+// the relational operators.
+func compareValues(op string, a, b interface{}, nodesOnLeft bool) bool {
+	if !nodesOnLeft {
+		a, b = b, a
+	}
+
+	switch op {
+	case "=", "!=":
+		var equal bool
+
+		_, aIsBool := a.(bool)
+		_, bIsBool := b.(bool)
+		_, aIsNumber := a.(float64)
+		_, bIsNumber := b.(float64)
+
+		switch {
+		case aIsBool || bIsBool:
+			equal = toBoolean(a) == toBoolean(b)
+		case aIsNumber || bIsNumber:
+			equal = stringToNumberValue(a) == stringToNumberValue(b)
+		default:
+			equal = a.(string) == b.(string)
+		}
+
+		if op == "=" {
+			return equal
+		}
+		return !equal
+	}
+
+	l, r := stringToNumberValue(a), stringToNumberValue(b)
+
+	switch op {
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	case ">=":
+		return l >= r
+	}
+
+	return false
+}
+
+// stringToNumberValue converts a string, number or boolean to a number.
+func stringToNumberValue(v interface{}) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	case string:
+		return stringToNumber(v)
+	}
+	return math.NaN()
+}
+
+func toBoolean(v interface{}) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	case string:
+		return v != ""
+	case []XPathNode:
+		return len(v) > 0
+	}
+	return false
+}
+
+func (ev *xpathEvaluator) toString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case bool:
+		if v {
+			return "true"
+		}
+		return "false"
+	case float64:
+		return numberToString(v)
+	case []XPathNode:
+		if len(v) == 0 {
+			return ""
+		}
+		return ev.doc.stringValue(v[0])
+	}
+	return ""
+}
+
+func (ev *xpathEvaluator) toNumber(v interface{}) float64 {
+	if nodes, ok := v.([]XPathNode); ok {
+		return stringToNumber(ev.toString(nodes))
+	}
+	return stringToNumberValue(v)
+}
+
+func stringToNumber(s string) float64 {
+	s = strings.TrimSpace(s)
+
+	// XPath numbers are an optional minus sign followed by digits with an
+	// optional decimal point. Anything else, including exponents, is NaN.
+	digits := strings.TrimPrefix(s, "-")
+	if digits == "" || digits == "." {
+		return math.NaN()
+	}
+
+	seenPoint := false
+	for _, r := range digits {
+		switch {
+		case r == '.' && !seenPoint:
+			seenPoint = true
+		case r >= '0' && r <= '9':
+		default:
+			return math.NaN()
+		}
+	}
+
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return n
+}
+
+func numberToString(n float64) string {
+	switch {
+	case math.IsNaN(n):
+		return "NaN"
+	case math.IsInf(n, 1):
+		return "Infinity"
+	case math.IsInf(n, -1):
+		return "-Infinity"
+	case n == 0:
+		return "0"
+	}
+	return strconv.FormatFloat(n, 'f', -1, 64)
+}
+
+type xpathFunction struct {
+	minArgs int
+	maxArgs int // -1 for no limit
+	call    func(ev *xpathEvaluator, c evalContext, args []xpathExpr) (interface{}, error)
+}
+
+// xpathFunctions is the function library, populated by init to avoid an
+// initialization cycle with evaluate.
+var xpathFunctions map[string]xpathFunction
+
+func (ev *xpathEvaluator) evaluateArgs(c evalContext, args []xpathExpr) ([]interface{}, error) {
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		v, err := ev.evaluate(arg, c)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+// nodeArgument returns the first node of the optional node-set argument,
+// defaulting to the context node.
+func (ev *xpathEvaluator) nodeArgument(c evalContext, args []xpathExpr) (XPathNode, bool, error) {
+	if len(args) == 0 {
+		return c.node, true, nil
+	}
+
+	v, err := ev.evaluate(args[0], c)
+	if err != nil {
+		return XPathNode{}, false, err
+	}
+
+	nodes, ok := v.([]XPathNode)
+	if !ok {
+		return XPathNode{}, false, ErrXPathNotNodeSet
+	}
+
+	if len(nodes) == 0 {
+		return XPathNode{}, false, nil
+	}
+
+	return nodes[0], true, nil
+}
+
+// stringArgument returns the string value of the optional argument,
+// defaulting to the string value of the context node.
+func (ev *xpathEvaluator) stringArgument(c evalContext, args []xpathExpr) (string, error) {
+	if len(args) == 0 {
+		return ev.doc.stringValue(c.node), nil
+	}
+
+	v, err := ev.evaluate(args[0], c)
+	if err != nil {
+		return "", err
+	}
+
+	return ev.toString(v), nil
+}
+
+func stringFunction(minArgs, maxArgs int, fn func(args []string) interface{}) xpathFunction {
+	return xpathFunction{
+		minArgs: minArgs,
+		maxArgs: maxArgs,
+		call: func(ev *xpathEvaluator, c evalContext, args []xpathExpr) (interface{}, error) {
+			values, err := ev.evaluateArgs(c, args)
+			if err != nil {
+				return nil, err
+			}
+
+			strs := make([]string, len(values))
+			for i, v := range values {
+				strs[i] = ev.toString(v)
+			}
+
+			return fn(strs), nil
+		},
+	}
+}
+
+func numberFunction(fn func(float64) float64) xpathFunction {
+	return xpathFunction{
+		minArgs: 1,
+		maxArgs: 1,
+		call: func(ev *xpathEvaluator, c evalContext, args []xpathExpr) (interface{}, error) {
+			v, err := ev.evaluate(args[0], c)
+			if err != nil {
+				return nil, err
+			}
+			return fn(ev.toNumber(v)), nil
+		},
+	}
+}
+
+func xpathRound(n float64) float64 {
+	if math.IsNaN(n) || math.IsInf(n, 0) {
+		return n
+	}
+	return math.Floor(n + 0.5)
+}
+
+// idAttributes are the attributes treated as IDs by id(), in the absence of
+// a DTD.
+var idAttributes = map[string]bool{
+	"ID": true,
+	"Id": true,
+	"id": true,
+}
+
+func (ev *xpathEvaluator) id(ids []string) []XPathNode {
+	wanted := map[string]bool{}
+	for _, id := range ids {
+		for _, field := range strings.Fields(id) {
+			wanted[field] = true
+		}
+	}
+
+	ev.doc.work += len(ev.doc.nodes)
+
+	var nodes []XPathNode
+	for _, n := range ev.doc.nodes {
+		if n.kind != elementNode {
+			continue
+		}
+		for _, attr := range n.el.Attr {
+			if attr.Space == defaultPrefix && idAttributes[attr.Key] && wanted[attr.Value] {
+				nodes = append(nodes, n)
+				break
+			}
+		}
+	}
+
+	return nodes
+}
+
+func init() {
+	xpathFunctions = map[string]xpathFunction{
+		"last": {0, 0, func(ev *xpathEvaluator, c evalContext, args []xpathExpr) (interface{}, error) {
+			return float64(c.size), nil
+		}},
+		"position": {0, 0, func(ev *xpathEvaluator, c evalContext, args []xpathExpr) (interface{}, error) {
+			return float64(c.position), nil
+		}},
+		"count": {1, 1, func(ev *xpathEvaluator, c evalContext, args []xpathExpr) (interface{}, error) {
+			v, err := ev.evaluate(args[0], c)
+			if err != nil {
+				return nil, err
+			}
+			nodes, ok := v.([]XPathNode)
+			if !ok {
+				return nil, ErrXPathNotNodeSet
+			}
+			return float64(len(nodes)), nil
+		}},
+		"id": {1, 1, func(ev *xpathEvaluator, c evalContext, args []xpathExpr) (interface{}, error) {
+			v, err := ev.evaluate(args[0], c)
+			if err != nil {
+				return nil, err
+			}
+			if nodes, ok := v.([]XPathNode); ok {
+				ids := make([]string, len(nodes))
+				for i, n := range nodes {
+					ids[i] = ev.doc.stringValue(n)
+				}
+				return ev.id(ids), nil
+			}
+			return ev.id([]string{ev.toString(v)}), nil
+		}},
+		"local-name": {0, 1, func(ev *xpathEvaluator, c evalContext, args []xpathExpr) (interface{}, error) {
+			n, ok, err := ev.nodeArgument(c, args)
+			if err != nil || !ok {
+				return "", err
+			}
+			return ev.doc.localName(n), nil
+		}},
+		"namespace-uri": {0, 1, func(ev *xpathEvaluator, c evalContext, args []xpathExpr) (interface{}, error) {
+			n, ok, err := ev.nodeArgument(c, args)
+			if err != nil || !ok {
+				return "", err
+			}
+			return ev.doc.namespaceURI(n), nil
+		}},
+		"name": {0, 1, func(ev *xpathEvaluator, c evalContext, args []xpathExpr) (interface{}, error) {
+			n, ok, err := ev.nodeArgument(c, args)
+			if err != nil || !ok {
+				return "", err
+			}
+			return ev.doc.name(n), nil
+		}},
+		"string": {0, 1, func(ev *xpathEvaluator, c evalContext, args []xpathExpr) (interface{}, error) {
+			return ev.stringArgument(c, args)
+		}},
+		"string-length": {0, 1, func(ev *xpathEvaluator, c evalContext, args []xpathExpr) (interface{}, error) {
+			s, err := ev.stringArgument(c, args)
+			if err != nil {
+				return nil, err
+			}
+			return float64(utf8.RuneCountInString(s)), nil
+		}},
+		"normalize-space": {0, 1, func(ev *xpathEvaluator, c evalContext, args []xpathExpr) (interface{}, error) {
+			s, err := ev.stringArgument(c, args)
+			if err != nil {
+				return nil, err
+			}
+			return strings.Join(strings.Fields(s), " "), nil
+		}},
+		"concat": stringFunction(2, -1, func(args []string) interface{} {
+			return strings.Join(args, "")
+		}),
+		"starts-with": stringFunction(2, 2, func(args []string) interface{} {
+			return strings.HasPrefix(args[0], args[1])
+		}),
+		"contains": stringFunction(2, 2, func(args []string) interface{} {
+			return strings.Contains(args[0], args[1])
+		}),
+		"substring-before": stringFunction(2, 2, func(args []string) interface{} {
+			if i := strings.Index(args[0], args[1]); i >= 0 {
+				return args[0][:i]
+			}
+			return ""
+		}),
+		"substring-after": stringFunction(2, 2, func(args []string) interface{} {
+			if i := strings.Index(args[0], args[1]); i >= 0 {
+				return args[0][i+len(args[1]):]
+			}
+			return ""
+		}),
+		"translate": stringFunction(3, 3, func(args []string) interface{} {
+			from, to := []rune(args[1]), []rune(args[2])
+			return strings.Map(func(r rune) rune {
+				for i, f := range from {
+					if f == r {
+						if i < len(to) {
+							return to[i]
+						}
+						return -1
+					}
+				}
+				return r
+			}, args[0])
+		}),
+		"substring": {2, 3, func(ev *xpathEvaluator, c evalContext, args []xpathExpr) (interface{}, error) {
+			values, err := ev.evaluateArgs(c, args)
+			if err != nil {
+				return nil, err
+			}
+
+			runes := []rune(ev.toString(values[0]))
+			start := xpathRound(ev.toNumber(values[1]))
+			end := math.Inf(1)
+			if len(values) == 3 {
+				end = start + xpathRound(ev.toNumber(values[2]))
+			}
+
+			var b strings.Builder
+			for i, r := range runes {
+				position := float64(i + 1)
+				if position >= start && position < end {
+					b.WriteRune(r)
+				}
+			}
+			return b.String(), nil
+		}},
+		"boolean": {1, 1, func(ev *xpathEvaluator, c evalContext, args []xpathExpr) (interface{}, error) {
+			v, err := ev.evaluate(args[0], c)
+			if err != nil {
+				return nil, err
+			}
+			return toBoolean(v), nil
+		}},
+		"not": {1, 1, func(ev *xpathEvaluator, c evalContext, args []xpathExpr) (interface{}, error) {
+			v, err := ev.evaluate(args[0], c)
+			if err != nil {
+				return nil, err
+			}
+			return !toBoolean(v), nil
+		}},
+		"true": {0, 0, func(ev *xpathEvaluator, c evalContext, args []xpathExpr) (interface{}, error) {
+			return true, nil
+		}},
+		"false": {0, 0, func(ev *xpathEvaluator, c evalContext, args []xpathExpr) (interface{}, error) {
+			return false, nil
+		}},
+		"number": {0, 1, func(ev *xpathEvaluator, c evalContext, args []xpathExpr) (interface{}, error) {
+			if len(args) == 0 {
+				return stringToNumber(ev.doc.stringValue(c.node)), nil
+			}
+			v, err := ev.evaluate(args[0], c)
+			if err != nil {
+				return nil, err
+			}
+			return ev.toNumber(v), nil
+		}},
+		"sum": {1, 1, func(ev *xpathEvaluator, c evalContext, args []xpathExpr) (interface{}, error) {
+			v, err := ev.evaluate(args[0], c)
+			if err != nil {
+				return nil, err
+			}
+			nodes, ok := v.([]XPathNode)
+			if !ok {
+				return nil, ErrXPathNotNodeSet
+			}
+			sum := 0.0
+			for _, n := range nodes {
+				sum += stringToNumber(ev.doc.stringValue(n))
+			}
+			return sum, nil
+		}},
+		"floor":   numberFunction(math.Floor),
+		"ceiling": numberFunction(math.Ceil),
+		"round":   numberFunction(xpathRound),
+		"here": {0, 0, func(ev *xpathEvaluator, c evalContext, args []xpathExpr) (interface{}, error) {
+			if ev.doc.here == nil {
+				return nil, ErrXPathHereUnavailable
+			}
+			return []XPathNode{*ev.doc.here}, nil
+		}},
+	}
+}
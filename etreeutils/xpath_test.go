@@ -0,0 +1,234 @@
+package etreeutils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/beevik/etree"
+	"github.com/stretchr/testify/require"
+)
+
+const xpathTestDocument = `<Root xmlns="urn:default" xmlns:ds="http://www.w3.org/2000/09/xmldsig#" xmlns:ex="urn:example" ID="root">
+  <ex:Item ex:kind="a" id="first">1</ex:Item>
+  <ex:Item ex:kind="b">2</ex:Item>
+  <!-- note -->
+  <ex:Item>3<ex:Child>tail</ex:Child></ex:Item>
+  <ds:Signature><ds:Reference><ds:XPath>here()</ds:XPath></ds:Reference></ds:Signature>
+</Root>`
+
+func newXPathTestDocument(t *testing.T) (*etree.Element, *XPathDocument) {
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(xpathTestDocument))
+
+	xdoc, err := NewXPathDocument(DefaultNSContext, doc.Root())
+	require.NoError(t, err)
+
+	return doc.Root(), xdoc
+}
+
+func compileTestXPath(t *testing.T, expr string) *XPath {
+	ctx, err := DefaultNSContext.SubContext(&etree.Element{Attr: []etree.Attr{
+		{Space: "xmlns", Key: "ds", Value: "http://www.w3.org/2000/09/xmldsig#"},
+		{Space: "xmlns", Key: "e", Value: "urn:example"},
+		{Space: "xmlns", Key: "d", Value: "urn:default"},
+	}})
+	require.NoError(t, err)
+
+	x, err := CompileXPath(ctx, expr)
+	require.NoError(t, err, expr)
+
+	return x
+}
+
+func describeNodes(nodes []XPathNode) []string {
+	var descriptions []string
+	for _, n := range nodes {
+		switch n.kind {
+		case rootNode:
+			descriptions = append(descriptions, "/")
+		case elementNode:
+			descriptions = append(descriptions, n.el.FullTag())
+		case attributeNode:
+			descriptions = append(descriptions, "@"+n.el.Attr[n.attr].FullKey())
+		case textNode:
+			descriptions = append(descriptions, "text:"+n.token.(*etree.CharData).Data)
+		case commentNode:
+			descriptions = append(descriptions, "comment")
+		}
+	}
+	return descriptions
+}
+
+func TestXPathSelect(t *testing.T) {
+	_, doc := newXPathTestDocument(t)
+
+	for expr, expected := range map[string][]string{
+		"/":                                  {"/"},
+		"/d:Root":                            {"Root"},
+		"/Root":                              nil,
+		"//e:Item":                           {"ex:Item", "ex:Item", "ex:Item"},
+		"//e:Item[2]":                        {"ex:Item"},
+		"//e:Item[last()]/e:Child/text()":    {"text:tail"},
+		"//e:Item[@e:kind='b']/text()":       {"text:2"},
+		"//e:Item[@id]/@*":                   {"@ex:kind", "@id"},
+		"//e:*[not(@e:kind)]":                {"ex:Item", "ex:Child"},
+		"//e:Child/ancestor::*":              {"Root", "ex:Item"},
+		"//e:Child/ancestor::*[1]/text()":    {"text:3"},
+		"//ds:XPath/ancestor-or-self::ds:*":  {"ds:Signature", "ds:Reference", "ds:XPath"},
+		"//e:Item[1]/following-sibling::*":   {"ex:Item", "ex:Item", "ds:Signature"},
+		"//e:Item[3]/preceding-sibling::*":   {"ex:Item", "ex:Item"},
+		"/d:Root/comment()":                  {"comment"},
+		"id('first root')":                   {"Root", "ex:Item"},
+		"//e:Item[position() > 1 and . < 3]": {"ex:Item"},
+		"//e:Item[1] | //ds:Signature":       {"ex:Item", "ds:Signature"},
+	} {
+		nodes, err := compileTestXPath(t, expr).Select(doc, doc.Root())
+		require.NoError(t, err, expr)
+		require.Equal(t, expected, describeNodes(nodes), expr)
+	}
+}
+
+func TestXPathBoolean(t *testing.T) {
+	root, doc := newXPathTestDocument(t)
+
+	xpath := root.FindElement("//ds:XPath")
+	item := root.FindElement("./ex:Item")
+
+	notSignature := compileTestXPath(t, "not(ancestor-or-self::ds:Signature)")
+
+	inSignature, err := notSignature.Boolean(doc, elementXPathNode(xpath))
+	require.NoError(t, err)
+	require.False(t, inSignature)
+
+	outsideSignature, err := notSignature.Boolean(doc, elementXPathNode(item))
+	require.NoError(t, err)
+	require.True(t, outsideSignature)
+
+	for expr, expected := range map[string]bool{
+		"count(//e:Item) = 3":                                           true,
+		"sum(//e:Item[@e:kind]) = 3":                                    true,
+		"string(//e:Item[3]) = '3tail'":                                 true,
+		"concat('a', 'b', 'c') = 'abc'":                                 true,
+		"substring('12345', 1.5, 2.6) = '234'":                          true,
+		"substring-after('a:b', ':') = 'b'":                             true,
+		"normalize-space('  a   b ') = 'a b'":                           true,
+		"translate('bar', 'abc', 'ABC') = 'BAr'":                        true,
+		"local-name(/*) = 'Root'":                                       true,
+		"namespace-uri(//e:Item) = 'urn:example'":                       true,
+		"floor(2.5) + ceiling(2.5) + round(2.5) = 8":                    true,
+		"7 mod 3 = 1 and 7 div 2 = 3.5":                                 true,
+		"-(1) = -1":                                                     true,
+		"'abc' = 'abd' or 1 != 1":                                       false,
+		"//e:Item = '2'":                                                true,
+		"//e:Item > 1":                                                  true,
+		"//e:Item >= 3":                                                 false,
+		"number('1e3') != number('1e3')":                                true,
+		"starts-with(name(/*), 'Ro') and contains(name(//e:Item), ':')": true,
+	} {
+		v, err := compileTestXPath(t, expr).Boolean(doc, doc.Root())
+		require.NoError(t, err, expr)
+		require.Equal(t, expected, v, expr)
+	}
+}
+
+func TestXPathHere(t *testing.T) {
+	root, doc := newXPathTestDocument(t)
+
+	x := compileTestXPath(t, "here()/ancestor::ds:Signature[1]")
+
+	_, err := x.Select(doc, doc.Root())
+	require.Equal(t, ErrXPathHereUnavailable, err)
+
+	require.False(t, doc.SetHere(etree.NewElement("Detached")))
+	require.True(t, doc.SetHere(root.FindElement("//ds:XPath")))
+
+	nodes, err := x.Select(doc, doc.Root())
+	require.NoError(t, err)
+	require.Equal(t, []string{"ds:Signature"}, describeNodes(nodes))
+}
+
+func TestXPathSyntaxErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"//",
+		"count(",
+		"unknown()",
+		"$var",
+		"//undeclared:Item",
+		"namespace::*",
+		"'unterminated",
+		"1 +",
+		"//a[1",
+	} {
+		_, err := CompileXPath(DefaultNSContext, expr)
+		require.Error(t, err, expr)
+	}
+
+	_, doc := newXPathTestDocument(t)
+	_, err := compileTestXPath(t, "1 + 1").Select(doc, doc.Root())
+	require.Equal(t, ErrXPathNotNodeSet, err)
+}
+
+func TestNodeSetRetain(t *testing.T) {
+	root, doc := newXPathTestDocument(t)
+
+	// Drop the first Item's attributes, and the second and third Items
+	// themselves while keeping the text of the third.
+	selected, err := compileTestXPath(t, "//e:Item[1]/@* | //e:Item[2] | //e:Item[2]//node() | //e:Item[3]").Select(doc, doc.Root())
+	require.NoError(t, err)
+
+	dropped := map[XPathNode]bool{}
+	for _, n := range selected {
+		dropped[n] = true
+	}
+
+	ns := NewNodeSet(root)
+	require.True(t, ns.IsSubtree())
+
+	err = ns.Retain(func(n XPathNode) (bool, error) {
+		return !dropped[n], nil
+	})
+	require.NoError(t, err)
+
+	require.False(t, ns.IsSubtree())
+
+	items := root.SelectElements("ex:Item")
+	require.Len(t, items, 2)
+	require.Empty(t, items[0].Attr)
+	require.Equal(t, "3", items[1].Text())
+	require.True(t, ns.Omitted[items[1]])
+	require.False(t, ns.Contains(elementXPathNode(items[1])))
+	require.True(t, ns.Contains(elementXPathNode(items[0])))
+
+	err = ns.Retain(func(n XPathNode) (bool, error) {
+		return false, nil
+	})
+	require.NoError(t, err)
+	require.Nil(t, ns.Root)
+}
+
+func TestXPathComplexityLimit(t *testing.T) {
+	_, err := CompileXPath(DefaultNSContext, strings.Repeat("1 + ", 2000)+"1")
+	require.Equal(t, ErrXPathTooComplex, err)
+
+	_, err = CompileXPath(DefaultNSContext, strings.Repeat("(", 40)+"1"+strings.Repeat(")", 40))
+	require.Equal(t, ErrXPathTooComplex, err)
+
+	require.Equal(t, 1, compileTestXPath(t, "1 + 1").Depth())
+	require.Equal(t, 3, compileTestXPath(t, "((1))").Depth())
+	require.Equal(t, 3, compileTestXPath(t, "count(//*[position() = 1])").Depth())
+
+	_, doc := newXPathTestDocument(t)
+	doc.MaxWork = 100
+
+	x := compileTestXPath(t, "//*[count(//node()) > 1]")
+	_, err = x.Select(doc, doc.Root())
+	require.Equal(t, ErrXPathTooComplex, err)
+
+	_, doc = newXPathTestDocument(t)
+	doc.MaxWork = 100
+
+	x = compileTestXPath(t, "//*[string(/) = '']")
+	_, err = x.Select(doc, doc.Root())
+	require.Equal(t, ErrXPathTooComplex, err)
+}
@@ -0,0 +1,242 @@
+package dsig
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"io"
+	"math/big"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// fakeTimestampPolicy is the TSA policy of fakeTimestampAuthority tokens.
+var fakeTimestampPolicy = asn1.ObjectIdentifier{1, 2, 3, 4, 1}
+
+// fakeTimestampAuthority is an in-process TSA for the tests, which issues
+// tokens signed with a self-signed certificate. It also serves RFC 3161
+// requests over HTTP.
+type fakeTimestampAuthority struct {
+	// Clock is the source of the times tokens assert.
+	Clock *Clock
+
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+
+	mu     sync.Mutex
+	serial int64
+}
+
+// newFakeTimestampAuthority creates a TSA with a new key and certificate.
+func newFakeTimestampAuthority() (*fakeTimestampAuthority, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Fake Timestamp Authority"},
+		NotBefore:    now.Add(-24 * time.Hour),
+		NotAfter:     now.Add(10 * 365 * 24 * time.Hour),
+
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fakeTimestampAuthority{key: key, cert: cert}, nil
+}
+
+// Certificate returns the certificate tokens are signed with.
+func (tsa *fakeTimestampAuthority) Certificate() *x509.Certificate {
+	return tsa.cert
+}
+
+// Timestamp implements TimestampAuthority.
+func (tsa *fakeTimestampAuthority) Timestamp(hash crypto.Hash, digest []byte) ([]byte, error) {
+	oid, err := hashOID(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return tsa.issue(messageImprint{
+		HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oid},
+		HashedMessage: digest,
+	}, nil)
+}
+
+// ServeHTTP answers RFC 3161 timestamp requests.
+func (tsa *fakeTimestampAuthority) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<16))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp := timeStampResp{}
+
+	var req timeStampReq
+	if rest, err := asn1.Unmarshal(body, &req); err != nil || len(rest) != 0 {
+		// rejection
+		resp.Status = pkiStatusInfo{Status: 2, StatusString: []string{"Malformed request"}}
+	} else if _, err := hashFromOID(req.MessageImprint.HashAlgorithm.Algorithm); err != nil {
+		resp.Status = pkiStatusInfo{Status: 2, StatusString: []string{"Unsupported hash algorithm"}}
+	} else {
+		token, err := tsa.issue(req.MessageImprint, req.Nonce)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		resp.TimeStampToken = asn1.RawValue{FullBytes: token}
+	}
+
+	der, err := asn1.Marshal(resp)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/timestamp-reply")
+	w.Write(der)
+}
+
+// issue returns a token over imprint, signed with SHA-256.
+func (tsa *fakeTimestampAuthority) issue(imprint messageImprint, nonce *big.Int) ([]byte, error) {
+	tsa.mu.Lock()
+	tsa.serial++
+	serial := tsa.serial
+	tsa.mu.Unlock()
+
+	content, err := asn1.Marshal(tstInfo{
+		Version:        1,
+		Policy:         fakeTimestampPolicy,
+		MessageImprint: imprint,
+		SerialNumber:   big.NewInt(serial),
+		GenTime:        tsa.Clock.Now().UTC().Truncate(time.Second),
+		Nonce:          nonce,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sha256OID, err := hashOID(crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	contentDigest := crypto.SHA256.New()
+	contentDigest.Write(content)
+
+	contentTypeAttr, err := marshalAttribute(oidContentType, oidTSTInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	messageDigestAttr, err := marshalAttribute(oidMessageDigest, contentDigest.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	signedAttrs := sortedSetContent(contentTypeAttr, messageDigestAttr)
+
+	// The signature is over the signed attributes as a SET OF.
+	signed, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: signedAttrs})
+	if err != nil {
+		return nil, err
+	}
+
+	signedDigest := crypto.SHA256.New()
+	signedDigest.Write(signed)
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, tsa.key, crypto.SHA256, signedDigest.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	sid, err := asn1.Marshal(issuerAndSerialNumber{
+		Issuer:       asn1.RawValue{FullBytes: tsa.cert.RawIssuer},
+		SerialNumber: tsa.cert.SerialNumber,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	eContent, err := asn1.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+
+	sd, err := asn1.Marshal(signedData{
+		Version:          3,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: sha256OID}},
+		EncapContentInfo: encapsulatedContentInfo{
+			EContentType: oidTSTInfo,
+			EContent:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: eContent},
+		},
+		Certificates: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: tsa.cert.Raw},
+		SignerInfos: []signerInfo{{
+			Version:            1,
+			SID:                asn1.RawValue{FullBytes: sid},
+			DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: sha256OID},
+			SignedAttrs:        asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signedAttrs},
+			SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue},
+			Signature:          signature,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: sd},
+	})
+}
+
+// marshalAttribute returns the DER encoding of an Attribute of type oid,
+// with the single value value.
+func marshalAttribute(oid asn1.ObjectIdentifier, value interface{}) ([]byte, error) {
+	valueDER, err := asn1.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(attribute{
+		Type:   oid,
+		Values: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: valueDER},
+	})
+}
+
+// sortedSetContent returns the content of a DER SET OF the encoded
+// elements, which DER requires to be sorted.
+func sortedSetContent(elements ...[]byte) []byte {
+	sort.Slice(elements, func(i, j int) bool {
+		return bytes.Compare(elements[i], elements[j]) < 0
+	})
+
+	return bytes.Join(elements, nil)
+}
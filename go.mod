@@ -1,10 +1,17 @@
 module gitlab.com/moolekkari/goxmldsig
 
-go 1.13
+go 1.21
 
 require (
 	github.com/beevik/etree v1.1.0
 	github.com/jonboulle/clockwork v0.1.0
-	github.com/russellhaering/goxmldsig v0.0.0-20180430223755-7acd5e4a6ef7
 	github.com/stretchr/testify v1.5.1
+	golang.org/x/crypto v0.11.0
+)
+
+require (
+	github.com/davecgh/go-spew v1.1.0 // indirect
+	github.com/pmezard/go-difflib v1.0.0 // indirect
+	golang.org/x/sys v0.10.0 // indirect
+	gopkg.in/yaml.v2 v2.2.2 // indirect
 )
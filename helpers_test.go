@@ -0,0 +1,67 @@
+package dsig
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/stretchr/testify/require"
+)
+
+// signDocumentForTest parses document and replaces its root element by a
+// copy signed with an enveloped signature made by ctx, keeping the prolog.
+func signDocumentForTest(t testing.TB, ctx *SigningContext, document string) *etree.Document {
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(document))
+
+	signed, err := ctx.SignEnveloped(doc.Root())
+	require.NoError(t, err)
+
+	doc.SetRoot(signed)
+	return doc
+}
+
+// serializeForTest returns the serialized form of doc.
+func serializeForTest(t testing.TB, doc *etree.Document) []byte {
+	serialized, err := doc.WriteToBytes()
+	require.NoError(t, err)
+
+	return serialized
+}
+
+// certificateStoreForTest returns a certificate store trusting the
+// certificate of ks.
+func certificateStoreForTest(t testing.TB, ks X509KeyStore) X509CertificateStore {
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	return &MemoryX509CertificateStore{Roots: []*x509.Certificate{cert}}
+}
+
+// keyStoreWithTemplateForTest returns a key store whose certificate is
+// self-signed from a copy of template. Unless the template sets them, the
+// certificate has serial number 1 and is valid for the next hour.
+func keyStoreWithTemplateForTest(t testing.TB, template *x509.Certificate) *MemoryX509KeyStore {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	tmpl := *template
+	if tmpl.SerialNumber == nil {
+		tmpl.SerialNumber = big.NewInt(1)
+	}
+	if tmpl.NotBefore.IsZero() {
+		tmpl.NotBefore = time.Now().Add(-5 * time.Minute)
+	}
+	if tmpl.NotAfter.IsZero() {
+		tmpl.NotAfter = time.Now().Add(time.Hour)
+	}
+
+	cert, err := x509.CreateCertificate(rand.Reader, &tmpl, &tmpl, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	return &MemoryX509KeyStore{privateKey: key, cert: cert}
+}
@@ -1,6 +1,7 @@
 package dsig
 
 import (
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -13,6 +14,13 @@ type X509KeyStore interface {
 	GetKeyPair() (privateKey *rsa.PrivateKey, cert *x509.Certificate, err error)
 }
 
+// X509SignerStore is implemented by key stores whose private key is not
+// necessarily an RSA key. When a SigningContext's KeyStore implements it,
+// GetSigner is used instead of GetKeyPair.
+type X509SignerStore interface {
+	GetSigner() (signer crypto.Signer, cert *x509.Certificate, err error)
+}
+
 // X509ChainStore interface.
 type X509ChainStore interface {
 	GetChain() (certs []*x509.Certificate, err error)
@@ -0,0 +1,291 @@
+package dsig
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/beevik/etree"
+	"gitlab.com/moolekkari/goxmldsig/etreeutils"
+	"gitlab.com/moolekkari/goxmldsig/types"
+)
+
+// ErrDTDNotAllowed indicates that a document being validated contains a
+// document type declaration, or another directive such as an entity
+// declaration, and the ValidationLimits in effect do not allow them.
+var ErrDTDNotAllowed = errors.New("Document type declarations are not allowed")
+
+// ErrLimitExceeded indicates that a document being validated exceeds one of
+// the ValidationLimits in effect.
+type ErrLimitExceeded struct {
+	// Limit is the name of the exceeded ValidationLimits field.
+	Limit string
+
+	// Max is the value of the exceeded limit.
+	Max int64
+}
+
+func (e ErrLimitExceeded) Error() string {
+	return fmt.Sprintf("Document exceeds %s of %d", e.Limit, e.Max)
+}
+
+// ValidationLimits bounds the work done validating a document, which is
+// usually supplied by an untrusted party. A zero limit is not enforced.
+type ValidationLimits struct {
+	// MaxDepth is the maximum nesting depth of elements.
+	MaxDepth int
+
+	// MaxElements is the maximum number of elements.
+	MaxElements int
+
+	// MaxReferences is the maximum number of References in a SignedInfo.
+	MaxReferences int
+
+	// MaxTransforms is the maximum number of Transforms in a Reference.
+	MaxTransforms int
+
+	// MaxBase64Size is the maximum length of a base64 encoded value: a
+	// DigestValue, SignatureValue or X509Certificate, or the input of a
+	// base64 transform.
+	MaxBase64Size int
+
+	// MaxCanonicalSize is the maximum size in bytes of the canonical form
+	// of any content which is digested.
+	MaxCanonicalSize int64
+
+	// MaxXPathLength is the maximum length in bytes of the expression of an
+	// XPath or XPath Filter 2.0 transform, and MaxXPathDepth its maximum
+	// nesting depth. Expressions exceeding the bounds of
+	// etreeutils.CompileXPath are rejected regardless.
+	MaxXPathLength int
+	MaxXPathDepth  int
+
+	// MaxXPathWork is the maximum work done evaluating the expressions of
+	// such a transform, measured in nodes visited.
+	MaxXPathWork int
+
+	// AllowDTD permits document type declarations and other directives.
+	// The package never expands entities, so a document relying on its DTD
+	// will not validate regardless.
+	AllowDTD bool
+}
+
+// DefaultValidationLimits are the limits applied by a ValidationContext
+// whose Limits are nil. They admit documents of several hundred megabytes.
+var DefaultValidationLimits = ValidationLimits{
+	MaxDepth:         256,
+	MaxElements:      10000000,
+	MaxReferences:    32,
+	MaxTransforms:    8,
+	MaxBase64Size:    32 << 20,
+	MaxCanonicalSize: 1 << 30,
+	MaxXPathLength:   4096,
+	MaxXPathDepth:    32,
+	MaxXPathWork:     etreeutils.DefaultXPathMaxWork,
+}
+
+func (ctx *ValidationContext) limits() *ValidationLimits {
+	if ctx.Limits == nil {
+		return &DefaultValidationLimits
+	}
+	return ctx.Limits
+}
+
+// The check methods below accept a nil receiver, which enforces nothing, as
+// the transforms shared with signing are applied without limits.
+
+// checkDocument checks el, and the document it is part of, against the
+// limits.
+func (l *ValidationLimits) checkDocument(el *etree.Element) error {
+	if l == nil {
+		return nil
+	}
+
+	// Declarations precede the root element, so look for them among the
+	// children of the document.
+	top := el
+	for top.Parent() != nil {
+		top = top.Parent()
+	}
+	if top != el && !l.AllowDTD {
+		for _, token := range top.Child {
+			if _, ok := token.(*etree.Directive); ok {
+				return ErrDTDNotAllowed
+			}
+		}
+	}
+
+	return l.checkTree(el)
+}
+
+// checkTree checks the depth and number of elements of the tree rooted at
+// el, and that it contains no directives.
+func (l *ValidationLimits) checkTree(el *etree.Element) error {
+	if l == nil {
+		return nil
+	}
+
+	elements := 0
+
+	var check func(el *etree.Element, depth int) error
+	check = func(el *etree.Element, depth int) error {
+		if err := l.checkElement(depth, elements+1); err != nil {
+			return err
+		}
+		elements++
+
+		for _, token := range el.Child {
+			switch token := token.(type) {
+			case *etree.Element:
+				if err := check(token, depth+1); err != nil {
+					return err
+				}
+			case *etree.Directive:
+				if !l.AllowDTD {
+					return ErrDTDNotAllowed
+				}
+			}
+		}
+		return nil
+	}
+
+	return check(el, 1)
+}
+
+// checkElement checks an element found at depth, which is the count-th
+// element of its document.
+func (l *ValidationLimits) checkElement(depth, count int) error {
+	if l == nil {
+		return nil
+	}
+
+	if l.MaxDepth > 0 && depth > l.MaxDepth {
+		return ErrLimitExceeded{Limit: "MaxDepth", Max: int64(l.MaxDepth)}
+	}
+
+	if l.MaxElements > 0 && count > l.MaxElements {
+		return ErrLimitExceeded{Limit: "MaxElements", Max: int64(l.MaxElements)}
+	}
+
+	return nil
+}
+
+// checkSignature checks the number of References and Transforms of sig, and
+// the sizes of its base64 encoded values.
+func (l *ValidationLimits) checkSignature(sig *types.Signature) error {
+	if l == nil {
+		return nil
+	}
+
+	if sig.SignedInfo != nil {
+		if err := l.checkReferences(sig.SignedInfo.References); err != nil {
+			return err
+		}
+	}
+
+	if sig.SignatureValue != nil {
+		if err := l.checkBase64(len(sig.SignatureValue.Data)); err != nil {
+			return err
+		}
+	}
+
+	if sig.KeyInfo != nil {
+		for _, cert := range sig.KeyInfo.X509Data.X509Certificates {
+			if err := l.checkBase64(len(cert.Data)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// checkReferences checks the number of references, and the number of
+// Transforms and size of the DigestValue of each.
+func (l *ValidationLimits) checkReferences(references []types.Reference) error {
+	if l == nil {
+		return nil
+	}
+
+	if l.MaxReferences > 0 && len(references) > l.MaxReferences {
+		return ErrLimitExceeded{Limit: "MaxReferences", Max: int64(l.MaxReferences)}
+	}
+
+	for i := range references {
+		if l.MaxTransforms > 0 && len(references[i].Transforms.Transforms) > l.MaxTransforms {
+			return ErrLimitExceeded{Limit: "MaxTransforms", Max: int64(l.MaxTransforms)}
+		}
+
+		if err := l.checkBase64(len(references[i].DigestValue)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *ValidationLimits) checkBase64(size int) error {
+	if l != nil && l.MaxBase64Size > 0 && size > l.MaxBase64Size {
+		return ErrLimitExceeded{Limit: "MaxBase64Size", Max: int64(l.MaxBase64Size)}
+	}
+	return nil
+}
+
+// checkXPath checks the length and nesting depth of the expression x.
+func (l *ValidationLimits) checkXPath(x *etreeutils.XPath) error {
+	if l == nil {
+		return nil
+	}
+
+	if l.MaxXPathLength > 0 && len(x.String()) > l.MaxXPathLength {
+		return ErrLimitExceeded{Limit: "MaxXPathLength", Max: int64(l.MaxXPathLength)}
+	}
+
+	if l.MaxXPathDepth > 0 && x.Depth() > l.MaxXPathDepth {
+		return ErrLimitExceeded{Limit: "MaxXPathDepth", Max: int64(l.MaxXPathDepth)}
+	}
+
+	return nil
+}
+
+// limitXPath bounds the work done evaluating expressions against doc by
+// MaxXPathWork. Without limits, doc keeps its default bound.
+func (l *ValidationLimits) limitXPath(doc *etreeutils.XPathDocument) {
+	if l != nil {
+		doc.MaxWork = l.MaxXPathWork
+	}
+}
+
+// xpathError reports err, returned by evaluating an expression against a
+// document bounded by limitXPath, as exceeding MaxXPathWork if it does.
+func (l *ValidationLimits) xpathError(err error) error {
+	if l != nil && err == etreeutils.ErrXPathTooComplex {
+		return ErrLimitExceeded{Limit: "MaxXPathWork", Max: int64(l.MaxXPathWork)}
+	}
+	return err
+}
+
+// limitCanonical returns a writer which fails once more than
+// MaxCanonicalSize bytes have been written to w through it.
+func (l *ValidationLimits) limitCanonical(w io.Writer) io.Writer {
+	if l == nil || l.MaxCanonicalSize <= 0 {
+		return w
+	}
+	return &limitedWriter{w: w, remaining: l.MaxCanonicalSize, max: l.MaxCanonicalSize}
+}
+
+type limitedWriter struct {
+	w         io.Writer
+	remaining int64
+	max       int64
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > lw.remaining {
+		lw.remaining = 0
+		return 0, ErrLimitExceeded{Limit: "MaxCanonicalSize", Max: lw.max}
+	}
+
+	lw.remaining -= int64(len(p))
+	return lw.w.Write(p)
+}
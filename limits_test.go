@@ -0,0 +1,154 @@
+package dsig
+
+import (
+	"bytes"
+	"crypto/x509"
+	"strings"
+	"testing"
+
+	"github.com/beevik/etree"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/moolekkari/goxmldsig/types"
+)
+
+func TestValidationLimits(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	serialized := serializeForTest(t, signDocumentForTest(t, NewDefaultSigningContext(ks), `<Root><A><B><C/></B></A><D/></Root>`))
+
+	for _, test := range []struct {
+		limits ValidationLimits
+		limit  string
+	}{
+		{ValidationLimits{MaxDepth: 3}, "MaxDepth"},
+		{ValidationLimits{MaxElements: 5}, "MaxElements"},
+		{ValidationLimits{MaxTransforms: 1}, "MaxTransforms"},
+		{ValidationLimits{MaxBase64Size: 64}, "MaxBase64Size"},
+		{ValidationLimits{MaxCanonicalSize: 32}, "MaxCanonicalSize"},
+	} {
+		limits := test.limits
+		vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+			Roots: []*x509.Certificate{cert},
+		})
+		vc.Limits = &limits
+
+		doc := etree.NewDocument()
+		require.NoError(t, doc.ReadFromBytes(serialized))
+
+		_, err := vc.Validate(doc.Root())
+		require.Error(t, err, test.limit)
+		require.IsType(t, ErrLimitExceeded{}, err, test.limit)
+		require.Equal(t, test.limit, err.(ErrLimitExceeded).Limit)
+
+		_, err = vc.ValidateStream(bytes.NewReader(serialized))
+		require.Error(t, err, test.limit)
+		require.IsType(t, ErrLimitExceeded{}, err, test.limit)
+		require.Equal(t, test.limit, err.(ErrLimitExceeded).Limit)
+	}
+
+	// The default limits admit the document.
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromBytes(serialized))
+
+	_, err = vc.Validate(doc.Root())
+	require.NoError(t, err)
+
+	_, err = vc.ValidateStream(bytes.NewReader(serialized))
+	require.NoError(t, err)
+}
+
+func TestValidationLimitsMaxReferences(t *testing.T) {
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{})
+	vc.Limits = &ValidationLimits{MaxReferences: 1}
+
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(`<Root><ds:Signature xmlns:ds="http://www.w3.org/2000/09/xmldsig#"><ds:SignedInfo>`+
+		`<ds:CanonicalizationMethod Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"/>`+
+		`<ds:Reference URI=""/><ds:Reference URI=""/>`+
+		`</ds:SignedInfo></ds:Signature></Root>`))
+
+	_, err := vc.Validate(doc.Root())
+	require.Equal(t, ErrLimitExceeded{Limit: "MaxReferences", Max: 1}, err)
+}
+
+func TestValidationLimitsXPath(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	ctx := NewDefaultSigningContext(ks)
+	ctx.Transforms = []types.Transform{
+		NewXPathTransform("not(ancestor-or-self::ds:Signature)", nil),
+	}
+
+	signed := signDocumentForTest(t, ctx, `<Root>`+strings.Repeat(`<Item>content</Item>`, 5000)+`</Root>`).Root()
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+
+	// The default limits admit the document.
+	_, err = vc.Validate(signed)
+	require.NoError(t, err)
+
+	for _, test := range []struct {
+		limits ValidationLimits
+		err    ErrLimitExceeded
+	}{
+		{ValidationLimits{MaxXPathWork: 1000}, ErrLimitExceeded{Limit: "MaxXPathWork", Max: 1000}},
+		{ValidationLimits{MaxXPathLength: 16}, ErrLimitExceeded{Limit: "MaxXPathLength", Max: 16}},
+		{ValidationLimits{MaxXPathDepth: 1}, ErrLimitExceeded{Limit: "MaxXPathDepth", Max: 1}},
+	} {
+		limits := test.limits
+		vc.Limits = &limits
+
+		_, err = vc.Validate(signed)
+		require.Equal(t, test.err, err)
+	}
+
+	// The limits may also be raised above their defaults.
+	vc.Limits = &ValidationLimits{MaxXPathWork: 1 << 30}
+	_, err = vc.Validate(signed)
+	require.NoError(t, err)
+}
+
+func TestValidationLimitsDTD(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	serialized := serializeForTest(t, signDocumentForTest(t, NewDefaultSigningContext(ks), `<Root><A/></Root>`))
+	withDTD := []byte(`<!DOCTYPE Root [<!ENTITY e "entity">]>` + string(serialized))
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromBytes(withDTD))
+
+	_, err = vc.Validate(doc.Root())
+	require.Equal(t, ErrDTDNotAllowed, err)
+
+	_, err = vc.ValidateStream(bytes.NewReader(withDTD))
+	require.Equal(t, ErrDTDNotAllowed, err)
+
+	vc.Limits = &ValidationLimits{AllowDTD: true}
+
+	_, err = vc.Validate(doc.Root())
+	require.NoError(t, err)
+
+	_, err = vc.ValidateStream(bytes.NewReader(withDTD))
+	require.NoError(t, err)
+
+	// Directives within the root element are rejected as well.
+	_, err = NewDefaultValidationContext(&MemoryX509CertificateStore{}).
+		ValidateStream(strings.NewReader(`<Root><!ENTITY e "entity"></Root>`))
+	require.Equal(t, ErrDTDNotAllowed, err)
+}
@@ -0,0 +1,236 @@
+package dsig
+
+import (
+	"errors"
+
+	"github.com/beevik/etree"
+	"gitlab.com/moolekkari/goxmldsig/etreeutils"
+	"gitlab.com/moolekkari/goxmldsig/types"
+)
+
+// ManifestType is the Type of a Reference to a ds:Manifest.
+const ManifestType = "http://www.w3.org/2000/09/xmldsig#Manifest"
+
+// ManifestResult is the outcome of checking the References of a ds:Manifest
+// which a verified signature signs.
+type ManifestResult struct {
+	// ID is the Id of the Manifest.
+	ID string
+
+	// References holds the outcome for each Reference of the Manifest, in
+	// document order.
+	References []ManifestReferenceResult
+}
+
+// ManifestReferenceResult is the outcome of checking a Reference of a
+// Manifest.
+type ManifestReferenceResult struct {
+	// URI is the URI of the Reference.
+	URI string
+
+	// Err is nil if the referenced content matches the digest of the
+	// Reference, and otherwise tells why it does not.
+	Err error
+}
+
+// Valid reports whether all References of the Manifest were verified.
+func (m *ManifestResult) Valid() bool {
+	for _, ref := range m.References {
+		if ref.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// manifestObject returns a ds:Object holding a ds:Manifest of References to
+// the elements of el with the context's ManifestIDs. The Manifest has an Id
+// derived from sigID, the Id of the Signature, if it is not empty.
+func (ctx *SigningContext) manifestObject(el *etree.Element, sigID string) (signedObject, error) {
+	digestAlgorithm, err := ctx.digestAlgorithm()
+	if err != nil {
+		return signedObject{}, err
+	}
+
+	id := sigID
+	if id == "" {
+		id, err = randomID("xmldsig-")
+		if err != nil {
+			return signedObject{}, err
+		}
+	}
+	id += "-Manifest"
+
+	object := &etree.Element{
+		Tag:   ObjectTag,
+		Space: ctx.Prefix,
+	}
+
+	manifest := ctx.createNamespacedElement(object, ManifestTag)
+	manifest.CreateAttr(IDAttr, id)
+
+	for _, targetID := range ctx.ManifestIDs {
+		uri := "#" + targetID
+
+		target, err := dereference(el, uri)
+		if err != nil {
+			return signedObject{}, err
+		}
+
+		// Digest the target as validation dereferences it: detached from
+		// the document, without comments.
+		detached, err := detachedCopy(target)
+		if err != nil {
+			return signedObject{}, err
+		}
+		removeComments(detached)
+
+		digest, err := ctx.digest(detached, digestAlgorithm.Hash)
+		if err != nil {
+			return signedObject{}, err
+		}
+
+		err = ctx.createReference(manifest, uri, "", digestAlgorithm, digest)
+		if err != nil {
+			return signedObject{}, err
+		}
+	}
+
+	return signedObject{
+		object:        object,
+		target:        manifest,
+		referenceType: ManifestType,
+	}, nil
+}
+
+// ValidateWithManifests verifies the signature of el as ValidateData does,
+// which covers the ds:Manifests the SignedInfo references as a whole, and
+// then checks the References of those Manifests. As XMLDSig leaves their
+// validation to the application, a Reference which does not verify does
+// not fail the validation, but is reported in the Manifests of the result,
+// telling the application which of the referenced elements were altered.
+// Any SignatureProperties are returned as ValidateWithSignatureProperties
+// does.
+func (ctx *ValidationContext) ValidateWithManifests(el *etree.Element) (*ValidationResult, error) {
+	el, sig, store, err := ctx.findValidationSignature(el)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := ctx.verifyCertificate(sig, store)
+	if err != nil {
+		return nil, err
+	}
+
+	transformed, err := ctx.validateSignature(el, sig, cert)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, err := ctx.verifyManifests(el, sig)
+	if err != nil {
+		return nil, err
+	}
+
+	properties, err := ctx.verifySignatureProperties(el, sig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ValidationResult{
+		Content:             transformed,
+		Certificate:         cert,
+		Manifests:           manifests,
+		SignatureProperties: properties,
+	}, nil
+}
+
+// verifyManifests checks the References of the Manifests within el which
+// the SignedInfo of sig references, after sig has been verified. Malformed
+// Manifests fail, while the outcome of each Reference is reported.
+func (ctx *ValidationContext) verifyManifests(el *etree.Element, sig *types.Signature) ([]*ManifestResult, error) {
+	var manifests []*ManifestResult
+
+	// The first Reference is to the signed document, whatever its Type.
+	references := sig.SignedInfo.References
+	for i := 1; i < len(references); i++ {
+		if references[i].Type != ManifestType {
+			continue
+		}
+
+		manifestElement, err := dereference(el, references[i].URI)
+		if err != nil {
+			return nil, err
+		}
+
+		manifest, err := ctx.parseManifest(manifestElement)
+		if err != nil {
+			return nil, err
+		}
+
+		result := &ManifestResult{ID: manifest.ID}
+		for j := range manifest.References {
+			ref := &manifest.References[j]
+			result.References = append(result.References, ManifestReferenceResult{
+				URI: ref.URI,
+				Err: ctx.verifyManifestReference(el, sig, ref),
+			})
+		}
+
+		manifests = append(manifests, result)
+	}
+
+	return manifests, nil
+}
+
+// parseManifest unmarshals manifest, which must be a ds:Manifest, binding
+// the Transform elements of its References.
+func (ctx *ValidationContext) parseManifest(manifest *etree.Element) (*types.Manifest, error) {
+	nsCtx, err := etreeutils.NSBuildParentContext(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	manifestCtx, err := nsCtx.SubContext(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace, err := manifestCtx.LookupPrefix(manifest.Space)
+	if err != nil {
+		return nil, err
+	}
+
+	if manifest.Tag != ManifestTag || namespace != Namespace {
+		return nil, errors.New("Manifest Reference does not refer to a Manifest")
+	}
+
+	parsed := &types.Manifest{}
+	err = etreeutils.NSUnmarshalElement(nsCtx, manifest, parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.limits().checkReferences(parsed.References)
+	if err != nil {
+		return nil, err
+	}
+
+	err = bindReferenceTransforms(manifestCtx, manifest, parsed.References)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsed, nil
+}
+
+// verifyManifestReference verifies ref, a Reference of a Manifest within el.
+func (ctx *ValidationContext) verifyManifestReference(el *etree.Element, sig *types.Signature, ref *types.Reference) error {
+	target, err := dereference(el, ref.URI)
+	if err != nil {
+		return err
+	}
+
+	_, err = ctx.verifyReference(target, sig, ref)
+	return err
+}
@@ -0,0 +1,103 @@
+package dsig
+
+import (
+	"crypto/x509"
+	"testing"
+
+	"github.com/beevik/etree"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/moolekkari/goxmldsig/types"
+)
+
+func TestValidateWithManifests(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	ctx := NewDefaultSigningContext(ks)
+	ctx.ManifestIDs = []string{"attachment-1", "attachment-2"}
+
+	// The attachments are signed only through the Manifest.
+	ctx.Transforms = []types.Transform{
+		NewXPathFilter2Transform(XPathFilter{
+			Filter:     XPathFilterSubtract,
+			XPath:      "//b:Attachment",
+			Namespaces: map[string]string{"b": "urn:bundle"},
+		}),
+	}
+
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(`<Bundle xmlns="urn:bundle"><Index>2 attachments</Index><Attachment Id="attachment-1">first</Attachment><Attachment Id="attachment-2"><!-- comment -->second</Attachment></Bundle>`))
+
+	signed, err := ctx.SignEnveloped(doc.Root())
+	require.NoError(t, err)
+
+	reference := signed.FindElement("./ds:Signature/ds:SignedInfo/ds:Reference[2]")
+	require.NotNil(t, reference)
+	require.Equal(t, ManifestType, reference.SelectAttrValue(TypeAttr, ""))
+
+	manifest := signed.FindElement("./ds:Signature/ds:Object/ds:Manifest")
+	require.NotNil(t, manifest)
+	require.Equal(t, reference.SelectAttrValue(URIAttr, ""), "#"+manifest.SelectAttrValue(IDAttr, ""))
+	require.Len(t, manifest.SelectElements(ReferenceTag), 2)
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+
+	result, err := vc.ValidateWithManifests(signed)
+	require.NoError(t, err)
+	require.True(t, cert.Equal(result.Certificate))
+	require.Len(t, result.Manifests, 1)
+	require.Equal(t, manifest.SelectAttrValue(IDAttr, ""), result.Manifests[0].ID)
+	require.True(t, result.Manifests[0].Valid())
+	require.Equal(t, []ManifestReferenceResult{
+		{URI: "#attachment-1"},
+		{URI: "#attachment-2"},
+	}, result.Manifests[0].References)
+
+	// An altered attachment is reported, without failing the validation.
+	tampered := signed.Copy()
+	tampered.FindElement("./Attachment[@Id='attachment-2']").SetText("altered")
+
+	_, err = vc.Validate(tampered)
+	require.NoError(t, err)
+
+	result, err = vc.ValidateWithManifests(tampered)
+	require.NoError(t, err)
+	require.False(t, result.Manifests[0].Valid())
+	require.NoError(t, result.Manifests[0].References[0].Err)
+	require.EqualError(t, result.Manifests[0].References[1].Err, "Signature could not be verified")
+
+	// So is a missing one.
+	tampered = signed.Copy()
+	tampered.RemoveChild(tampered.FindElement("./Attachment[@Id='attachment-1']"))
+
+	result, err = vc.ValidateWithManifests(tampered)
+	require.NoError(t, err)
+	require.EqualError(t, result.Manifests[0].References[0].Err, "Missing element referenced by #attachment-1")
+	require.NoError(t, result.Manifests[0].References[1].Err)
+
+	// The Manifest itself is signed.
+	tampered = signed.Copy()
+	tampered.FindElement("./ds:Signature/ds:Object/ds:Manifest/ds:Reference/ds:DigestValue").SetText("AAAA")
+
+	_, err = vc.ValidateWithManifests(tampered)
+	require.EqualError(t, err, "Signature could not be verified")
+
+	// Its References are subject to the limits.
+	vc.Limits = &ValidationLimits{MaxReferences: 1}
+	_, err = vc.parseManifest(manifest)
+	require.Equal(t, ErrLimitExceeded{Limit: "MaxReferences", Max: 1}, err)
+}
+
+func TestSignWithMissingManifestTarget(t *testing.T) {
+	ctx := NewDefaultSigningContext(RandomKeyStoreForTest())
+	ctx.ManifestIDs = []string{"missing"}
+
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(`<Bundle xmlns="urn:bundle"/>`))
+
+	_, err := ctx.SignEnveloped(doc.Root())
+	require.EqualError(t, err, "Missing element referenced by #missing")
+}
@@ -0,0 +1,117 @@
+package dsig
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/beevik/etree"
+	"gitlab.com/moolekkari/goxmldsig/etreeutils"
+)
+
+// SAMLAssertionNamespace is the namespace of the SAML 2.0 Issuer element.
+const SAMLAssertionNamespace = "urn:oasis:names:tc:SAML:2.0:assertion"
+
+// ErrUnknownCertificateStore indicates that a CertificateStoreResolver has no
+// certificate store for a document.
+type ErrUnknownCertificateStore struct {
+	// Key is the value the certificate store was looked up by.
+	Key string
+}
+
+func (e ErrUnknownCertificateStore) Error() string {
+	return "No certificate store for " + e.Key
+}
+
+// CertificateStoreResolver selects the certificate store to validate the
+// signature of a document against, such as one per tenant.
+type CertificateStoreResolver interface {
+	// ResolveCertificateStore returns the certificate store for el, the
+	// element being validated. el has not been verified yet, so its content
+	// can only select which certificates to trust, never grant trust
+	// itself. Implementations must not modify el.
+	ResolveCertificateStore(el *etree.Element) (X509CertificateStore, error)
+}
+
+// CertificateStoreResolverFunc adapts a function to a
+// CertificateStoreResolver.
+type CertificateStoreResolverFunc func(el *etree.Element) (X509CertificateStore, error)
+
+// ResolveCertificateStore calls f(el).
+func (f CertificateStoreResolverFunc) ResolveCertificateStore(el *etree.Element) (X509CertificateStore, error) {
+	return f(el)
+}
+
+// NewSAMLIssuerResolver returns a CertificateStoreResolver which selects
+// among stores by the SAML Issuer of a Response or Assertion.
+func NewSAMLIssuerResolver(stores map[string]X509CertificateStore) CertificateStoreResolver {
+	return CertificateStoreResolverFunc(func(el *etree.Element) (X509CertificateStore, error) {
+		ctx, err := etreeutils.NSBuildParentContext(el)
+		if err != nil {
+			return nil, err
+		}
+
+		issuer, err := etreeutils.NSFindOneChildCtx(ctx, el, SAMLAssertionNamespace, "Issuer")
+		if err != nil {
+			return nil, err
+		}
+
+		if issuer == nil {
+			return nil, errors.New("Missing Issuer")
+		}
+
+		return lookupCertificateStore(stores, issuer.Text())
+	})
+}
+
+// NewElementPathResolver returns a CertificateStoreResolver which selects
+// among stores by the text of the element found at path, an etree path
+// relative to the element being validated, such as "./HEADER/FI_CODE".
+func NewElementPathResolver(path string, stores map[string]X509CertificateStore) (CertificateStoreResolver, error) {
+	compiled, err := compilePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return CertificateStoreResolverFunc(func(el *etree.Element) (X509CertificateStore, error) {
+		found := el.FindElementPath(compiled)
+		if found == nil {
+			return nil, errors.New("Missing element " + path)
+		}
+
+		return lookupCertificateStore(stores, found.Text())
+	}), nil
+}
+
+// compilePath compiles an etree path, which panics on some malformed paths
+// rather than failing.
+func compilePath(path string) (compiled etree.Path, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("Invalid path %q: %v", path, r)
+		}
+	}()
+
+	return etree.CompilePath(path)
+}
+
+func lookupCertificateStore(stores map[string]X509CertificateStore, key string) (X509CertificateStore, error) {
+	key = strings.TrimSpace(key)
+
+	store, ok := stores[key]
+	if !ok {
+		return nil, ErrUnknownCertificateStore{Key: key}
+	}
+
+	return store, nil
+}
+
+// certificateStore returns the certificate store to validate the signature
+// of el against.
+func (ctx *ValidationContext) certificateStore(el *etree.Element) (X509CertificateStore, error) {
+	if ctx.CertificateStoreResolver == nil {
+		return ctx.CertificateStore, nil
+	}
+
+	return ctx.CertificateStoreResolver.ResolveCertificateStore(el)
+}
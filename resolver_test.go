@@ -0,0 +1,84 @@
+package dsig
+
+import (
+	"crypto/x509"
+	"testing"
+
+	"github.com/beevik/etree"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/moolekkari/goxmldsig/types"
+)
+
+func TestSAMLIssuerResolver(t *testing.T) {
+	ksA, ksB := RandomKeyStoreForTest(), RandomKeyStoreForTest()
+
+	vc := NewDefaultValidationContext(nil)
+	vc.CertificateStoreResolver = NewSAMLIssuerResolver(map[string]X509CertificateStore{
+		"https://idp-a.example.com": certificateStoreForTest(t, ksA),
+		"https://idp-b.example.com": certificateStoreForTest(t, ksB),
+	})
+
+	response := func(issuer string) string {
+		return `<samlp:Response xmlns:samlp="urn:oasis:names:tc:SAML:2.0:protocol" xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="r1">` +
+			`<saml:Issuer> ` + issuer + ` </saml:Issuer></samlp:Response>`
+	}
+
+	_, err := vc.Validate(signDocumentForTest(t, NewDefaultSigningContext(ksA), response("https://idp-a.example.com")).Root())
+	require.NoError(t, err)
+
+	_, err = vc.Validate(signDocumentForTest(t, NewDefaultSigningContext(ksB), response("https://idp-b.example.com")).Root())
+	require.NoError(t, err)
+
+	// A document claiming another tenant's Issuer is checked against that
+	// tenant's certificates.
+	_, err = vc.Validate(signDocumentForTest(t, NewDefaultSigningContext(ksA), response("https://idp-b.example.com")).Root())
+	require.Error(t, err)
+
+	_, err = vc.Validate(signDocumentForTest(t, NewDefaultSigningContext(ksA), response("https://idp-c.example.com")).Root())
+	require.Equal(t, ErrUnknownCertificateStore{Key: "https://idp-c.example.com"}, err)
+
+	_, err = vc.Validate(signDocumentForTest(t, NewDefaultSigningContext(ksA), `<Response/>`).Root())
+	require.Error(t, err)
+}
+
+func TestElementPathResolver(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+
+	resolver, err := NewElementPathResolver("./HEADER/FI_CODE", map[string]X509CertificateStore{
+		"IN106": certificateStoreForTest(t, ks),
+	})
+	require.NoError(t, err)
+
+	vc := NewDefaultValidationContext(nil)
+	vc.CertificateStoreResolver = resolver
+
+	_, err = vc.Validate(signDocumentForTest(t, NewDefaultSigningContext(ks), `<REQ_ROOT><HEADER><FI_CODE>IN106</FI_CODE></HEADER></REQ_ROOT>`).Root())
+	require.NoError(t, err)
+
+	_, err = vc.Validate(signDocumentForTest(t, NewDefaultSigningContext(ks), `<REQ_ROOT><HEADER><FI_CODE>IN107</FI_CODE></HEADER></REQ_ROOT>`).Root())
+	require.Equal(t, ErrUnknownCertificateStore{Key: "IN107"}, err)
+
+	_, err = NewElementPathResolver("./HEADER[", nil)
+	require.Error(t, err)
+}
+
+func TestCertificateStoreResolverWithoutStore(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	signed := signDocumentForTest(t, NewDefaultSigningContext(ks), `<Root><Child/></Root>`).Root()
+
+	vc := NewDefaultValidationContext(nil)
+	vc.CertificateStoreResolver = CertificateStoreResolverFunc(func(*etree.Element) (X509CertificateStore, error) {
+		return nil, nil
+	})
+
+	_, err := vc.Validate(signed)
+	require.EqualError(t, err, "Validation requires a CertificateStore or a Trust callback")
+
+	// The Trust callback decides without a store.
+	vc.Trust = func(*x509.Certificate, []*x509.Certificate, *types.KeyInfo) error {
+		return nil
+	}
+
+	_, err = vc.Validate(signed)
+	require.NoError(t, err)
+}
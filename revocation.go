@@ -0,0 +1,174 @@
+package dsig
+
+import (
+	"crypto/x509"
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// ValidationData is the data needed to validate certificates offline: the
+// certificates of their chains, and revocation information about them.
+type ValidationData struct {
+	Certificates []*x509.Certificate
+
+	// CRLs and OCSPResponses hold DER encoded CRLs and OCSP responses.
+	CRLs          [][]byte
+	OCSPResponses [][]byte
+}
+
+// ErrCertificateRevoked indicates that a certificate had been revoked by
+// the time it had to be valid.
+type ErrCertificateRevoked struct {
+	// Subject is the subject of the certificate, as formatted by
+	// pkix.Name.String.
+	Subject        string
+	RevocationTime time.Time
+}
+
+func (e ErrCertificateRevoked) Error() string {
+	return "Certificate " + e.Subject + " was revoked at " + e.RevocationTime.UTC().Format(time.RFC3339)
+}
+
+// ErrMissingRevocationData indicates that no CRL or OCSP response shows
+// whether a certificate was revoked.
+type ErrMissingRevocationData struct {
+	// Subject is the subject of the certificate, as formatted by
+	// pkix.Name.String.
+	Subject string
+}
+
+func (e ErrMissingRevocationData) Error() string {
+	return "Missing revocation data for " + e.Subject
+}
+
+// verifyChain returns the chain of cert to one of roots, through
+// intermediates, as of the time at.
+func verifyChain(cert *x509.Certificate, roots, intermediates []*x509.Certificate, at time.Time, usage x509.ExtKeyUsage) ([]*x509.Certificate, error) {
+	rootPool := x509.NewCertPool()
+	for _, root := range roots {
+		rootPool.AddCert(root)
+	}
+
+	intermediatePool := x509.NewCertPool()
+	for _, intermediate := range intermediates {
+		intermediatePool.AddCert(intermediate)
+	}
+
+	chains, err := cert.Verify(x509.VerifyOptions{
+		Roots:         rootPool,
+		Intermediates: intermediatePool,
+		CurrentTime:   at,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return chains[0], nil
+}
+
+// checkRevocation checks that no certificate of chain, but its root, had
+// been revoked at the time at. Revocation data issued before then does not
+// show that, so is disregarded, and any data showing a revocation prevails.
+func (data *ValidationData) checkRevocation(chain []*x509.Certificate, at time.Time) error {
+	for i := 0; i+1 < len(chain); i++ {
+		err := data.checkCertificateRevocation(chain[i], chain[i+1], at)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (data *ValidationData) checkCertificateRevocation(cert, issuer *x509.Certificate, at time.Time) error {
+	if data == nil {
+		return ErrMissingRevocationData{Subject: cert.Subject.String()}
+	}
+
+	checked := false
+
+	for _, der := range data.CRLs {
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return err
+		}
+
+		if crl.CheckSignatureFrom(issuer) != nil || crl.ThisUpdate.Before(at) {
+			continue
+		}
+
+		for _, revoked := range crl.RevokedCertificateEntries {
+			if revoked.SerialNumber.Cmp(cert.SerialNumber) == 0 && !revoked.RevocationTime.After(at) {
+				return ErrCertificateRevoked{Subject: cert.Subject.String(), RevocationTime: revoked.RevocationTime}
+			}
+		}
+
+		checked = true
+	}
+
+	for _, der := range data.OCSPResponses {
+		response, err := parseOCSPResponse(der, cert, issuer)
+		if err != nil {
+			return err
+		}
+
+		if response == nil || response.ThisUpdate.Before(at) || response.Status == ocsp.Unknown {
+			continue
+		}
+
+		if response.Status == ocsp.Revoked && !response.RevokedAt.After(at) {
+			return ErrCertificateRevoked{Subject: cert.Subject.String(), RevocationTime: response.RevokedAt}
+		}
+
+		checked = true
+	}
+
+	if !checked {
+		return ErrMissingRevocationData{Subject: cert.Subject.String()}
+	}
+
+	return nil
+}
+
+// errNoMatchingOCSPResponse is returned by ocsp.ParseResponseForCert for
+// responses which are not about the certificate.
+var errNoMatchingOCSPResponse = ocsp.ParseError("no response matching the supplied certificate")
+
+// parseOCSPResponse verifies the OCSP response der, which must be signed by
+// issuer or by a responder issuer delegated OCSP signing to, and returns its
+// response about cert, or nil if it has none.
+func parseOCSPResponse(der []byte, cert, issuer *x509.Certificate) (*ocsp.Response, error) {
+	// Without an issuer, only the signature of any responder certificate
+	// is verified, which leaves the checks below to tell why the response
+	// can not be trusted.
+	response, err := ocsp.ParseResponseForCert(der, cert, nil)
+	if err == errNoMatchingOCSPResponse {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.New("Invalid OCSP response: " + err.Error())
+	}
+
+	responder := response.Certificate
+	if responder == nil || responder.Equal(issuer) {
+		if response.CheckSignatureFrom(issuer) != nil {
+			return nil, errors.New("OCSP response signature could not be verified")
+		}
+		return response, nil
+	}
+
+	// A delegated responder must be certified by issuer for OCSP signing,
+	// and have been valid when it produced the response.
+	if responder.CheckSignatureFrom(issuer) != nil || !hasExtKeyUsage(responder, x509.ExtKeyUsageOCSPSigning) {
+		return nil, errors.New("OCSP response signature could not be verified")
+	}
+
+	if response.ProducedAt.Before(responder.NotBefore) || response.ProducedAt.After(responder.NotAfter) {
+		return nil, errors.New("OCSP responder certificate was not valid when the response was produced")
+	}
+
+	return response, nil
+}
@@ -0,0 +1,232 @@
+package dsig
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ocsp"
+)
+
+// testCA is a certificate authority issuing certificates, CRLs and OCSP
+// responses for tests.
+type testCA struct {
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+
+	// issuer is the CA an OCSP responder responds for.
+	issuer *x509.Certificate
+}
+
+func newTestCA(t *testing.T) *testCA {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	now := time.Now()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "Test CA"},
+		NotBefore:    now.Add(-24 * time.Hour),
+		NotAfter:     now.Add(10 * 365 * 24 * time.Hour),
+
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{key: key, cert: cert}
+}
+
+// issue returns a key store for a new key, certified by ca.
+func (ca *testCA) issue(t *testing.T, serial int64, commonName string) *MemoryX509KeyStore {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	now := time.Now()
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-12 * time.Hour),
+		NotAfter:     now.Add(365 * 24 * time.Hour),
+
+		KeyUsage: x509.KeyUsageDigitalSignature,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	return &MemoryX509KeyStore{privateKey: key, cert: der}
+}
+
+// crl returns a CRL issued at thisUpdate, revoking the certificates of
+// revoked at the times they map to.
+func (ca *testCA) crl(t *testing.T, thisUpdate time.Time, revoked map[*x509.Certificate]time.Time) []byte {
+	var entries []x509.RevocationListEntry
+	for cert, revocationTime := range revoked {
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   cert.SerialNumber,
+			RevocationTime: revocationTime,
+		})
+	}
+
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(thisUpdate.Unix()),
+		ThisUpdate:                thisUpdate,
+		NextUpdate:                thisUpdate.Add(24 * time.Hour),
+		RevokedCertificateEntries: entries,
+	}, ca.cert, ca.key)
+	require.NoError(t, err)
+
+	return der
+}
+
+// ocspResponder returns a responder ca delegates OCSP signing to, with a
+// certificate valid from notBefore to notAfter for usages.
+func (ca *testCA) ocspResponder(t *testing.T, notBefore, notAfter time.Time, usages ...x509.ExtKeyUsage) *testCA {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(notBefore.UnixNano()),
+		Subject:      pkix.Name{CommonName: "Test OCSP Responder"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: usages,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	return &testCA{key: key, cert: cert, issuer: ca.cert}
+}
+
+// ocspResponse returns an OCSP response about cert, as of thisUpdate,
+// which shows it revoked at revocationTime unless that is zero.
+func (ca *testCA) ocspResponse(t *testing.T, cert *x509.Certificate, thisUpdate, revocationTime time.Time) []byte {
+	template := ocsp.Response{
+		Status:       ocsp.Good,
+		SerialNumber: cert.SerialNumber,
+		ThisUpdate:   thisUpdate,
+	}
+	if !revocationTime.IsZero() {
+		template.Status = ocsp.Revoked
+		template.RevokedAt = revocationTime
+	}
+
+	issuer := ca.cert
+	if ca.issuer != nil {
+		issuer = ca.issuer
+		template.Certificate = ca.cert
+	}
+
+	der, err := ocsp.CreateResponse(issuer, ca.cert, template, ca.key)
+	require.NoError(t, err)
+
+	return der
+}
+
+func TestCheckRevocation(t *testing.T) {
+	ca := newTestCA(t)
+
+	_, cert, err := ca.issue(t, 2, "Signer").GetKeyPair()
+	require.NoError(t, err)
+
+	chain := []*x509.Certificate{cert, ca.cert}
+	at := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	var missing *ValidationData
+	require.Equal(t, ErrMissingRevocationData{Subject: "CN=Signer"}, missing.checkRevocation(chain, at))
+
+	// The root of the chain is trusted as is.
+	require.NoError(t, missing.checkRevocation([]*x509.Certificate{ca.cert}, at))
+
+	data := &ValidationData{CRLs: [][]byte{ca.crl(t, at.Add(time.Minute), nil)}}
+	require.NoError(t, data.checkRevocation(chain, at))
+
+	// Revocation data issued before the time at does not show that the
+	// certificate was not revoked by then.
+	require.Equal(t, ErrMissingRevocationData{Subject: "CN=Signer"}, data.checkRevocation(chain, at.Add(time.Hour)))
+
+	revokedAt := at.Add(-time.Minute)
+	data = &ValidationData{CRLs: [][]byte{ca.crl(t, at.Add(time.Minute), map[*x509.Certificate]time.Time{cert: revokedAt})}}
+	require.Equal(t, ErrCertificateRevoked{Subject: "CN=Signer", RevocationTime: revokedAt.UTC()}, data.checkRevocation(chain, at))
+
+	// A revocation after the time at does not matter.
+	require.NoError(t, data.checkRevocation(chain, at.Add(-2*time.Minute)))
+
+	// CRLs of other issuers are disregarded.
+	data = &ValidationData{CRLs: [][]byte{newTestCA(t).crl(t, at.Add(time.Minute), nil)}}
+	require.Equal(t, ErrMissingRevocationData{Subject: "CN=Signer"}, data.checkRevocation(chain, at))
+}
+
+func TestCheckRevocationOCSP(t *testing.T) {
+	ca := newTestCA(t)
+
+	_, cert, err := ca.issue(t, 2, "Signer").GetKeyPair()
+	require.NoError(t, err)
+
+	_, other, err := ca.issue(t, 3, "Other").GetKeyPair()
+	require.NoError(t, err)
+
+	chain := []*x509.Certificate{cert, ca.cert}
+	at := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	data := &ValidationData{OCSPResponses: [][]byte{ca.ocspResponse(t, cert, at.Add(time.Minute), time.Time{})}}
+	require.NoError(t, data.checkRevocation(chain, at))
+
+	revokedAt := at.Add(-time.Minute)
+	data = &ValidationData{OCSPResponses: [][]byte{ca.ocspResponse(t, cert, at.Add(time.Minute), revokedAt)}}
+	require.Equal(t, ErrCertificateRevoked{Subject: "CN=Signer", RevocationTime: revokedAt.UTC()}, data.checkRevocation(chain, at))
+
+	// Responses about other certificates are disregarded.
+	data = &ValidationData{OCSPResponses: [][]byte{ca.ocspResponse(t, other, at.Add(time.Minute), time.Time{})}}
+	require.Equal(t, ErrMissingRevocationData{Subject: "CN=Signer"}, data.checkRevocation(chain, at))
+
+	// The response must be signed by the issuer.
+	forged := newTestCA(t)
+	forged.cert = ca.cert
+	data = &ValidationData{OCSPResponses: [][]byte{forged.ocspResponse(t, cert, at.Add(time.Minute), time.Time{})}}
+	require.EqualError(t, data.checkRevocation(chain, at), "OCSP response signature could not be verified")
+
+	// Delegated responders must be certified by the issuer for OCSP signing,
+	// and valid when they produced the response.
+	now := time.Now()
+	responder := ca.ocspResponder(t, now.Add(-time.Hour), now.Add(time.Hour), x509.ExtKeyUsageOCSPSigning)
+	data = &ValidationData{OCSPResponses: [][]byte{responder.ocspResponse(t, cert, at.Add(time.Minute), time.Time{})}}
+	require.NoError(t, data.checkRevocation(chain, at))
+
+	responder = ca.ocspResponder(t, now.Add(-time.Hour), now.Add(time.Hour))
+	data = &ValidationData{OCSPResponses: [][]byte{responder.ocspResponse(t, cert, at.Add(time.Minute), time.Time{})}}
+	require.EqualError(t, data.checkRevocation(chain, at), "OCSP response signature could not be verified")
+
+	responder = newTestCA(t).ocspResponder(t, now.Add(-time.Hour), now.Add(time.Hour), x509.ExtKeyUsageOCSPSigning)
+	responder.issuer = ca.cert
+	data = &ValidationData{OCSPResponses: [][]byte{responder.ocspResponse(t, cert, at.Add(time.Minute), time.Time{})}}
+	require.EqualError(t, data.checkRevocation(chain, at), "OCSP response signature could not be verified")
+
+	responder = ca.ocspResponder(t, now.Add(-2*time.Hour), now.Add(-time.Hour), x509.ExtKeyUsageOCSPSigning)
+	data = &ValidationData{OCSPResponses: [][]byte{responder.ocspResponse(t, cert, at.Add(time.Minute), time.Time{})}}
+	require.EqualError(t, data.checkRevocation(chain, at), "OCSP responder certificate was not valid when the response was produced")
+
+	responder = ca.ocspResponder(t, now.Add(time.Hour), now.Add(2*time.Hour), x509.ExtKeyUsageOCSPSigning)
+	data = &ValidationData{OCSPResponses: [][]byte{responder.ocspResponse(t, cert, at.Add(time.Minute), time.Time{})}}
+	require.EqualError(t, data.checkRevocation(chain, at), "OCSP responder certificate was not valid when the response was produced")
+}
@@ -2,18 +2,13 @@ package dsig
 
 import (
 	"crypto"
-	"crypto/rand"
-	"crypto/rsa"
-
-	// implimenting sha1 and sha256.
-	_ "crypto/sha1"
-	_ "crypto/sha256"
-	"encoding/base64"
+	"crypto/x509"
 	"errors"
 	"fmt"
 
 	"github.com/beevik/etree"
-	"github.com/russellhaering/goxmldsig/etreeutils"
+	"gitlab.com/moolekkari/goxmldsig/etreeutils"
+	"gitlab.com/moolekkari/goxmldsig/types"
 )
 
 // SigningContext is a base structure for signing.
@@ -22,6 +17,39 @@ type SigningContext struct {
 	KeyStore      X509KeyStore
 	Prefix        string
 	Canonicalizer Canonicalizer
+
+	// SignatureMethod and DigestMethod select registered algorithms by URI.
+	// When empty, the algorithms registered as defaults for Hash (and, for
+	// the SignatureMethod, the type of the signing key) are used.
+	SignatureMethod string
+	DigestMethod    string
+
+	// AlgorithmPolicy restricts the signature and digest algorithms which
+	// may be used. A nil AlgorithmPolicy permits all registered algorithms.
+	AlgorithmPolicy *AlgorithmPolicy
+
+	// Transforms are additional Reference transforms, resolved through the
+	// transform registry and applied in order after the enveloped signature
+	// transform and before canonicalization.
+	Transforms []types.Transform
+
+	// WrapBase64 wraps the DigestValue, SignatureValue and X509Certificate
+	// over lines of 76 characters, as Java and .NET implementations do.
+	WrapBase64 bool
+
+	// SignatureProperties are signed along with the document, in a
+	// ds:SignatureProperties held in a ds:Object of the Signature, which is
+	// given an Id for them to target if it has none.
+	SignatureProperties []SignatureProperty
+
+	// ManifestIDs are the Ids of elements of the signed document which are
+	// also signed through a ds:Manifest, held in a ds:Object of the
+	// Signature. Validators check the References of a Manifest at their
+	// discretion, see ValidationContext.ValidateWithManifests. For changes
+	// to these elements to be reported rather than to invalidate the
+	// signature, exclude them from the signed document with Transforms,
+	// such as an XPath Filter 2.0 subtract filter.
+	ManifestIDs []string
 }
 
 // NewDefaultSigningContext is for creating a default signing context.
@@ -46,25 +74,116 @@ func NewKYCSigningContext(ks X509KeyStore) *SigningContext {
 
 // SetSignatureMethod to set signature method.
 func (ctx *SigningContext) SetSignatureMethod(algorithmID string) error {
-	hash, ok := signatureMethodsByIdentifier[algorithmID]
+	alg, ok := LookupSignatureAlgorithm(algorithmID)
 	if !ok {
 		return fmt.Errorf("Unknown SignatureMethod: %s", algorithmID)
 	}
 
-	ctx.Hash = hash
+	ctx.Hash = alg.Hash
+	ctx.SignatureMethod = algorithmID
 
 	return nil
 }
 
-// digest will create digest of the signature.
-func (ctx *SigningContext) digest(el *etree.Element) ([]byte, error) {
-	canonical, err := ctx.Canonicalizer.Canonicalize(el)
+// SetDigestMethod to set the digest method used for References.
+func (ctx *SigningContext) SetDigestMethod(algorithmID string) error {
+	if _, ok := LookupDigestAlgorithm(algorithmID); !ok {
+		return fmt.Errorf("Unknown DigestMethod: %s", algorithmID)
+	}
+
+	ctx.DigestMethod = algorithmID
+
+	return nil
+}
+
+// signer returns the key and certificate used for signing. Key stores which
+// implement X509SignerStore take precedence over GetKeyPair, which only
+// supports RSA keys.
+func (ctx *SigningContext) signer() (crypto.Signer, *x509.Certificate, error) {
+	if ss, ok := ctx.KeyStore.(X509SignerStore); ok {
+		return ss.GetSigner()
+	}
+
+	key, cert, err := ctx.KeyStore.GetKeyPair()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+
+	return key, cert, nil
+}
+
+// signatureMethodIdentifier returns the SignatureMethod for signing with a
+// key of type keyAlgorithm.
+func (ctx *SigningContext) signatureMethodIdentifier(keyAlgorithm x509.PublicKeyAlgorithm) string {
+	if ctx.SignatureMethod != "" {
+		return ctx.SignatureMethod
 	}
+	return defaultSignatureMethodIdentifier(keyAlgorithm, ctx.Hash)
+}
 
-	hash := ctx.Hash.New()
-	_, err = hash.Write(canonical)
+// signatureAlgorithm resolves the signature algorithm selected by the context
+// for signing with key.
+func (ctx *SigningContext) signatureAlgorithm(key crypto.Signer) (SignatureAlgorithm, error) {
+	signatureMethodIdentifier := ctx.signatureMethodIdentifier(publicKeyAlgorithm(key.Public()))
+	if signatureMethodIdentifier == "" {
+		return SignatureAlgorithm{}, errors.New("unsupported signature method")
+	}
+
+	return ctx.AlgorithmPolicy.signatureAlgorithm(signatureMethodIdentifier)
+}
+
+// digestAlgorithm resolves the digest algorithm selected by the context.
+func (ctx *SigningContext) digestAlgorithm() (DigestAlgorithm, error) {
+	digestAlgorithmIdentifier := ctx.GetDigestAlgorithmIdentifier()
+	if digestAlgorithmIdentifier == "" {
+		return DigestAlgorithm{}, errors.New("unsupported hash mechanism")
+	}
+
+	return ctx.AlgorithmPolicy.digestAlgorithm(digestAlgorithmIdentifier)
+}
+
+// SetCanonicalizer selects the registered Canonicalizer for algorithmID.
+func (ctx *SigningContext) SetCanonicalizer(algorithmID string) error {
+	canonicalizer, err := NewCanonicalizer(&types.Transform{Algorithm: algorithmID})
+	if err != nil {
+		return err
+	}
+
+	ctx.Canonicalizer = canonicalizer
+
+	return nil
+}
+
+// transform applies the context's additional Transforms to el.
+func (ctx *SigningContext) transform(el *etree.Element) (*TransformData, error) {
+	tctx := &TransformContext{}
+	data := &TransformData{NodeSet: etreeutils.NewNodeSet(el)}
+
+	for i := range ctx.Transforms {
+		t, err := NewTransform(&ctx.Transforms[i])
+		if err != nil {
+			return nil, err
+		}
+
+		data, err = applyTransform(t, data, tctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
+
+// digest will create digest of the signature.
+func (ctx *SigningContext) digest(el *etree.Element, h crypto.Hash) ([]byte, error) {
+	return ctx.digestData(&TransformData{NodeSet: etreeutils.NewNodeSet(el)}, h)
+}
+
+// digestData digests transformed content, canonicalizing it first if it is
+// a node-set. The canonical form is streamed into the hash.
+func (ctx *SigningContext) digestData(data *TransformData, h crypto.Hash) ([]byte, error) {
+	hash := h.New()
+	err := writeCanonicalData(hash, ctx.Canonicalizer, data)
 	if err != nil {
 		return nil, err
 	}
@@ -73,18 +192,23 @@ func (ctx *SigningContext) digest(el *etree.Element) ([]byte, error) {
 }
 
 // constructSignedInfo will create etree nodes for signed info tag.
-func (ctx *SigningContext) constructSignedInfo(el *etree.Element, enveloped bool) (*etree.Element, error) {
-	digestAlgorithmIdentifier := ctx.GetDigestAlgorithmIdentifier()
-	if digestAlgorithmIdentifier == "" {
-		return nil, errors.New("unsupported hash mechanism")
+func (ctx *SigningContext) constructSignedInfo(el *etree.Element, enveloped bool, signatureAlgorithm SignatureAlgorithm) (*etree.Element, error) {
+	digestAlgorithm, err := ctx.digestAlgorithm()
+	if err != nil {
+		return nil, err
 	}
 
-	signatureMethodIdentifier := ctx.GetSignatureMethodIdentifier()
-	if signatureMethodIdentifier == "" {
-		return nil, errors.New("unsupported signature method")
+	// The Reference is a same-document reference, so comments are never part
+	// of the digested content, even with a #WithComments canonicalizer.
+	referenced := el.Copy()
+	removeComments(referenced)
+
+	transformed, err := ctx.transform(referenced)
+	if err != nil {
+		return nil, err
 	}
 
-	digest, err := ctx.digest(el)
+	digest, err := ctx.digestData(transformed, digestAlgorithm.Hash)
 	if err != nil {
 		return nil, err
 	}
@@ -95,12 +219,14 @@ func (ctx *SigningContext) constructSignedInfo(el *etree.Element, enveloped bool
 	}
 
 	// /SignedInfo/CanonicalizationMethod
-	canonicalizationMethod := ctx.createNamespacedElement(signedInfo, CanonicalizationMethodTag)
-	canonicalizationMethod.CreateAttr(AlgorithmAttr, string(ctx.Canonicalizer.Algorithm()))
+	err = ctx.createCanonicalizationElement(signedInfo, CanonicalizationMethodTag, ctx.Canonicalizer)
+	if err != nil {
+		return nil, err
+	}
 
 	// /SignedInfo/SignatureMethod
 	signatureMethod := ctx.createNamespacedElement(signedInfo, SignatureMethodTag)
-	signatureMethod.CreateAttr(AlgorithmAttr, signatureMethodIdentifier)
+	signatureMethod.CreateAttr(AlgorithmAttr, signatureAlgorithm.URI)
 
 	// /SignedInfo/Reference
 	reference := ctx.createNamespacedElement(signedInfo, ReferenceTag)
@@ -118,27 +244,92 @@ func (ctx *SigningContext) constructSignedInfo(el *etree.Element, enveloped bool
 		envelopedTransform := ctx.createNamespacedElement(transforms, TransformTag)
 		envelopedTransform.CreateAttr(AlgorithmAttr, EnvelopedSignatureAltorithmID.String())
 	}
-	canonicalizationAlgorithm := ctx.createNamespacedElement(transforms, TransformTag)
-	canonicalizationAlgorithm.CreateAttr(AlgorithmAttr, string(ctx.Canonicalizer.Algorithm()))
+	for i := range ctx.Transforms {
+		err := ctx.createTransform(transforms, &ctx.Transforms[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Octet stream content is digested as is, and must not be parsed and
+	// canonicalized when validating.
+	if !transformed.IsOctetStream() {
+		err := ctx.createCanonicalizationElement(transforms, TransformTag, ctx.Canonicalizer)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	// /SignedInfo/Reference/DigestMethod
 	digestMethod := ctx.createNamespacedElement(reference, DigestMethodTag)
-	digestMethod.CreateAttr(AlgorithmAttr, digestAlgorithmIdentifier)
+	digestMethod.CreateAttr(AlgorithmAttr, digestAlgorithm.URI)
 
 	// /SignedInfo/Reference/DigestValue
 	digestValue := ctx.createNamespacedElement(reference, DigestValueTag)
-	digestValue.SetText(base64.StdEncoding.EncodeToString(digest))
+	digestValue.SetText(ctx.encodeBase64(digest))
 
 	return signedInfo, nil
 }
 
 // ConstructSignature will construct etree nodes for signature.
 func (ctx *SigningContext) ConstructSignature(el *etree.Element, enveloped bool) (*etree.Element, error) {
-	signedInfo, err := ctx.constructSignedInfo(el, enveloped)
+	return ctx.constructSignature(el, enveloped, nil)
+}
+
+// signatureContent is content added to a Signature beyond the Reference to
+// the signed document, by layers such as XAdES.
+type signatureContent struct {
+	// id is the Id of the Signature element, if any.
+	id string
+
+	// uri and referenceType are the URI and Type of the Reference to the
+	// signed element, if any.
+	uri           string
+	referenceType string
+
+	// objects are signed along with the document.
+	objects []signedObject
+}
+
+// signedObject is a ds:Object of a Signature, part of which is signed
+// through a Reference from the SignedInfo.
+type signedObject struct {
+	// object is the ds:Object element.
+	object *etree.Element
+
+	// target is the element within object which the Reference points at,
+	// by its Id.
+	target *etree.Element
+
+	// referenceType is the Type of the Reference, if any.
+	referenceType string
+}
+
+func (ctx *SigningContext) constructSignature(el *etree.Element, enveloped bool, content *signatureContent) (*etree.Element, error) {
+	key, cert, err := ctx.signer()
 	if err != nil {
 		return nil, err
 	}
 
+	signatureAlgorithm, err := ctx.signatureAlgorithm(key)
+	if err != nil {
+		return nil, err
+	}
+
+	signedInfo, err := ctx.constructSignedInfo(el, enveloped, signatureAlgorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	if content != nil && (content.uri != "" || content.referenceType != "") {
+		reference := signedInfo.SelectElement(ReferenceTag)
+		if content.uri != "" {
+			reference.CreateAttr(URIAttr, content.uri)
+		}
+		if content.referenceType != "" {
+			reference.CreateAttr(TypeAttr, content.referenceType)
+		}
+	}
+
 	sig := &etree.Element{
 		Tag:   SignatureTag,
 		Space: ctx.Prefix,
@@ -149,7 +340,23 @@ func (ctx *SigningContext) ConstructSignature(el *etree.Element, enveloped bool)
 		xmlns += ":" + ctx.Prefix
 	}
 
+	sigID := ""
+	if content != nil {
+		sigID = content.id
+	}
+
+	// SignatureProperties target the Signature by its Id.
+	if sigID == "" && len(ctx.SignatureProperties) > 0 {
+		sigID, err = randomID("xmldsig-")
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	sig.CreateAttr(xmlns, Namespace)
+	if sigID != "" {
+		sig.CreateAttr(IDAttr, sigID)
+	}
 	sig.AddChild(signedInfo)
 
 	// When using xml-c14n11 (ie, non-exclusive canonicalization) the canonical form
@@ -175,6 +382,32 @@ func (ctx *SigningContext) ConstructSignature(el *etree.Element, enveloped bool)
 		return nil, err
 	}
 
+	var objects []signedObject
+	if content != nil {
+		objects = content.objects
+	}
+
+	if len(ctx.ManifestIDs) > 0 {
+		manifest, err := ctx.manifestObject(el, sigID)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, manifest)
+	}
+
+	if len(ctx.SignatureProperties) > 0 {
+		objects = append(objects, ctx.signaturePropertiesObject(sigID))
+	}
+
+	// Objects are referenced from the SignedInfo, so must be digested
+	// before it is.
+	for _, object := range objects {
+		err := ctx.appendObjectReference(signedInfo, sigNSCtx, object)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// Finally detatch the SignedInfo in order to capture all of the namespace
 	// declarations in the scope we've constructed.
 	detatchedSignedInfo, err := etreeutils.NSDetatch(sigNSCtx, signedInfo)
@@ -183,23 +416,18 @@ func (ctx *SigningContext) ConstructSignature(el *etree.Element, enveloped bool)
 	}
 	detatchedSignedInfo.RemoveAttr("xmlns:xsi")
 
-	digest, err := ctx.digest(detatchedSignedInfo)
-	if err != nil {
-		return nil, err
-	}
-
-	key, cert, err := ctx.KeyStore.GetKeyPair()
+	digest, err := ctx.digest(detatchedSignedInfo, signatureAlgorithm.Hash)
 	if err != nil {
 		return nil, err
 	}
 
-	rawSignature, err := rsa.SignPKCS1v15(rand.Reader, key, ctx.Hash, digest)
+	rawSignature, err := signatureAlgorithm.Signer.Sign(key, signatureAlgorithm.Hash, digest)
 	if err != nil {
 		return nil, err
 	}
 
 	signatureValue := ctx.createNamespacedElement(sig, SignatureValueTag)
-	signatureValue.SetText(base64.StdEncoding.EncodeToString(rawSignature))
+	signatureValue.SetText(ctx.encodeBase64(rawSignature))
 
 	keyInfo := ctx.createNamespacedElement(sig, KeyInfoTag)
 	x509Data := ctx.createNamespacedElement(keyInfo, X509DataTag)
@@ -210,11 +438,154 @@ func (ctx *SigningContext) ConstructSignature(el *etree.Element, enveloped bool)
 		x509Subject := ctx.createNamespacedElement(x509Data, X509SubjectNameTag)
 		x509Subject.SetText(sub)
 	}
-	x509Certificate.SetText(base64.StdEncoding.EncodeToString(cert.Raw))
+	x509Certificate.SetText(ctx.encodeBase64(cert.Raw))
+
+	for _, object := range objects {
+		sig.AddChild(object.object)
+	}
 
 	return sig, nil
 }
 
+// appendObjectReference digests the target of object, in the namespace
+// context it will have once object is added to the Signature, and appends a
+// Reference to it to signedInfo. sigNSCtx is the context within the
+// Signature.
+func (ctx *SigningContext) appendObjectReference(signedInfo *etree.Element, sigNSCtx etreeutils.NSContext, object signedObject) error {
+	digestAlgorithm, err := ctx.digestAlgorithm()
+	if err != nil {
+		return err
+	}
+
+	id := object.target.SelectAttrValue(IDAttr, "")
+	if id == "" {
+		return errors.New("Missing Id of signed Object content")
+	}
+
+	nsCtx, err := descendantContext(sigNSCtx, object.object, object.target)
+	if err != nil {
+		return err
+	}
+
+	detached, err := etreeutils.NSDetatch(nsCtx, object.target)
+	if err != nil {
+		return err
+	}
+
+	digest, err := ctx.digest(detached, digestAlgorithm.Hash)
+	if err != nil {
+		return err
+	}
+
+	return ctx.createReference(signedInfo, "#"+id, object.referenceType, digestAlgorithm, digest)
+}
+
+// createReference appends to parent a Reference to uri, of the Type
+// referenceType if it is not empty, whose content is canonicalized by the
+// context's Canonicalizer and has the given digest.
+func (ctx *SigningContext) createReference(parent *etree.Element, uri, referenceType string, digestAlgorithm DigestAlgorithm, digest []byte) error {
+	reference := ctx.createNamespacedElement(parent, ReferenceTag)
+	reference.CreateAttr(URIAttr, uri)
+	if referenceType != "" {
+		reference.CreateAttr(TypeAttr, referenceType)
+	}
+
+	transforms := ctx.createNamespacedElement(reference, TransformsTag)
+	err := ctx.createCanonicalizationElement(transforms, TransformTag, ctx.Canonicalizer)
+	if err != nil {
+		return err
+	}
+
+	digestMethod := ctx.createNamespacedElement(reference, DigestMethodTag)
+	digestMethod.CreateAttr(AlgorithmAttr, digestAlgorithm.URI)
+
+	digestValue := ctx.createNamespacedElement(reference, DigestValueTag)
+	digestValue.SetText(ctx.encodeBase64(digest))
+
+	return nil
+}
+
+// descendantContext returns the namespace context surrounding el, a
+// descendant of root, given ctx, the context surrounding root.
+func descendantContext(ctx etreeutils.NSContext, root, el *etree.Element) (etreeutils.NSContext, error) {
+	var ancestors []*etree.Element
+	for parent := el.Parent(); parent != nil; parent = parent.Parent() {
+		ancestors = append(ancestors, parent)
+		if parent == root {
+			break
+		}
+	}
+
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		var err error
+		ctx, err = ctx.SubContext(ancestors[i])
+		if err != nil {
+			return ctx, err
+		}
+	}
+
+	return ctx, nil
+}
+
+// createTransform creates a Transform element for t, copying any parameters
+// from the element t was unmarshaled from.
+func (ctx *SigningContext) createTransform(transforms *etree.Element, t *types.Transform) error {
+	return ctx.createAlgorithmElement(transforms, TransformTag, t)
+}
+
+// createCanonicalizationElement creates a CanonicalizationMethod or
+// Transform element, as tag says, for canonicalizer. The PrefixList of an
+// exclusive canonicalizer is written as InclusiveNamespaces, lest
+// validators canonicalize without it.
+func (ctx *SigningContext) createCanonicalizationElement(parent *etree.Element, tag string, canonicalizer Canonicalizer) error {
+	t := &types.Transform{Algorithm: string(canonicalizer.Algorithm())}
+	if c, ok := canonicalizer.(*c14N10ExclusiveCanonicalizer); ok && c.prefixList != "" {
+		t.InclusiveNamespaces = &types.InclusiveNamespaces{PrefixList: c.prefixList}
+	}
+
+	return ctx.createAlgorithmElement(parent, tag, t)
+}
+
+// createAlgorithmElement creates an element named tag, such as a Transform,
+// with the Algorithm and parameters of t.
+func (ctx *SigningContext) createAlgorithmElement(parent *etree.Element, tag string, t *types.Transform) error {
+	transform := ctx.createNamespacedElement(parent, tag)
+	transform.CreateAttr(AlgorithmAttr, t.Algorithm)
+
+	if t.InclusiveNamespaces != nil {
+		inclusiveNamespaces := transform.CreateElement(InclusiveNamespacesTag)
+		inclusiveNamespaces.Space = "ec"
+		inclusiveNamespaces.CreateAttr("xmlns:ec", string(CanonicalXML10ExclusiveAlgorithmID))
+		inclusiveNamespaces.CreateAttr(PrefixListAttr, t.InclusiveNamespaces.PrefixList)
+	}
+
+	if el := t.UnderlyingElement(); el != nil {
+		nsCtx, err := etreeutils.NSBuildParentContext(el)
+		if err != nil {
+			return err
+		}
+
+		err = etreeutils.NSIterateChildren(nsCtx, el, func(nsCtx etreeutils.NSContext, child *etree.Element) error {
+			if child.Tag == InclusiveNamespacesTag && t.InclusiveNamespaces != nil {
+				return nil
+			}
+
+			detached, err := etreeutils.NSDetatch(nsCtx, child)
+			if err != nil {
+				return err
+			}
+
+			transform.AddChild(detached)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 func (ctx *SigningContext) createNamespacedElement(el *etree.Element, tag string) *etree.Element {
 	child := el.CreateElement(tag)
 	child.Space = ctx.Prefix
@@ -229,32 +600,45 @@ func (ctx *SigningContext) SignEnveloped(el *etree.Element) (*etree.Element, err
 	}
 
 	ret := el.Copy()
-	ret.Child = append(ret.Child, sig)
+	ret.AddChild(sig)
 
 	return ret, nil
 }
 
 // GetSignatureMethodIdentifier returns identifier string.
 func (ctx *SigningContext) GetSignatureMethodIdentifier() string {
-	if ident, ok := signatureMethodIdentifiers[ctx.Hash]; ok {
-		return ident
+	keyAlgorithm := x509.RSA
+	if ss, ok := ctx.KeyStore.(X509SignerStore); ok {
+		if key, _, err := ss.GetSigner(); err == nil {
+			keyAlgorithm = publicKeyAlgorithm(key.Public())
+		}
 	}
-	return ""
+	return ctx.signatureMethodIdentifier(keyAlgorithm)
 }
 
 // GetDigestAlgorithmIdentifier returns digest identifier.
 func (ctx *SigningContext) GetDigestAlgorithmIdentifier() string {
-	if ident, ok := digestAlgorithmIdentifiers[ctx.Hash]; ok {
-		return ident
+	if ctx.DigestMethod != "" {
+		return ctx.DigestMethod
 	}
-	return ""
+	return defaultDigestAlgorithmIdentifier(ctx.Hash)
 }
 
 // SignString is useful for signing query string (including DEFLATED AuthnRequest) when
 // using HTTP-Redirect to make a signed request.
 // See 3.4.4.1 DEFLATE Encoding of https://docs.oasis-open.org/security/saml/v2.0/saml-bindings-2.0-os.pdf
 func (ctx *SigningContext) SignString(content string) ([]byte, error) {
-	hash := ctx.Hash.New()
+	key, _, err := ctx.signer()
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch key for signing: %v", err)
+	}
+
+	signatureAlgorithm, err := ctx.signatureAlgorithm(key)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := signatureAlgorithm.Hash.New()
 	if ln, err := hash.Write([]byte(content)); err != nil {
 		return nil, fmt.Errorf("error calculating hash: %v", err)
 	} else if ln < 1 {
@@ -262,10 +646,8 @@ func (ctx *SigningContext) SignString(content string) ([]byte, error) {
 	}
 	digest := hash.Sum(nil)
 
-	var signature []byte
-	if key, _, err := ctx.KeyStore.GetKeyPair(); err != nil {
-		return nil, fmt.Errorf("unable to fetch key for signing: %v", err)
-	} else if signature, err = rsa.SignPKCS1v15(rand.Reader, key, ctx.Hash, digest); err != nil {
+	signature, err := signatureAlgorithm.Signer.Sign(key, signatureAlgorithm.Hash, digest)
+	if err != nil {
 		return nil, fmt.Errorf("error signing: %v", err)
 	}
 	return signature, nil
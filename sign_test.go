@@ -1,7 +1,9 @@
 package dsig
 
 import (
+	"bytes"
 	"crypto"
+	"crypto/x509"
 	"encoding/base64"
 	"testing"
 
@@ -135,3 +137,87 @@ func TestSignNonDefaultID(t *testing.T) {
 	refURI := ref.SelectAttrValue("URI", "")
 	require.Equal(t, refURI, "#"+id)
 }
+
+func TestSignIgnoresInjectedComments(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	for _, canonicalizer := range []Canonicalizer{
+		MakeC14N10ExclusiveCanonicalizerWithPrefixList(""),
+		MakeC14N10ExclusiveWithCommentsCanonicalizerWithPrefixList(""),
+		MakeC14N11Canonicalizer(),
+		MakeC14N11WithCommentsCanonicalizer(),
+		MakeC14N10RecCanonicalizer(),
+		MakeC14N10CommentCanonicalizer(),
+	} {
+		ctx := NewDefaultSigningContext(ks)
+		ctx.Canonicalizer = canonicalizer
+
+		doc := etree.NewDocument()
+		err := doc.ReadFromString(`<foo:Bar xmlns:foo="urn:foo"><foo:Baz>text<!-- signed --></foo:Baz></foo:Bar>`)
+		require.NoError(t, err)
+
+		signed, err := ctx.SignEnveloped(doc.Root())
+		require.NoError(t, err)
+
+		// Comments injected after signing are outside of the node-set of a
+		// same-document reference, and must not affect validation.
+		signed.FindElement("./Baz").CreateComment(" injected ")
+
+		vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+			Roots: []*x509.Certificate{cert},
+		})
+
+		_, err = vc.Validate(signed)
+		require.NoError(t, err, "canonicalizer: %s", canonicalizer.Algorithm())
+	}
+}
+
+func TestSignExclusivePrefixList(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+
+	for _, canonicalizer := range []Canonicalizer{
+		MakeC14N10ExclusiveCanonicalizerWithPrefixList("x"),
+		MakeC14N10ExclusiveWithCommentsCanonicalizerWithPrefixList("x"),
+	} {
+		ctx := NewDefaultSigningContext(ks)
+		ctx.Canonicalizer = canonicalizer
+
+		// The x namespace is in scope at the SignedInfo, but not visibly
+		// used by it, so the PrefixList changes its canonical form.
+		doc := etree.NewDocument()
+		require.NoError(t, doc.ReadFromString(`<r:Root xmlns:r="urn:r" xmlns:x="urn:x"><x:Item>1</x:Item></r:Root>`))
+
+		signed, err := ctx.SignEnveloped(doc.Root())
+		require.NoError(t, err)
+
+		for _, path := range []string{
+			"./ds:Signature/ds:SignedInfo/ds:CanonicalizationMethod/ec:InclusiveNamespaces",
+			"./ds:Signature/ds:SignedInfo/ds:Reference/ds:Transforms/ds:Transform[2]/ec:InclusiveNamespaces",
+		} {
+			inclusiveNamespaces := signed.FindElement(path)
+			require.NotNil(t, inclusiveNamespaces, path)
+			require.Equal(t, "x", inclusiveNamespaces.SelectAttrValue(PrefixListAttr, ""))
+		}
+
+		_, err = vc.Validate(signed)
+		require.NoError(t, err)
+
+		doc.SetRoot(signed)
+		serialized, err := doc.WriteToBytes()
+		require.NoError(t, err)
+
+		_, _, err = vc.ValidateBytes(serialized)
+		require.NoError(t, err)
+
+		_, err = vc.ValidateStream(bytes.NewReader(serialized))
+		require.NoError(t, err)
+	}
+}
@@ -0,0 +1,236 @@
+package dsig
+
+import (
+	"errors"
+
+	"github.com/beevik/etree"
+	"gitlab.com/moolekkari/goxmldsig/etreeutils"
+	"gitlab.com/moolekkari/goxmldsig/types"
+)
+
+// SignaturePropertiesType is the Type of a Reference to a
+// ds:SignatureProperties.
+const SignaturePropertiesType = "http://www.w3.org/2000/09/xmldsig#SignatureProperties"
+
+// SignatureProperty is a ds:SignatureProperty: signed metadata about a
+// signature, such as the device it was made on or its purpose.
+type SignatureProperty struct {
+	// ID is the Id of the SignatureProperty, if any.
+	ID string
+
+	// Content holds the elements of the property, which are in namespaces
+	// of the application.
+	Content []*etree.Element
+}
+
+// NewSignatureProperty returns a SignatureProperty holding a single element
+// in namespace, with the local name tag and the text value.
+func NewSignatureProperty(namespace, tag, value string) SignatureProperty {
+	el := etree.NewElement(tag)
+	el.CreateAttr("xmlns", namespace)
+	el.SetText(value)
+
+	return SignatureProperty{Content: []*etree.Element{el}}
+}
+
+// Value returns the text of the first content element of p in namespace
+// with the local name tag, and whether p has one.
+func (p *SignatureProperty) Value(namespace, tag string) (string, bool) {
+	for _, el := range p.Content {
+		if el.Tag != tag {
+			continue
+		}
+
+		nsCtx, err := etreeutils.NSBuildParentContext(el)
+		if err != nil {
+			continue
+		}
+
+		nsCtx, err = nsCtx.SubContext(el)
+		if err != nil {
+			continue
+		}
+
+		if ns, err := nsCtx.LookupPrefix(el.Space); err == nil && ns == namespace {
+			return el.Text(), true
+		}
+	}
+
+	return "", false
+}
+
+// SignaturePropertyValue returns the Value in namespace with the local name
+// tag of the first of the SignatureProperties of the result which has one,
+// and whether there is one.
+func (r *ValidationResult) SignaturePropertyValue(namespace, tag string) (string, bool) {
+	for _, property := range r.SignatureProperties {
+		if value, ok := property.Value(namespace, tag); ok {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+// signaturePropertiesObject returns a ds:Object holding a
+// ds:SignatureProperties with the context's SignatureProperties, which
+// target the Signature by sigID, its Id.
+func (ctx *SigningContext) signaturePropertiesObject(sigID string) signedObject {
+	object := &etree.Element{
+		Tag:   ObjectTag,
+		Space: ctx.Prefix,
+	}
+
+	properties := ctx.createNamespacedElement(object, SignaturePropertiesTag)
+	properties.CreateAttr(IDAttr, sigID+"-SignatureProperties")
+
+	for _, property := range ctx.SignatureProperties {
+		signatureProperty := ctx.createNamespacedElement(properties, SignaturePropertyTag)
+		if property.ID != "" {
+			signatureProperty.CreateAttr(IDAttr, property.ID)
+		}
+		signatureProperty.CreateAttr(TargetAttr, "#"+sigID)
+
+		for _, el := range property.Content {
+			signatureProperty.AddChild(el.Copy())
+		}
+	}
+
+	return signedObject{
+		object:        object,
+		target:        properties,
+		referenceType: SignaturePropertiesType,
+	}
+}
+
+// ValidateWithSignatureProperties verifies the signature of el as
+// ValidateData does, and returns the SignatureProperties it signs in the
+// result. They must all target the signature.
+func (ctx *ValidationContext) ValidateWithSignatureProperties(el *etree.Element) (*ValidationResult, error) {
+	el, sig, store, err := ctx.findValidationSignature(el)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := ctx.verifyCertificate(sig, store)
+	if err != nil {
+		return nil, err
+	}
+
+	transformed, err := ctx.validateSignature(el, sig, cert)
+	if err != nil {
+		return nil, err
+	}
+
+	properties, err := ctx.verifySignatureProperties(el, sig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ValidationResult{
+		Content:             transformed,
+		Certificate:         cert,
+		SignatureProperties: properties,
+	}, nil
+}
+
+// verifySignatureProperties returns the SignatureProperty elements of the
+// SignatureProperties within el which the SignedInfo of sig references,
+// after sig has been verified.
+func (ctx *ValidationContext) verifySignatureProperties(el *etree.Element, sig *types.Signature) ([]*SignatureProperty, error) {
+	sigID := sig.UnderlyingElement().SelectAttrValue(IDAttr, "")
+
+	var properties []*SignatureProperty
+
+	// The first Reference is to the signed document, whatever its Type.
+	references := sig.SignedInfo.References
+	for i := 1; i < len(references); i++ {
+		if references[i].Type != SignaturePropertiesType {
+			continue
+		}
+
+		target, err := dereference(el, references[i].URI)
+		if err != nil {
+			return nil, err
+		}
+
+		parsed, err := parseSignatureProperties(target, sigID)
+		if err != nil {
+			return nil, err
+		}
+
+		properties = append(properties, parsed...)
+	}
+
+	return properties, nil
+}
+
+// parseSignatureProperties returns the SignatureProperty children of el,
+// which must be a ds:SignatureProperties, with their content detached from
+// the document. They must all target the Signature with the Id sigID, so
+// that properties signed for one signature can not be passed off as those
+// of another.
+func parseSignatureProperties(el *etree.Element, sigID string) ([]*SignatureProperty, error) {
+	nsCtx, err := etreeutils.NSBuildParentContext(el)
+	if err != nil {
+		return nil, err
+	}
+
+	elCtx, err := nsCtx.SubContext(el)
+	if err != nil {
+		return nil, err
+	}
+
+	namespace, err := elCtx.LookupPrefix(el.Space)
+	if err != nil {
+		return nil, err
+	}
+
+	if el.Tag != SignaturePropertiesTag || namespace != Namespace {
+		return nil, errors.New("SignatureProperties Reference does not refer to SignatureProperties")
+	}
+
+	parsed := &types.SignatureProperties{}
+	err = etreeutils.NSUnmarshalElement(nsCtx, el, parsed)
+	if err != nil {
+		return nil, err
+	}
+
+	var properties []*SignatureProperty
+
+	index := 0
+	err = etreeutils.NSFindChildrenIterateCtx(elCtx, el, Namespace, SignaturePropertyTag,
+		func(ctx etreeutils.NSContext, signatureProperty *etree.Element) error {
+			if index >= len(parsed.SignatureProperties) {
+				return etreeutils.ErrTraversalHalted
+			}
+			p := &parsed.SignatureProperties[index]
+			index++
+
+			if sigID == "" || p.Target != "#"+sigID {
+				return errors.New("SignatureProperty does not target the Signature")
+			}
+
+			property := &SignatureProperty{ID: p.ID}
+			err := etreeutils.NSIterateChildren(ctx, signatureProperty, func(ctx etreeutils.NSContext, content *etree.Element) error {
+				detached, err := etreeutils.NSDetatch(ctx, content)
+				if err != nil {
+					return err
+				}
+
+				property.Content = append(property.Content, detached)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+
+			properties = append(properties, property)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return properties, nil
+}
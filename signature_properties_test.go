@@ -0,0 +1,142 @@
+package dsig
+
+import (
+	"crypto/x509"
+	"testing"
+
+	"github.com/beevik/etree"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/moolekkari/goxmldsig/etreeutils"
+	"gitlab.com/moolekkari/goxmldsig/types"
+)
+
+const testWorkflowNamespace = "urn:workflow"
+
+func TestValidateWithSignatureProperties(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	step := NewSignatureProperty(testWorkflowNamespace, "Step", "approval")
+	step.ID = "step"
+
+	ctx := NewDefaultSigningContext(ks)
+	ctx.SignatureProperties = []SignatureProperty{
+		NewSignatureProperty(testWorkflowNamespace, "DeviceID", "device-42"),
+		NewSignatureProperty(testWorkflowNamespace, "Purpose", "invoice approval"),
+		step,
+	}
+
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(`<Invoice xmlns="urn:invoice"><Total>100</Total></Invoice>`))
+
+	signed, err := ctx.SignEnveloped(doc.Root())
+	require.NoError(t, err)
+
+	sig := signed.FindElement("./ds:Signature")
+	sigID := sig.SelectAttrValue(IDAttr, "")
+	require.NotEmpty(t, sigID)
+
+	signatureProperties := sig.FindElement("./ds:Object/ds:SignatureProperties")
+	require.NotNil(t, signatureProperties)
+	require.Len(t, signatureProperties.SelectElements(SignaturePropertyTag), 3)
+	require.Equal(t, "#"+sigID, signatureProperties.SelectElement(SignaturePropertyTag).SelectAttrValue(TargetAttr, ""))
+
+	reference := sig.FindElement("./ds:SignedInfo/ds:Reference[2]")
+	require.Equal(t, SignaturePropertiesType, reference.SelectAttrValue(TypeAttr, ""))
+	require.Equal(t, "#"+signatureProperties.SelectAttrValue(IDAttr, ""), reference.SelectAttrValue(URIAttr, ""))
+
+	// The properties survive serialization.
+	doc = etree.NewDocument()
+	doc.SetRoot(signed.Copy())
+
+	serialized, err := doc.WriteToString()
+	require.NoError(t, err)
+
+	doc = etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(serialized))
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+
+	result, err := vc.ValidateWithSignatureProperties(doc.Root())
+	require.NoError(t, err)
+	require.True(t, cert.Equal(result.Certificate))
+	require.Len(t, result.SignatureProperties, 3)
+	require.Equal(t, "step", result.SignatureProperties[2].ID)
+
+	deviceID, ok := result.SignaturePropertyValue(testWorkflowNamespace, "DeviceID")
+	require.True(t, ok)
+	require.Equal(t, "device-42", deviceID)
+
+	purpose, ok := result.SignaturePropertyValue(testWorkflowNamespace, "Purpose")
+	require.True(t, ok)
+	require.Equal(t, "invoice approval", purpose)
+
+	value, ok := result.SignatureProperties[2].Value(testWorkflowNamespace, "Step")
+	require.True(t, ok)
+	require.Equal(t, "approval", value)
+
+	_, ok = result.SignaturePropertyValue("urn:other", "Step")
+	require.False(t, ok)
+
+	// The properties are signed.
+	tampered := signed.Copy()
+	tampered.FindElement("./ds:Signature/ds:Object/ds:SignatureProperties/ds:SignatureProperty/DeviceID").SetText("device-43")
+
+	_, err = vc.ValidateWithSignatureProperties(tampered)
+	require.EqualError(t, err, "Signature could not be verified")
+
+	// And only taken as those of the Signature they target.
+	_, err = parseSignatureProperties(signatureProperties, "other")
+	require.EqualError(t, err, "SignatureProperty does not target the Signature")
+}
+
+func TestXAdESSignatureProperties(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	ctx := NewXAdESSigningContext(NewDefaultSigningContext(ks))
+	ctx.SignatureProperties = []SignatureProperty{
+		NewSignatureProperty(testWorkflowNamespace, "DeviceID", "device-42"),
+	}
+
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(`<Invoice xmlns="urn:invoice"><Total>100</Total></Invoice>`))
+
+	signed, err := ctx.SignEnveloped(doc.Root())
+	require.NoError(t, err)
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+
+	result, err := vc.ValidateXAdES(signed)
+	require.NoError(t, err)
+	require.NotNil(t, result.XAdES)
+
+	deviceID, ok := result.SignaturePropertyValue(testWorkflowNamespace, "DeviceID")
+	require.True(t, ok)
+	require.Equal(t, "device-42", deviceID)
+}
+
+func TestUnmarshalSignatureProperties(t *testing.T) {
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(`<ds:SignatureProperties xmlns:ds="http://www.w3.org/2000/09/xmldsig#" Id="props"><ds:SignatureProperty Id="device" Target="#sig"><w:DeviceID xmlns:w="urn:workflow">device-42</w:DeviceID></ds:SignatureProperty></ds:SignatureProperties>`))
+
+	properties := &types.SignatureProperties{}
+	require.NoError(t, etreeutils.NSUnmarshalElement(etreeutils.DefaultNSContext, doc.Root(), properties))
+
+	require.Equal(t, "props", properties.ID)
+	require.Len(t, properties.SignatureProperties, 1)
+
+	property := properties.SignatureProperties[0]
+	require.Equal(t, "device", property.ID)
+	require.Equal(t, "#sig", property.Target)
+	require.Len(t, property.Content, 1)
+	require.Equal(t, testWorkflowNamespace, property.Content[0].XMLName.Space)
+	require.Equal(t, "DeviceID", property.Content[0].XMLName.Local)
+	require.Equal(t, "device-42", property.Content[0].Value)
+}
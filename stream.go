@@ -0,0 +1,520 @@
+package dsig
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/beevik/etree"
+	"gitlab.com/moolekkari/goxmldsig/etreeutils"
+	"gitlab.com/moolekkari/goxmldsig/types"
+)
+
+// ErrStreamingNotSupported indicates that a signature does not follow the
+// profile supported by ValidateStream, and must be validated with Validate
+// instead.
+var ErrStreamingNotSupported = errors.New("Signature cannot be validated as a stream")
+
+// ValidateStream verifies the enveloped signature of the document read from
+// r, as Validate does for a parsed document, without holding the document in
+// memory. It returns the certificate which the signature was verified
+// against.
+//
+// The document is read twice: once to find and buffer the Signature
+// element, and once to digest the rest of the document as it is read, so r
+// must not change between the two. Only a first Reference to the whole
+// document, with an enveloped signature transform and at most a
+// canonicalization transform, is supported, and further References must
+// refer to content of the Signature; other signatures fail with
+// ErrStreamingNotSupported.
+func (ctx *ValidationContext) ValidateStream(r io.ReadSeeker) (*x509.Certificate, error) {
+	// The resolver would need the whole document.
+	if ctx.CertificateStoreResolver != nil {
+		return nil, ErrStreamingNotSupported
+	}
+
+	limits := ctx.limits()
+
+	skeleton, sigElement, ordinal, err := scanForSignature(r, limits)
+	if err != nil {
+		return nil, err
+	}
+
+	if skeleton == nil {
+		return nil, ErrMissingSignature
+	}
+
+	sig, err := ctx.findSignature(skeleton)
+	if err != nil {
+		return nil, err
+	}
+
+	// A Signature other than the buffered one, such as a counter-signature,
+	// is not the one the document is digested without.
+	if sig.UnderlyingElement() != sigElement {
+		return nil, ErrStreamingNotSupported
+	}
+
+	err = limits.checkSignature(sig)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := ctx.verifyCertificate(sig, ctx.CertificateStore)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sig.SignedInfo.References) == 0 {
+		return nil, errors.New("Missing Reference")
+	}
+	ref := &sig.SignedInfo.References[0]
+
+	canonicalizer, err := streamCanonicalizer(sig, ref)
+	if err != nil {
+		return nil, err
+	}
+
+	digestAlgorithm, err := ctx.AlgorithmPolicy.digestAlgorithm(ref.DigestAlgo.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	hash := digestAlgorithm.Hash.New()
+	err = digestStream(r, limits.limitCanonical(hash), canonicalizer, ordinal)
+	if err != nil {
+		return nil, err
+	}
+
+	decodedDigestValue, err := decodeBase64Binary(ref.DigestValue)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(hash.Sum(nil), decodedDigestValue) {
+		return nil, errors.New("Signature could not be verified")
+	}
+
+	// Further References can only be verified if they refer to content of
+	// the buffered Signature, such as its ds:Objects.
+	for i := 1; i < len(sig.SignedInfo.References); i++ {
+		ref := &sig.SignedInfo.References[i]
+
+		if !uriRegexp.MatchString(ref.URI) {
+			return nil, ErrStreamingNotSupported
+		}
+
+		target, err := findElementByID(sigElement, ref.URI[1:])
+		if err != nil {
+			return nil, err
+		}
+
+		if target == nil {
+			return nil, ErrStreamingNotSupported
+		}
+
+		_, err = ctx.verifyReference(target, sig, ref)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	decodedSignature, err := decodeBase64Binary(sig.SignatureValue.Data)
+	if err != nil {
+		return nil, errors.New("Could not decode signature")
+	}
+
+	err = ctx.verifySignedInfo(sig, sig.SignedInfo.SignatureMethod.Algorithm, cert, decodedSignature)
+	if err != nil {
+		return nil, err
+	}
+
+	return cert, nil
+}
+
+// streamCanonicalizer checks that ref can be digested as a stream, and
+// returns the canonicalizer to digest it with.
+func streamCanonicalizer(sig *types.Signature, ref *types.Reference) (Canonicalizer, error) {
+	if !isSameDocumentReference(ref.URI) {
+		return nil, ErrStreamingNotSupported
+	}
+
+	enveloped := false
+	var canonicalizer Canonicalizer
+
+	for i := range ref.Transforms.Transforms {
+		transform := &ref.Transforms.Transforms[i]
+		algo := AlgorithmID(transform.Algorithm)
+
+		if algo == EnvelopedSignatureAltorithmID {
+			enveloped = true
+			continue
+		}
+
+		factory, ok := lookupCanonicalizer(algo)
+		if !ok || canonicalizer != nil {
+			return nil, ErrStreamingNotSupported
+		}
+
+		c, err := factory(transform)
+		if err != nil {
+			return nil, err
+		}
+		canonicalizer = c
+	}
+
+	if !enveloped {
+		return nil, ErrStreamingNotSupported
+	}
+
+	if canonicalizer == nil {
+		c, err := NewCanonicalizer(canonicalizationMethodTransform(&sig.SignedInfo.CanonicalizationMethod))
+		if err != nil {
+			return nil, errors.New("Expected canonicalization transform")
+		}
+		canonicalizer = c
+	}
+
+	return canonicalizer, nil
+}
+
+// newStreamDecoder returns a decoder which reads tokens as etree does,
+// converting documents in other encodings to UTF-8.
+func newStreamDecoder(r io.Reader) *xml.Decoder {
+	dec := xml.NewDecoder(r)
+	dec.CharsetReader = charsetReader
+	return dec
+}
+
+func bareElement(t xml.StartElement) *etree.Element {
+	el := &etree.Element{
+		Space: t.Name.Space,
+		Tag:   t.Name.Local,
+		Attr:  make([]etree.Attr, 0, len(t.Attr)),
+	}
+	for _, a := range t.Attr {
+		el.Attr = append(el.Attr, etree.Attr{Space: a.Name.Space, Key: a.Name.Local, Value: a.Value})
+	}
+	return el
+}
+
+// scanForSignature reads the document from r and returns the last ds:Signature
+// element within its root element, attached to copies of its ancestors which
+// carry their attributes but no other content. ordinal is the position of
+// the Signature's start tag among all start tags of the document. The
+// document is checked against limits as it is read.
+func scanForSignature(r io.Reader, limits *ValidationLimits) (skeleton, sig *etree.Element, ordinal int, err error) {
+	dec := newStreamDecoder(r)
+
+	var (
+		path     []*etree.Element
+		contexts = []etreeutils.NSContext{etreeutils.DefaultNSContext}
+
+		// capture is the innermost element of the Signature being
+		// buffered, if any.
+		capture *etree.Element
+
+		started int
+	)
+
+	for {
+		t, err := dec.RawToken()
+		if err == io.EOF {
+			if len(path) != 0 {
+				return nil, nil, 0, io.ErrUnexpectedEOF
+			}
+			return skeleton, sig, ordinal, nil
+		}
+		if err != nil {
+			return nil, nil, 0, err
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			if started > 0 && len(path) == 0 {
+				// Content after the root element is not part of it.
+				return skeleton, sig, ordinal, nil
+			}
+			started++
+
+			if err := limits.checkElement(len(path)+1, started); err != nil {
+				return nil, nil, 0, err
+			}
+
+			el := bareElement(t)
+
+			if capture != nil {
+				capture.AddChild(el)
+				capture = el
+				path = append(path, el)
+				continue
+			}
+
+			nsCtx, err := contexts[len(contexts)-1].SubContext(el)
+			if err != nil {
+				return nil, nil, 0, err
+			}
+
+			namespace, err := nsCtx.LookupPrefix(el.Space)
+			if err != nil {
+				return nil, nil, 0, err
+			}
+
+			if namespace == Namespace && el.Tag == SignatureTag && len(path) > 0 {
+				skeleton, capture = copyPath(path)
+				capture.AddChild(el)
+				capture = el
+				sig = el
+				ordinal = started
+			}
+
+			path = append(path, el)
+			contexts = append(contexts, nsCtx)
+
+		case xml.EndElement:
+			if len(path) == 0 || path[len(path)-1].FullTag() != fullName(t.Name) {
+				return nil, nil, 0, fmt.Errorf("unexpected end element </%s>", fullName(t.Name))
+			}
+			path = path[:len(path)-1]
+
+			if capture != nil {
+				if capture == sig {
+					capture = nil
+					contexts = contexts[:len(contexts)-1]
+				} else {
+					capture = capture.Parent()
+				}
+				continue
+			}
+			contexts = contexts[:len(contexts)-1]
+
+		case xml.CharData:
+			if capture != nil {
+				capture.CreateText(string(t))
+			}
+
+		case xml.Comment:
+			if capture != nil {
+				capture.CreateComment(string(t))
+			}
+
+		case xml.ProcInst:
+			if capture != nil {
+				capture.CreateProcInst(t.Target, string(t.Inst))
+			}
+
+		case xml.Directive:
+			if !limits.AllowDTD {
+				return nil, nil, 0, ErrDTDNotAllowed
+			}
+			if capture != nil {
+				capture.CreateDirective(string(t))
+			}
+		}
+	}
+}
+
+// copyPath copies the elements of path, without their content, into a new
+// tree, and returns its root and innermost element.
+func copyPath(path []*etree.Element) (root, leaf *etree.Element) {
+	for _, el := range path {
+		copied := &etree.Element{
+			Space: el.Space,
+			Tag:   el.Tag,
+			Attr:  append([]etree.Attr(nil), el.Attr...),
+		}
+
+		if leaf == nil {
+			root = copied
+		} else {
+			leaf.AddChild(copied)
+		}
+		leaf = copied
+	}
+
+	return root, leaf
+}
+
+func fullName(name xml.Name) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return name.Space + ":" + name.Local
+}
+
+// digestStream writes the canonical form of the root element of the
+// document read from r to w, leaving out comments and the element whose start
+// tag is at position skip.
+func digestStream(r io.Reader, w io.Writer, c Canonicalizer, skip int) error {
+	tc, err := newTokenCanonicalizer(w, c)
+	if err != nil {
+		return err
+	}
+
+	dec := newStreamDecoder(r)
+
+	var (
+		started int
+		depth   int
+
+		// skipping is the depth of the element being left out, if any.
+		skipping int
+	)
+
+	for {
+		t, err := dec.RawToken()
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			started++
+			depth++
+
+			if skipping > 0 {
+				continue
+			}
+
+			if started == skip {
+				skipping = depth
+				continue
+			}
+
+			if err := tc.startElement(bareElement(t)); err != nil {
+				return err
+			}
+
+		case xml.EndElement:
+			if depth == 0 {
+				return fmt.Errorf("unexpected end element </%s>", fullName(t.Name))
+			}
+			depth--
+
+			if skipping > 0 {
+				if depth < skipping {
+					skipping = 0
+				}
+				continue
+			}
+
+			if err := tc.endElement(fullName(t.Name)); err != nil {
+				return err
+			}
+
+			if depth == 0 {
+				return tc.cw.w.Flush()
+			}
+
+		case xml.CharData:
+			if depth > 0 && skipping == 0 {
+				tc.cw.writeEscaped(string(t), false)
+			}
+
+		case xml.ProcInst:
+			if depth > 0 && skipping == 0 {
+				tc.cw.writeToken(etree.NewProcInst(t.Target, string(t.Inst)))
+			}
+
+		case xml.Directive:
+			if depth > 0 && skipping == 0 {
+				tc.cw.writeToken(etree.NewDirective(string(t)))
+			}
+		}
+	}
+}
+
+// tokenCanonicalizer writes the canonical form of an element read as a
+// stream of tokens, holding only the elements which are currently open.
+type tokenCanonicalizer struct {
+	cw *canonicalWriter
+
+	// exclusive is set for exclusive canonicalization.
+	exclusive *etreeutils.ExcC14nRenderer
+
+	// seen holds the namespace declarations written so far, for inclusive
+	// canonicalization.
+	seen map[string]struct{}
+
+	open []openElement
+}
+
+type openElement struct {
+	el *etree.Element
+
+	// scope and declared are the contexts for the element's children, for
+	// exclusive canonicalization.
+	scope, declared etreeutils.NSContext
+
+	// seen holds the prefixes the element added to seen, for inclusive
+	// canonicalization.
+	seen []string
+}
+
+func newTokenCanonicalizer(w io.Writer, c Canonicalizer) (*tokenCanonicalizer, error) {
+	tc := &tokenCanonicalizer{
+		cw: newCanonicalWriter(w, false),
+	}
+
+	switch c := c.(type) {
+	case *c14N10ExclusiveCanonicalizer:
+		tc.exclusive = etreeutils.NewExcC14nRenderer(c.prefixList)
+	case *c14N11Canonicalizer, *c14N10RecCanonicalizer, *c14N10CommentCanonicalizer:
+		tc.seen = map[string]struct{}{}
+	default:
+		return nil, ErrStreamingNotSupported
+	}
+
+	return tc, nil
+}
+
+func (tc *tokenCanonicalizer) startElement(el *etree.Element) error {
+	open := openElement{el: el}
+
+	if tc.exclusive != nil {
+		ctx, declared := etreeutils.DefaultNSContext, etreeutils.DefaultNSContext
+		if len(tc.open) > 0 {
+			parent := tc.open[len(tc.open)-1]
+			ctx, declared = parent.scope, parent.declared
+		}
+
+		var err error
+		open.scope, open.declared, err = tc.cw.startExclusive(tc.exclusive, ctx, declared, el)
+		if err != nil {
+			return err
+		}
+	} else {
+		open.seen = tc.cw.startInclusive(el, tc.seen, nil)
+	}
+
+	tc.open = append(tc.open, open)
+
+	return nil
+}
+
+func (tc *tokenCanonicalizer) endElement(name string) error {
+	if len(tc.open) == 0 || tc.open[len(tc.open)-1].el.FullTag() != name {
+		return fmt.Errorf("unexpected end element </%s>", name)
+	}
+
+	open := tc.open[len(tc.open)-1]
+	tc.open = tc.open[:len(tc.open)-1]
+
+	tc.cw.writeEndTag(open.el)
+
+	for _, prefix := range open.seen {
+		delete(tc.seen, prefix)
+	}
+
+	return nil
+}
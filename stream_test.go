@@ -0,0 +1,149 @@
+package dsig
+
+import (
+	"bytes"
+	"crypto/x509"
+	"strings"
+	"testing"
+
+	"github.com/beevik/etree"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/moolekkari/goxmldsig/types"
+)
+
+const streamTestDocument = `<?xml version="1.0" encoding="UTF-8"?>
+<!-- leading comment -->
+<b:Batch xmlns:b="urn:batch" xmlns:k="urn:kyc" xmlns:unused="urn:unused" Version="1">
+  <k:Record ID="r1" k:type="a&amp;b"><k:Name>First &lt;one&gt;</k:Name><!-- inner comment --></k:Record>
+  <k:Record ID="r2" xmlns:k="urn:kyc:v2"><?pi data?><k:Name>Second</k:Name></k:Record>
+  <Plain xmlns="urn:default"><Child attr="tab&#9;newline&#10;"/></Plain>
+</b:Batch>`
+
+func TestValidateStream(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+
+	for _, canonicalizer := range []Canonicalizer{
+		MakeC14N10ExclusiveCanonicalizerWithPrefixList(""),
+		MakeC14N11Canonicalizer(),
+		MakeC14N10RecCanonicalizer(),
+	} {
+		ctx := NewDefaultSigningContext(ks)
+		ctx.Canonicalizer = canonicalizer
+		ctx.Prefix = "ds"
+
+		serialized := serializeForTest(t, signDocumentForTest(t, ctx, streamTestDocument))
+
+		streamCert, err := vc.ValidateStream(bytes.NewReader(serialized))
+		require.NoError(t, err, canonicalizer.Algorithm())
+		require.Equal(t, cert, streamCert)
+
+		// Any change to the content outside the Signature is detected.
+		tampered := bytes.Replace(serialized, []byte("Second"), []byte("Secund"), 1)
+		_, err = vc.ValidateStream(bytes.NewReader(tampered))
+		require.Error(t, err, canonicalizer.Algorithm())
+
+		// Comments are not part of the signed content.
+		commented := bytes.Replace(serialized, []byte("inner comment"), []byte("changed comment"), 1)
+		_, err = vc.ValidateStream(bytes.NewReader(commented))
+		require.NoError(t, err, canonicalizer.Algorithm())
+	}
+}
+
+func TestValidateStreamLargeDocument(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	ctx := NewDefaultSigningContext(ks)
+	signed, err := ctx.SignEnveloped(largeDocument(2000))
+	require.NoError(t, err)
+
+	doc := etree.NewDocument()
+	doc.SetRoot(signed)
+	serialized := serializeForTest(t, doc)
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+
+	_, err = vc.ValidateStream(bytes.NewReader(serialized))
+	require.NoError(t, err)
+}
+
+func TestValidateStreamUnsupported(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+
+	_, err = vc.ValidateStream(strings.NewReader(`<Root><Child/></Root>`))
+	require.Equal(t, ErrMissingSignature, err)
+
+	_, err = vc.ValidateStream(strings.NewReader(`<Root><Child></Root>`))
+	require.Error(t, err)
+
+	ctx := NewDefaultSigningContext(ks)
+	ctx.Transforms = []types.Transform{NewXPathTransform("not(self::Volatile)", nil)}
+
+	serialized := serializeForTest(t, signDocumentForTest(t, ctx, `<Root><Volatile/></Root>`))
+
+	_, err = vc.ValidateStream(bytes.NewReader(serialized))
+	require.Equal(t, ErrStreamingNotSupported, err)
+}
+
+func BenchmarkValidate(b *testing.B) {
+	serialized, vc := benchmarkSignedDocument(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		doc := etree.NewDocument()
+		if err := doc.ReadFromBytes(serialized); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := vc.Validate(doc.Root()); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkValidateStream(b *testing.B) {
+	serialized, vc := benchmarkSignedDocument(b)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := vc.ValidateStream(bytes.NewReader(serialized)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func benchmarkSignedDocument(b *testing.B) ([]byte, *ValidationContext) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	signed, err := NewDefaultSigningContext(ks).SignEnveloped(largeDocument(5000))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	doc := etree.NewDocument()
+	doc.SetRoot(signed)
+
+	return serializeForTest(b, doc), NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+}
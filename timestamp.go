@@ -0,0 +1,505 @@
+package dsig
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"time"
+)
+
+// TimestampAuthority issues RFC 3161 timestamp tokens.
+type TimestampAuthority interface {
+	// Timestamp returns a DER encoded TimeStampToken whose message imprint
+	// is digest, the digest of the timestamped data made with hash.
+	Timestamp(hash crypto.Hash, digest []byte) ([]byte, error)
+}
+
+// Timestamp is a verified RFC 3161 timestamp token.
+type Timestamp struct {
+	// Time is the time at which the TSA asserts the timestamped data
+	// existed.
+	Time time.Time
+
+	// SerialNumber is the serial number the TSA gave the token, and Policy
+	// the TSA policy it was issued under.
+	SerialNumber *big.Int
+	Policy       asn1.ObjectIdentifier
+
+	// Certificate is the TSA certificate the token was signed with, and
+	// Chain its chain to a trusted root.
+	Certificate *x509.Certificate
+	Chain       []*x509.Certificate
+}
+
+// ErrTimestampRejected indicates that a TSA did not grant a timestamp.
+type ErrTimestampRejected struct {
+	// Status is the PKIStatus of the TSA's response, and StatusString its
+	// explanation, if any.
+	Status       int
+	StatusString string
+}
+
+func (e ErrTimestampRejected) Error() string {
+	msg := fmt.Sprintf("Timestamp rejected with status %d", e.Status)
+	if e.StatusString != "" {
+		msg += ": " + e.StatusString
+	}
+	return msg
+}
+
+var (
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTSTInfo       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+)
+
+// hashOIDs identifies the hashes timestamp tokens can be made with.
+var hashOIDs = []struct {
+	oid  asn1.ObjectIdentifier
+	hash crypto.Hash
+}{
+	{asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}, crypto.SHA1},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 4}, crypto.SHA224},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}, crypto.SHA256},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}, crypto.SHA384},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}, crypto.SHA512},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 8}, crypto.SHA3_256},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 9}, crypto.SHA3_384},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 10}, crypto.SHA3_512},
+}
+
+func hashOID(hash crypto.Hash) (asn1.ObjectIdentifier, error) {
+	for _, h := range hashOIDs {
+		if h.hash == hash {
+			return h.oid, nil
+		}
+	}
+	return nil, fmt.Errorf("Unsupported timestamp hash: %v", hash)
+}
+
+func hashFromOID(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	for _, h := range hashOIDs {
+		if h.oid.Equal(oid) {
+			return h.hash, nil
+		}
+	}
+	return 0, fmt.Errorf("Unsupported timestamp hash algorithm: %v", oid)
+}
+
+// The ASN.1 structures of RFC 3161 and of CMS (RFC 5652).
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	ReqPolicy      asn1.ObjectIdentifier `asn1:"optional"`
+	Nonce          *big.Int              `asn1:"optional"`
+	CertReq        bool                  `asn1:"optional"`
+	Extensions     []pkix.Extension      `asn1:"optional,tag:0"`
+}
+
+type pkiStatusInfo struct {
+	Status       int
+	StatusString []string       `asn1:"optional"`
+	FailInfo     asn1.BitString `asn1:"optional"`
+}
+
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type accuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time        `asn1:"generalized"`
+	Accuracy       accuracy         `asn1:"optional"`
+	Ordering       bool             `asn1:"optional"`
+	Nonce          *big.Int         `asn1:"optional"`
+	TSA            asn1.RawValue    `asn1:"optional,tag:0"`
+	Extensions     []pkix.Extension `asn1:"optional,tag:1"`
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type encapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     asn1.RawValue `asn1:"optional,explicit,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapsulatedContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type signerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+// timestampToken is a parsed, but not yet verified, TimeStampToken.
+type timestampToken struct {
+	info tstInfo
+
+	// content is the DER encoded TSTInfo the signer signed.
+	content []byte
+
+	signer       signerInfo
+	certificates []*x509.Certificate
+}
+
+func parseTimestampToken(der []byte) (*timestampToken, error) {
+	var ci contentInfo
+	if rest, err := asn1.Unmarshal(der, &ci); err != nil || len(rest) != 0 {
+		return nil, errors.New("Invalid timestamp token")
+	}
+
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, errors.New("Timestamp token is not CMS SignedData")
+	}
+
+	// encoding/asn1 keeps the explicit [0] tags of RawValues.
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, errors.New("Invalid timestamp token SignedData")
+	}
+
+	if !sd.EncapContentInfo.EContentType.Equal(oidTSTInfo) {
+		return nil, errors.New("Timestamp token does not hold a TSTInfo")
+	}
+
+	if len(sd.SignerInfos) != 1 {
+		return nil, errors.New("Timestamp token must have a single signer")
+	}
+
+	token := &timestampToken{signer: sd.SignerInfos[0]}
+
+	if _, err := asn1.Unmarshal(sd.EncapContentInfo.EContent.Bytes, &token.content); err != nil {
+		return nil, errors.New("Invalid timestamp token content")
+	}
+
+	if rest, err := asn1.Unmarshal(token.content, &token.info); err != nil || len(rest) != 0 {
+		return nil, errors.New("Invalid TSTInfo")
+	}
+
+	if len(sd.Certificates.Bytes) != 0 {
+		certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		token.certificates = certs
+	}
+
+	return token, nil
+}
+
+// signedAttributes returns the signed attributes of the token's signer,
+// by type.
+func (token *timestampToken) signedAttributes() (map[string]asn1.RawValue, error) {
+	attrs := map[string]asn1.RawValue{}
+
+	for rest := token.signer.SignedAttrs.Bytes; len(rest) > 0; {
+		var attr attribute
+		var err error
+		rest, err = asn1.Unmarshal(rest, &attr)
+		if err != nil {
+			return nil, errors.New("Invalid timestamp token signed attributes")
+		}
+
+		// Each attribute of a signer must have a single value.
+		var value asn1.RawValue
+		if extra, err := asn1.Unmarshal(attr.Values.Bytes, &value); err != nil || len(extra) != 0 {
+			return nil, errors.New("Invalid timestamp token signed attribute")
+		}
+
+		key := attr.Type.String()
+		if _, ok := attrs[key]; ok {
+			return nil, errors.New("Duplicate timestamp token signed attribute")
+		}
+		attrs[key] = value
+	}
+
+	return attrs, nil
+}
+
+// signerCertificate returns the certificate among certs which identifies
+// the token's signer.
+func (token *timestampToken) signerCertificate(certs []*x509.Certificate) (*x509.Certificate, error) {
+	sid := token.signer.SID
+
+	for _, cert := range certs {
+		switch {
+		case sid.Class == asn1.ClassUniversal && sid.Tag == asn1.TagSequence:
+			var ias issuerAndSerialNumber
+			if _, err := asn1.Unmarshal(sid.FullBytes, &ias); err != nil {
+				return nil, errors.New("Invalid timestamp token signer identifier")
+			}
+
+			if bytes.Equal(ias.Issuer.FullBytes, cert.RawIssuer) && ias.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+				return cert, nil
+			}
+
+		case sid.Class == asn1.ClassContextSpecific && sid.Tag == 0:
+			if len(cert.SubjectKeyId) != 0 && bytes.Equal(sid.Bytes, cert.SubjectKeyId) {
+				return cert, nil
+			}
+		}
+	}
+
+	return nil, errors.New("Missing timestamp token signer certificate")
+}
+
+// verifySignature checks the CMS signature of the token, made by cert.
+func (token *timestampToken) verifySignature(cert *x509.Certificate, policy *AlgorithmPolicy) error {
+	if len(token.signer.SignedAttrs.Bytes) == 0 {
+		return errors.New("Missing timestamp token signed attributes")
+	}
+
+	hash, err := hashFromOID(token.signer.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := policy.digestAlgorithm(defaultDigestAlgorithmIdentifier(hash)); err != nil {
+		return err
+	}
+
+	attrs, err := token.signedAttributes()
+	if err != nil {
+		return err
+	}
+
+	var contentType asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(attrs[oidContentType.String()].FullBytes, &contentType); err != nil || !contentType.Equal(oidTSTInfo) {
+		return errors.New("Invalid timestamp token content type attribute")
+	}
+
+	var messageDigest []byte
+	if _, err := asn1.Unmarshal(attrs[oidMessageDigest.String()].FullBytes, &messageDigest); err != nil {
+		return errors.New("Invalid timestamp token message digest attribute")
+	}
+
+	h := hash.New()
+	h.Write(token.content)
+	if !bytes.Equal(h.Sum(nil), messageDigest) {
+		return errors.New("Timestamp token content does not match its message digest")
+	}
+
+	// The signature is over the DER encoding of the signed attributes as a
+	// SET OF, rather than with their IMPLICIT [0] tag.
+	signed := append([]byte{}, token.signer.SignedAttrs.FullBytes...)
+	signed[0] = asn1.TagSet | 0x20
+
+	h = hash.New()
+	h.Write(signed)
+	hashed := h.Sum(nil)
+
+	switch pub := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(pub, hash, hashed, token.signer.Signature)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, hashed, token.signer.Signature) {
+			err = errors.New("ECDSA verification failure")
+		}
+	default:
+		err = errors.New("Unsupported timestamp token signer key")
+	}
+
+	return err
+}
+
+// verifyTimestampToken verifies the TimeStampToken der over data. The token
+// must be signed by a TSA certificate which is valid at the time the token
+// asserts, is permitted to issue timestamps, and chains to one of roots,
+// through the certificates of the token or intermediates.
+func verifyTimestampToken(der, data []byte, roots, intermediates []*x509.Certificate, policy *AlgorithmPolicy) (*Timestamp, error) {
+	token, err := parseTimestampToken(der)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := hashFromOID(token.info.MessageImprint.HashAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := policy.digestAlgorithm(defaultDigestAlgorithmIdentifier(hash)); err != nil {
+		return nil, err
+	}
+
+	h := hash.New()
+	h.Write(data)
+	if !bytes.Equal(h.Sum(nil), token.info.MessageImprint.HashedMessage) {
+		return nil, errors.New("Timestamp token does not match the timestamped data")
+	}
+
+	certs := append(append(append([]*x509.Certificate{}, token.certificates...), intermediates...), roots...)
+
+	cert, err := token.signerCertificate(certs)
+	if err != nil {
+		return nil, err
+	}
+
+	err = token.verifySignature(cert, policy)
+	if err != nil {
+		return nil, err
+	}
+
+	chain, err := verifyChain(cert, roots, append(token.certificates, intermediates...), token.info.GenTime, x509.ExtKeyUsageTimeStamping)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Timestamp{
+		Time:         token.info.GenTime,
+		SerialNumber: token.info.SerialNumber,
+		Policy:       token.info.Policy,
+		Certificate:  cert,
+		Chain:        chain,
+	}, nil
+}
+
+// maxTimestampResponseSize bounds the size of TSA responses read.
+const maxTimestampResponseSize = 1 << 20
+
+// HTTPTimestampAuthority requests timestamp tokens from a TSA over HTTP, as
+// described by RFC 3161.
+type HTTPTimestampAuthority struct {
+	// URL is the TSA's endpoint.
+	URL string
+
+	// Client sends the requests. http.DefaultClient is used when nil.
+	Client *http.Client
+
+	// Policy, if set, is the TSA policy to request tokens under.
+	Policy asn1.ObjectIdentifier
+}
+
+// Timestamp implements TimestampAuthority. The TSA is asked to include its
+// certificate in the token, and the token returned is checked to answer the
+// request, but not verified.
+func (tsa *HTTPTimestampAuthority) Timestamp(hash crypto.Hash, digest []byte) ([]byte, error) {
+	oid, err := hashOID(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := asn1.Marshal(timeStampReq{
+		Version: 1,
+		MessageImprint: messageImprint{
+			HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oid},
+			HashedMessage: digest,
+		},
+		ReqPolicy: tsa.Policy,
+		Nonce:     nonce,
+		CertReq:   true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	client := tsa.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Post(tsa.URL, "application/timestamp-query", bytes.NewReader(req))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Timestamp request failed: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxTimestampResponseSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(body) > maxTimestampResponseSize {
+		return nil, errors.New("Timestamp response too large")
+	}
+
+	var tsResp timeStampResp
+	if rest, err := asn1.Unmarshal(body, &tsResp); err != nil || len(rest) != 0 {
+		return nil, errors.New("Invalid timestamp response")
+	}
+
+	// granted (0) or grantedWithMods (1)
+	if status := tsResp.Status.Status; status != 0 && status != 1 {
+		rejected := ErrTimestampRejected{Status: status}
+		if len(tsResp.Status.StatusString) != 0 {
+			rejected.StatusString = tsResp.Status.StatusString[0]
+		}
+		return nil, rejected
+	}
+
+	token, err := parseTimestampToken(tsResp.TimeStampToken.FullBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.info.MessageImprint.HashAlgorithm.Algorithm.Equal(oid) ||
+		!bytes.Equal(token.info.MessageImprint.HashedMessage, digest) {
+		return nil, errors.New("Timestamp token does not match the request")
+	}
+
+	if token.info.Nonce == nil || token.info.Nonce.Cmp(nonce) != 0 {
+		return nil, errors.New("Timestamp token nonce does not match the request")
+	}
+
+	return tsResp.TimeStampToken.FullBytes, nil
+}
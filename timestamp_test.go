@@ -0,0 +1,98 @@
+package dsig
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeTimestampAuthority(t *testing.T) {
+	tsa, err := newFakeTimestampAuthority()
+	require.NoError(t, err)
+	tsa.Clock = NewFakeClockAt(time.Now().Add(-time.Hour).UTC().Truncate(time.Second))
+
+	data := []byte("timestamped data")
+	digest := sha256.Sum256(data)
+
+	token, err := tsa.Timestamp(crypto.SHA256, digest[:])
+	require.NoError(t, err)
+
+	roots := []*x509.Certificate{tsa.Certificate()}
+
+	timestamp, err := verifyTimestampToken(token, data, roots, nil, nil)
+	require.NoError(t, err)
+	require.True(t, tsa.Clock.Now().Equal(timestamp.Time))
+	require.True(t, tsa.Certificate().Equal(timestamp.Certificate))
+	require.Equal(t, fakeTimestampPolicy, timestamp.Policy)
+	require.Equal(t, int64(1), timestamp.SerialNumber.Int64())
+
+	_, err = verifyTimestampToken(token, []byte("other data"), roots, nil, nil)
+	require.EqualError(t, err, "Timestamp token does not match the timestamped data")
+
+	// The TSA certificate must be trusted.
+	other, err := newFakeTimestampAuthority()
+	require.NoError(t, err)
+	_, err = verifyTimestampToken(token, data, []*x509.Certificate{other.Certificate()}, nil, nil)
+	require.Error(t, err)
+
+	// Its signature covers the token content.
+	tampered := append([]byte{}, token...)
+	tampered[len(tampered)-1] ^= 1
+	_, err = verifyTimestampToken(tampered, data, roots, nil, nil)
+	require.Error(t, err)
+
+	// The imprint algorithm is subject to the algorithm policy.
+	_, err = verifyTimestampToken(token, data, roots, nil, &AlgorithmPolicy{Allowed: []string{SHA512DigestMethod}})
+	require.Error(t, err)
+}
+
+func TestHTTPTimestampAuthority(t *testing.T) {
+	tsa, err := newFakeTimestampAuthority()
+	require.NoError(t, err)
+
+	server := httptest.NewServer(tsa)
+	defer server.Close()
+
+	data := []byte("timestamped data")
+	digest := sha256.Sum256(data)
+
+	client := &HTTPTimestampAuthority{URL: server.URL}
+
+	token, err := client.Timestamp(crypto.SHA256, digest[:])
+	require.NoError(t, err)
+
+	_, err = verifyTimestampToken(token, data, []*x509.Certificate{tsa.Certificate()}, nil, nil)
+	require.NoError(t, err)
+
+	// Tokens which do not answer the request, here for lack of its nonce,
+	// are rejected.
+	replay := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, err := tsa.Timestamp(crypto.SHA256, digest[:])
+		require.NoError(t, err)
+
+		resp, err := asn1.Marshal(timeStampResp{TimeStampToken: asn1.RawValue{FullBytes: token}})
+		require.NoError(t, err)
+		w.Write(resp)
+	}))
+	defer replay.Close()
+
+	_, err = (&HTTPTimestampAuthority{URL: replay.URL}).Timestamp(crypto.SHA256, digest[:])
+	require.EqualError(t, err, "Timestamp token nonce does not match the request")
+
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp, err := asn1.Marshal(timeStampResp{Status: pkiStatusInfo{Status: 2, StatusString: []string{"Not today"}}})
+		require.NoError(t, err)
+		w.Write(resp)
+	}))
+	defer rejecting.Close()
+
+	_, err = (&HTTPTimestampAuthority{URL: rejecting.URL}).Timestamp(crypto.SHA256, digest[:])
+	require.Equal(t, ErrTimestampRejected{Status: 2, StatusString: "Not today"}, err)
+}
@@ -1,6 +1,7 @@
 package dsig
 
 import (
+	"crypto"
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
@@ -10,7 +11,9 @@ import (
 //Well-known errors
 var (
 	ErrNonRSAKey           = fmt.Errorf("Private key was not RSA")
+	ErrNonECDSAKey         = fmt.Errorf("Private key was not ECDSA")
 	ErrMissingCertificates = fmt.Errorf("No public certificates provided")
+	ErrUnsupportedKey      = fmt.Errorf("Private key does not support signing")
 )
 
 //TLSCertKeyStore wraps the stdlib tls.Certificate to return its contained key
@@ -37,6 +40,26 @@ func (d TLSCertKeyStore) GetKeyPair() (*rsa.PrivateKey, *x509.Certificate, error
 	return pk, crt, nil
 }
 
+//GetSigner implements X509SignerStore using the underlying tls.Certificate,
+//accepting any private key which implements crypto.Signer.
+func (d TLSCertKeyStore) GetSigner() (crypto.Signer, *x509.Certificate, error) {
+	signer, ok := d.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, nil, ErrUnsupportedKey
+	}
+
+	if len(d.Certificate) < 1 {
+		return nil, nil, ErrMissingCertificates
+	}
+
+	crt, err := x509.ParseCertificate(d.Certificate[0])
+	if err != nil {
+		return nil, nil, ErrMissingCertificates
+	}
+
+	return signer, crt, nil
+}
+
 //GetChain impliments X509ChainStore using the underlying tls.Certificate
 func (d TLSCertKeyStore) GetChain() ([]*x509.Certificate, error) {
 	certs := []*x509.Certificate{}
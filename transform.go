@@ -0,0 +1,330 @@
+package dsig
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/beevik/etree"
+	"gitlab.com/moolekkari/goxmldsig/etreeutils"
+	"gitlab.com/moolekkari/goxmldsig/types"
+)
+
+// Transform is an implementation of a Reference transform algorithm.
+type Transform interface {
+	// Transform applies the transform to el, the current content of the
+	// Reference, and returns the transformed content. Implementations may
+	// modify el in place.
+	Transform(el *etree.Element, tctx *TransformContext) (*etree.Element, error)
+}
+
+// TransformContext describes the Reference a Transform is being applied to.
+type TransformContext struct {
+	// Signature is the Signature element containing the Reference, as found
+	// within the content being transformed. It is nil if the Signature is not
+	// part of that content, which is always the case while signing.
+	Signature *etree.Element
+
+	// Reference is the Reference being processed.
+	Reference *types.Reference
+
+	// element is the ds:Transform element being applied, as found within
+	// the content being transformed, if it is part of that content.
+	element *etree.Element
+
+	// limits bounds the content produced by transforms while validating.
+	// It is nil while signing.
+	limits *ValidationLimits
+}
+
+// NodeSetTransform is implemented by Transforms which select a subset of the
+// nodes of their input, such as the XPath transforms. When a Transform also
+// implements NodeSetTransform, TransformNodeSet is used in its place.
+type NodeSetTransform interface {
+	// TransformNodeSet applies the transform to ns and returns the resulting
+	// node-set. Implementations may modify ns in place.
+	TransformNodeSet(ns *etreeutils.NodeSet, tctx *TransformContext) (*etreeutils.NodeSet, error)
+}
+
+// OctetStreamTransform is implemented by Transforms whose output is an octet
+// stream rather than XML, such as the base64 transform. When a Transform
+// also implements OctetStreamTransform, TransformOctets is used in its place.
+type OctetStreamTransform interface {
+	// TransformOctets applies the transform to data and returns the
+	// resulting octet stream.
+	TransformOctets(data *TransformData, tctx *TransformContext) ([]byte, error)
+}
+
+// TransformData is the content of a Reference as it passes through its
+// transforms: either a node-set or an octet stream.
+type TransformData struct {
+	// NodeSet is the content as a node-set, or nil if the content is an
+	// octet stream.
+	NodeSet *etreeutils.NodeSet
+
+	// Octets is the content as an octet stream, if NodeSet is nil.
+	Octets []byte
+}
+
+// IsOctetStream reports whether the content is an octet stream.
+func (d *TransformData) IsOctetStream() bool {
+	return d.NodeSet == nil
+}
+
+// toNodeSet returns the content as a node-set, parsing it as XML if it is an
+// octet stream. The parsed document is checked against limits.
+func (d *TransformData) toNodeSet(limits *ValidationLimits) (*etreeutils.NodeSet, error) {
+	if !d.IsOctetStream() {
+		return d.NodeSet, nil
+	}
+
+	doc := etree.NewDocument()
+	doc.ReadSettings.CharsetReader = charsetReader
+	if err := doc.ReadFromBytes(d.Octets); err != nil {
+		return nil, fmt.Errorf("Transform input is not XML: %v", err)
+	}
+
+	if doc.Root() == nil {
+		return nil, errors.New("Transform input is not XML: missing root element")
+	}
+
+	if err := limits.checkDocument(doc.Root()); err != nil {
+		return nil, err
+	}
+
+	return etreeutils.NewNodeSet(doc.Root()), nil
+}
+
+// TransformFactory constructs a Transform from the parameters of a
+// ds:Transform element.
+type TransformFactory func(t *types.Transform) (Transform, error)
+
+// CanonicalizerFactory constructs a Canonicalizer from the parameters of a
+// ds:Transform or ds:CanonicalizationMethod element.
+type CanonicalizerFactory func(t *types.Transform) (Canonicalizer, error)
+
+var (
+	registryLock              sync.RWMutex
+	transformsByAlgorithm     = map[AlgorithmID]TransformFactory{}
+	canonicalizersByAlgorithm = map[AlgorithmID]CanonicalizerFactory{}
+)
+
+// RegisterTransform makes a Transform available under the passed algorithm
+// identifier, for both signing and validation. Registering an algorithm
+// a second time replaces the previous registration.
+func RegisterTransform(algorithm AlgorithmID, factory TransformFactory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	transformsByAlgorithm[algorithm] = factory
+}
+
+// RegisterCanonicalizer makes a Canonicalizer available under the passed
+// algorithm identifier, both as a Reference transform and as the
+// CanonicalizationMethod of a SignedInfo. Registering an algorithm a second
+// time replaces the previous registration.
+func RegisterCanonicalizer(algorithm AlgorithmID, factory CanonicalizerFactory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	canonicalizersByAlgorithm[algorithm] = factory
+}
+
+func lookupTransform(algorithm AlgorithmID) (TransformFactory, bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	factory, ok := transformsByAlgorithm[algorithm]
+	return factory, ok
+}
+
+func lookupCanonicalizer(algorithm AlgorithmID) (CanonicalizerFactory, bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	factory, ok := canonicalizersByAlgorithm[algorithm]
+	return factory, ok
+}
+
+// NewCanonicalizer constructs the registered Canonicalizer for the algorithm
+// and parameters of t.
+func NewCanonicalizer(t *types.Transform) (Canonicalizer, error) {
+	factory, ok := lookupCanonicalizer(AlgorithmID(t.Algorithm))
+	if !ok {
+		return nil, fmt.Errorf("Unknown Canonicalization Algorithm: %s", t.Algorithm)
+	}
+
+	return factory(t)
+}
+
+// NewTransform constructs the registered Transform for the algorithm and
+// parameters of t.
+func NewTransform(t *types.Transform) (Transform, error) {
+	factory, ok := lookupTransform(AlgorithmID(t.Algorithm))
+	if !ok {
+		return nil, errors.New("Unknown Transform Algorithm: " + t.Algorithm)
+	}
+
+	return factory(t)
+}
+
+// applyTransform applies t to data. Octet streams are parsed as XML for
+// Transforms which take a node-set, and Transforms which only implement
+// Transform are applied to the top level element of the node-set.
+func applyTransform(t Transform, data *TransformData, tctx *TransformContext) (*TransformData, error) {
+	if ost, ok := t.(OctetStreamTransform); ok {
+		octets, err := ost.TransformOctets(data, tctx)
+		if err != nil {
+			return nil, err
+		}
+		return &TransformData{Octets: octets}, nil
+	}
+
+	ns, err := data.toNodeSet(tctx.limits)
+	if err != nil {
+		return nil, err
+	}
+
+	if nst, ok := t.(NodeSetTransform); ok {
+		ns, err = nst.TransformNodeSet(ns, tctx)
+		if err != nil {
+			return nil, err
+		}
+		return &TransformData{NodeSet: ns}, nil
+	}
+
+	if ns.Root == nil {
+		return nil, errors.New("Transform input is an empty node-set")
+	}
+
+	el, err := t.Transform(ns.Root, tctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if el != ns.Root {
+		if !ns.IsSubtree() {
+			return nil, fmt.Errorf("Transform %T cannot be applied to a node-set", t)
+		}
+		ns = etreeutils.NewNodeSet(el)
+	}
+
+	return &TransformData{NodeSet: ns}, nil
+}
+
+// canonicalizationMethodTransform converts a CanonicalizationMethod into the
+// equivalent Transform, so that the same factories can serve both.
+func canonicalizationMethodTransform(method *types.CanonicalizationMethod) *types.Transform {
+	return &types.Transform{
+		Algorithm:           method.Algorithm,
+		InclusiveNamespaces: method.InclusiveNamespaces,
+	}
+}
+
+func inclusivePrefixList(t *types.Transform) string {
+	if t == nil || t.InclusiveNamespaces == nil {
+		return ""
+	}
+	return t.InclusiveNamespaces.PrefixList
+}
+
+// base64Transform implements the base64 decoding transform. A node-set
+// input is first reduced to the concatenation of its text nodes.
+type base64Transform struct{}
+
+// Transform cannot produce XML, so it always fails; base64 transforms are
+// applied through TransformOctets.
+func (base64Transform) Transform(el *etree.Element, tctx *TransformContext) (*etree.Element, error) {
+	return nil, errors.New("base64 transform output is not XML")
+}
+
+// TransformOctets decodes data as base64, ignoring whitespace.
+func (base64Transform) TransformOctets(data *TransformData, tctx *TransformContext) ([]byte, error) {
+	encoded := data.Octets
+	if !data.IsOctetStream() {
+		encoded = []byte(nodeSetText(data.NodeSet))
+	}
+
+	if err := tctx.limits.checkBase64(len(encoded)); err != nil {
+		return nil, err
+	}
+
+	encoded = bytes.Map(dropBase64Whitespace, encoded)
+
+	decoded := make([]byte, base64.StdEncoding.DecodedLen(len(encoded)))
+	n, err := base64.StdEncoding.Decode(decoded, encoded)
+	if err != nil {
+		return nil, fmt.Errorf("Error applying base64 transform: %v", err)
+	}
+
+	return decoded[:n], nil
+}
+
+// nodeSetText returns the concatenation of the text nodes of ns, in document
+// order.
+func nodeSetText(ns *etreeutils.NodeSet) string {
+	if ns.Root == nil {
+		return ""
+	}
+
+	var text strings.Builder
+
+	var collect func(el *etree.Element)
+	collect = func(el *etree.Element) {
+		for _, token := range el.Child {
+			switch token := token.(type) {
+			case *etree.Element:
+				collect(token)
+			case *etree.CharData:
+				text.WriteString(token.Data)
+			}
+		}
+	}
+	collect(ns.Root)
+
+	return text.String()
+}
+
+type envelopedSignatureTransform struct{}
+
+// Transform removes the Signature containing the Reference from el.
+func (envelopedSignatureTransform) Transform(el *etree.Element, tctx *TransformContext) (*etree.Element, error) {
+	if tctx.Signature == nil || tctx.Signature.Parent() == nil {
+		return nil, errors.New("Error applying canonicalization transform: Signature not found")
+	}
+
+	tctx.Signature.Parent().RemoveChild(tctx.Signature)
+
+	return el, nil
+}
+
+func init() {
+	RegisterTransform(EnvelopedSignatureAltorithmID, func(*types.Transform) (Transform, error) {
+		return envelopedSignatureTransform{}, nil
+	})
+	RegisterTransform(Base64AlgorithmID, func(*types.Transform) (Transform, error) {
+		return base64Transform{}, nil
+	})
+
+	RegisterCanonicalizer(CanonicalXML10ExclusiveAlgorithmID, func(t *types.Transform) (Canonicalizer, error) {
+		return MakeC14N10ExclusiveCanonicalizerWithPrefixList(inclusivePrefixList(t)), nil
+	})
+	RegisterCanonicalizer(CanonicalXML10ExclusiveWithCommentsAlgorithmID, func(t *types.Transform) (Canonicalizer, error) {
+		return MakeC14N10ExclusiveWithCommentsCanonicalizerWithPrefixList(inclusivePrefixList(t)), nil
+	})
+	RegisterCanonicalizer(CanonicalXML11AlgorithmID, func(*types.Transform) (Canonicalizer, error) {
+		return MakeC14N11Canonicalizer(), nil
+	})
+	RegisterCanonicalizer(CanonicalXML11WithCommentsAlgorithmID, func(*types.Transform) (Canonicalizer, error) {
+		return MakeC14N11WithCommentsCanonicalizer(), nil
+	})
+	RegisterCanonicalizer(CanonicalXML10RecAlgorithmID, func(*types.Transform) (Canonicalizer, error) {
+		return MakeC14N10RecCanonicalizer(), nil
+	})
+	RegisterCanonicalizer(CanonicalXML10CommentAlgorithmID, func(*types.Transform) (Canonicalizer, error) {
+		return MakeC14N10CommentCanonicalizer(), nil
+	})
+}
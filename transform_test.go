@@ -0,0 +1,195 @@
+package dsig
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"github.com/beevik/etree"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/moolekkari/goxmldsig/etreeutils"
+	"gitlab.com/moolekkari/goxmldsig/types"
+)
+
+const stripElementsAlgorithmID AlgorithmID = "urn:example:strip-elements"
+
+// stripElementsTransform removes every element whose tag is listed in the
+// Tag parameters of its Transform.
+type stripElementsTransform struct {
+	tags map[string]bool
+}
+
+func (t *stripElementsTransform) Transform(el *etree.Element, tctx *TransformContext) (*etree.Element, error) {
+	var strip func(el *etree.Element)
+	strip = func(el *etree.Element) {
+		for _, child := range el.ChildElements() {
+			if t.tags[child.Tag] {
+				el.RemoveChild(child)
+				continue
+			}
+			strip(child)
+		}
+	}
+	strip(el)
+
+	return el, nil
+}
+
+func init() {
+	RegisterTransform(stripElementsAlgorithmID, func(t *types.Transform) (Transform, error) {
+		tags := map[string]bool{}
+		if el := t.UnderlyingElement(); el != nil {
+			for _, param := range el.SelectElements("Tag") {
+				tags[param.Text()] = true
+			}
+		}
+		return &stripElementsTransform{tags: tags}, nil
+	})
+}
+
+func TestRegisteredTransform(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	params := etree.NewElement(TransformTag)
+	params.CreateElement("Tag").SetText("Volatile")
+
+	transform := types.Transform{Algorithm: string(stripElementsAlgorithmID)}
+	transform.SetUnderlyingElement(params)
+
+	ctx := NewDefaultSigningContext(ks)
+	ctx.Transforms = []types.Transform{transform}
+
+	doc := etree.NewDocument()
+	err = doc.ReadFromString(`<Root><Stable>a</Stable><Volatile>b</Volatile></Root>`)
+	require.NoError(t, err)
+
+	signed, err := ctx.SignEnveloped(doc.Root())
+	require.NoError(t, err)
+
+	transformElements := signed.FindElements("./Signature/SignedInfo/Reference/Transforms/Transform")
+	require.Len(t, transformElements, 3)
+	require.Equal(t, string(stripElementsAlgorithmID), transformElements[1].SelectAttrValue(AlgorithmAttr, ""))
+	require.Equal(t, "Volatile", transformElements[1].FindElement("./Tag").Text())
+
+	// Changes to stripped content do not affect the signature, while changes
+	// to anything else do.
+	signed.FindElement("./Volatile").SetText("changed")
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+
+	_, err = vc.Validate(signed)
+	require.NoError(t, err)
+
+	signed.FindElement("./Stable").SetText("changed")
+	_, err = vc.Validate(signed)
+	require.Error(t, err)
+}
+
+func TestUnknownTransform(t *testing.T) {
+	_, err := NewTransform(&types.Transform{Algorithm: "urn:example:unknown"})
+	require.EqualError(t, err, "Unknown Transform Algorithm: urn:example:unknown")
+
+	_, err = NewCanonicalizer(&types.Transform{Algorithm: "urn:example:unknown"})
+	require.Error(t, err)
+}
+
+func TestSetCanonicalizer(t *testing.T) {
+	ctx := NewDefaultSigningContext(RandomKeyStoreForTest())
+
+	err := ctx.SetCanonicalizer(string(CanonicalXML10ExclusiveWithCommentsAlgorithmID))
+	require.NoError(t, err)
+	require.Equal(t, CanonicalXML10ExclusiveWithCommentsAlgorithmID, ctx.Canonicalizer.Algorithm())
+
+	err = ctx.SetCanonicalizer("urn:example:unknown")
+	require.Error(t, err)
+}
+
+func TestBase64TransformReference(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	attachment := []byte("%PDF-1.4\n\x00\x01binary content")
+
+	// Wrap the encoded attachment over several lines, as is usual.
+	encoded := base64.StdEncoding.EncodeToString(attachment)
+	wrapped := encoded[:8] + "\n  " + encoded[8:]
+
+	doc := etree.NewDocument()
+	err = doc.ReadFromString(`<Packet><Metadata>a</Metadata><Attachment>` + wrapped + `</Attachment></Packet>`)
+	require.NoError(t, err)
+
+	ctx := NewDefaultSigningContext(ks)
+	ctx.Transforms = []types.Transform{
+		NewXPathFilter2Transform(XPathFilter{Filter: XPathFilterIntersect, XPath: "//Attachment"}),
+		{Algorithm: Base64AlgorithmID.String()},
+	}
+
+	signed, err := ctx.SignEnveloped(doc.Root())
+	require.NoError(t, err)
+
+	// The octet stream is digested as is, so no canonicalization transform
+	// follows the base64 transform.
+	transformElements := signed.FindElements("./Signature/SignedInfo/Reference/Transforms/Transform")
+	require.Len(t, transformElements, 3)
+	require.Equal(t, Base64AlgorithmID.String(), transformElements[2].SelectAttrValue(AlgorithmAttr, ""))
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+
+	_, err = vc.Validate(signed)
+	require.Equal(t, ErrOctetStreamReference, err)
+
+	data, err := vc.ValidateData(signed)
+	require.NoError(t, err)
+	require.True(t, data.IsOctetStream())
+	require.Equal(t, attachment, data.Octets)
+
+	// Only the attachment is signed, and only its decoded content matters.
+	signed.FindElement("./Metadata").SetText("changed")
+	signed.FindElement("./Attachment").SetText(encoded)
+	_, err = vc.ValidateData(signed)
+	require.NoError(t, err)
+
+	signed.FindElement("./Attachment").SetText(base64.StdEncoding.EncodeToString([]byte("changed")))
+	_, err = vc.ValidateData(signed)
+	require.Error(t, err)
+}
+
+func TestBase64TransformOutputParsed(t *testing.T) {
+	base64Transform, err := NewTransform(&types.Transform{Algorithm: Base64AlgorithmID.String()})
+	require.NoError(t, err)
+
+	el := etree.NewElement("Encoded")
+	el.SetText(base64.StdEncoding.EncodeToString([]byte(`<Decoded><Child/></Decoded>`)))
+
+	data, err := applyTransform(base64Transform, &TransformData{NodeSet: etreeutils.NewNodeSet(el)}, &TransformContext{})
+	require.NoError(t, err)
+	require.Equal(t, "<Decoded><Child/></Decoded>", string(data.Octets))
+
+	// A node-set transform parses the octet stream.
+	xpath := NewXPathTransform("not(self::Child)", nil)
+	xpathTransform, err := NewTransform(&xpath)
+	require.NoError(t, err)
+
+	data, err = applyTransform(xpathTransform, data, &TransformContext{})
+	require.NoError(t, err)
+	require.False(t, data.IsOctetStream())
+
+	canonical, err := canonicalizeData(MakeC14N11Canonicalizer(), data)
+	require.NoError(t, err)
+	require.Equal(t, "<Decoded></Decoded>", string(canonical))
+
+	el.SetText(strings.Repeat("!", 8))
+	_, err = applyTransform(base64Transform, &TransformData{NodeSet: etreeutils.NewNodeSet(el)}, &TransformContext{})
+	require.Error(t, err)
+
+	_, err = applyTransform(xpathTransform, &TransformData{Octets: []byte("not xml")}, &TransformContext{})
+	require.Error(t, err)
+}
@@ -15,6 +15,20 @@ type Transform struct {
 	XMLName             xml.Name             `xml:"http://www.w3.org/2000/09/xmldsig# Transform"`
 	Algorithm           string               `xml:"Algorithm,attr"`
 	InclusiveNamespaces *InclusiveNamespaces `xml:"InclusiveNamespaces"`
+	el                  *etree.Element
+}
+
+// SetUnderlyingElement will be called with a reference to the Element this Transform
+// was unmarshaled from.
+func (t *Transform) SetUnderlyingElement(el *etree.Element) {
+	t.el = el
+}
+
+// UnderlyingElement returns a reference to the Element this Transform was unmarshaled
+// from, where applicable. Algorithm specific parameters which are not modeled by
+// Transform can be read from its children.
+func (t *Transform) UnderlyingElement() *etree.Element {
+	return t.el
 }
 
 type Transforms struct {
@@ -29,15 +43,18 @@ type DigestMethod struct {
 
 type Reference struct {
 	XMLName     xml.Name     `xml:"http://www.w3.org/2000/09/xmldsig# Reference"`
+	ID          string       `xml:"Id,attr"`
 	URI         string       `xml:"URI,attr"`
+	Type        string       `xml:"Type,attr"`
 	DigestValue string       `xml:"DigestValue"`
 	DigestAlgo  DigestMethod `xml:"DigestMethod"`
 	Transforms  Transforms   `xml:"Transforms"`
 }
 
 type CanonicalizationMethod struct {
-	XMLName   xml.Name `xml:"http://www.w3.org/2000/09/xmldsig# CanonicalizationMethod"`
-	Algorithm string   `xml:"Algorithm,attr"`
+	XMLName             xml.Name             `xml:"http://www.w3.org/2000/09/xmldsig# CanonicalizationMethod"`
+	Algorithm           string               `xml:"Algorithm,attr"`
+	InclusiveNamespaces *InclusiveNamespaces `xml:"InclusiveNamespaces"`
 }
 
 type SignatureMethod struct {
@@ -52,6 +69,31 @@ type SignedInfo struct {
 	References             []Reference            `xml:"Reference"`
 }
 
+type Manifest struct {
+	XMLName    xml.Name    `xml:"http://www.w3.org/2000/09/xmldsig# Manifest"`
+	ID         string      `xml:"Id,attr"`
+	References []Reference `xml:"Reference"`
+}
+
+type SignatureProperties struct {
+	XMLName             xml.Name            `xml:"http://www.w3.org/2000/09/xmldsig# SignatureProperties"`
+	ID                  string              `xml:"Id,attr"`
+	SignatureProperties []SignatureProperty `xml:"SignatureProperty"`
+}
+
+type SignatureProperty struct {
+	XMLName xml.Name                   `xml:"http://www.w3.org/2000/09/xmldsig# SignatureProperty"`
+	ID      string                     `xml:"Id,attr"`
+	Target  string                     `xml:"Target,attr"`
+	Content []SignaturePropertyContent `xml:",any"`
+}
+
+type SignaturePropertyContent struct {
+	XMLName  xml.Name
+	Value    string `xml:",chardata"`
+	InnerXML string `xml:",innerxml"`
+}
+
 type SignatureValue struct {
 	XMLName xml.Name `xml:"http://www.w3.org/2000/09/xmldsig# SignatureValue"`
 	Data    string   `xml:",chardata"`
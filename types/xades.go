@@ -0,0 +1,124 @@
+package types
+
+import (
+	"encoding/xml"
+)
+
+type SignedProperties struct {
+	XMLName                   xml.Name                   `xml:"http://uri.etsi.org/01903/v1.3.2# SignedProperties"`
+	ID                        string                     `xml:"Id,attr"`
+	SignedSignatureProperties *SignedSignatureProperties `xml:"SignedSignatureProperties"`
+}
+
+type SignedSignatureProperties struct {
+	XMLName                   xml.Name                   `xml:"http://uri.etsi.org/01903/v1.3.2# SignedSignatureProperties"`
+	SigningTime               string                     `xml:"SigningTime"`
+	SigningCertificateV2      *SigningCertificateV2      `xml:"SigningCertificateV2"`
+	SignaturePolicyIdentifier *SignaturePolicyIdentifier `xml:"SignaturePolicyIdentifier"`
+}
+
+type SigningCertificateV2 struct {
+	XMLName xml.Name `xml:"http://uri.etsi.org/01903/v1.3.2# SigningCertificateV2"`
+	Certs   []Cert   `xml:"Cert"`
+}
+
+type Cert struct {
+	XMLName        xml.Name   `xml:"http://uri.etsi.org/01903/v1.3.2# Cert"`
+	CertDigest     CertDigest `xml:"CertDigest"`
+	IssuerSerialV2 string     `xml:"IssuerSerialV2"`
+}
+
+type CertDigest struct {
+	XMLName      xml.Name     `xml:"http://uri.etsi.org/01903/v1.3.2# CertDigest"`
+	DigestMethod DigestMethod `xml:"DigestMethod"`
+	DigestValue  string       `xml:"DigestValue"`
+}
+
+type SignaturePolicyIdentifier struct {
+	XMLName                xml.Name                `xml:"http://uri.etsi.org/01903/v1.3.2# SignaturePolicyIdentifier"`
+	SignaturePolicyID      *SignaturePolicyID      `xml:"SignaturePolicyId"`
+	SignaturePolicyImplied *SignaturePolicyImplied `xml:"SignaturePolicyImplied"`
+}
+
+type SignaturePolicyImplied struct {
+	XMLName xml.Name `xml:"http://uri.etsi.org/01903/v1.3.2# SignaturePolicyImplied"`
+}
+
+type SignaturePolicyID struct {
+	XMLName             xml.Name             `xml:"http://uri.etsi.org/01903/v1.3.2# SignaturePolicyId"`
+	SigPolicyID         SigPolicyID          `xml:"SigPolicyId"`
+	SigPolicyHash       SigPolicyHash        `xml:"SigPolicyHash"`
+	SigPolicyQualifiers *SigPolicyQualifiers `xml:"SigPolicyQualifiers"`
+}
+
+type SigPolicyID struct {
+	XMLName     xml.Name `xml:"http://uri.etsi.org/01903/v1.3.2# SigPolicyId"`
+	Identifier  string   `xml:"Identifier"`
+	Description string   `xml:"Description"`
+}
+
+type SigPolicyHash struct {
+	XMLName      xml.Name     `xml:"http://uri.etsi.org/01903/v1.3.2# SigPolicyHash"`
+	DigestMethod DigestMethod `xml:"DigestMethod"`
+	DigestValue  string       `xml:"DigestValue"`
+}
+
+type SigPolicyQualifiers struct {
+	XMLName             xml.Name             `xml:"http://uri.etsi.org/01903/v1.3.2# SigPolicyQualifiers"`
+	SigPolicyQualifiers []SigPolicyQualifier `xml:"SigPolicyQualifier"`
+}
+
+type SigPolicyQualifier struct {
+	XMLName xml.Name `xml:"http://uri.etsi.org/01903/v1.3.2# SigPolicyQualifier"`
+	SPURI   string   `xml:"SPURI"`
+}
+
+type UnsignedProperties struct {
+	XMLName                     xml.Name                     `xml:"http://uri.etsi.org/01903/v1.3.2# UnsignedProperties"`
+	ID                          string                       `xml:"Id,attr"`
+	UnsignedSignatureProperties *UnsignedSignatureProperties `xml:"UnsignedSignatureProperties"`
+}
+
+type UnsignedSignatureProperties struct {
+	XMLName             xml.Name            `xml:"http://uri.etsi.org/01903/v1.3.2# UnsignedSignatureProperties"`
+	SignatureTimeStamps []XAdESTimeStamp    `xml:"SignatureTimeStamp"`
+	CertificateValues   []CertificateValues `xml:"CertificateValues"`
+	RevocationValues    []RevocationValues  `xml:"RevocationValues"`
+}
+
+// XAdESTimeStamp is a XAdES time-stamp property, such as a
+// SignatureTimeStamp, whose name varies with the data it timestamps.
+type XAdESTimeStamp struct {
+	ID                     string                  `xml:"Id,attr"`
+	CanonicalizationMethod *CanonicalizationMethod `xml:"CanonicalizationMethod"`
+	EncapsulatedTimeStamps []EncapsulatedPKIData   `xml:"EncapsulatedTimeStamp"`
+}
+
+type EncapsulatedPKIData struct {
+	ID       string `xml:"Id,attr"`
+	Encoding string `xml:"Encoding,attr"`
+	Data     string `xml:",chardata"`
+}
+
+type CertificateValues struct {
+	XMLName                      xml.Name              `xml:"http://uri.etsi.org/01903/v1.3.2# CertificateValues"`
+	ID                           string                `xml:"Id,attr"`
+	EncapsulatedX509Certificates []EncapsulatedPKIData `xml:"EncapsulatedX509Certificate"`
+}
+
+type RevocationValues struct {
+	XMLName    xml.Name    `xml:"http://uri.etsi.org/01903/v1.3.2# RevocationValues"`
+	ID         string      `xml:"Id,attr"`
+	CRLValues  *CRLValues  `xml:"CRLValues"`
+	OCSPValues *OCSPValues `xml:"OCSPValues"`
+}
+
+type CRLValues struct {
+	XMLName               xml.Name              `xml:"http://uri.etsi.org/01903/v1.3.2# CRLValues"`
+	EncapsulatedCRLValues []EncapsulatedPKIData `xml:"EncapsulatedCRLValue"`
+}
+
+type OCSPValues struct {
+	XMLName                xml.Name              `xml:"http://uri.etsi.org/01903/v1.3.2# OCSPValues"`
+	EncapsulatedOCSPValues []EncapsulatedPKIData `xml:"EncapsulatedOCSPValue"`
+}
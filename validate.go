@@ -2,33 +2,110 @@ package dsig
 
 import (
 	"bytes"
-	"crypto/rsa"
 	"crypto/x509"
-	"encoding/base64"
 	"errors"
 	"fmt"
 	"regexp"
+	"strings"
+	"time"
 
 	"github.com/beevik/etree"
-	"github.com/russellhaering/goxmldsig/etreeutils"
-	"github.com/russellhaering/goxmldsig/types"
+	"gitlab.com/moolekkari/goxmldsig/etreeutils"
+	"gitlab.com/moolekkari/goxmldsig/types"
 )
 
 var uriRegexp = regexp.MustCompile("^#[a-zA-Z_][\\w.-]*$")
-var whiteSpace = regexp.MustCompile("\\s+")
 
 var (
 	// ErrMissingSignature indicates that no enveloped signature was found referencing
 	// the top level element passed for signature verification.
 	ErrMissingSignature = errors.New("Missing signature referencing the top-level element")
+
+	// ErrOctetStreamReference indicates that the content signed by a valid
+	// signature is an octet stream rather than XML, and so can only be
+	// obtained through ValidateData.
+	ErrOctetStreamReference = errors.New("Signed content is an octet stream")
 )
 
 // ValidationContext is a base structure for validation.
 type ValidationContext struct {
 	CertificateStore X509CertificateStore
 	Clock            *Clock
+
+	// AlgorithmPolicy restricts the signature and digest algorithms which
+	// are accepted. A nil AlgorithmPolicy accepts all registered algorithms.
+	AlgorithmPolicy *AlgorithmPolicy
+
+	// Limits bounds the work done validating untrusted documents. Nil
+	// Limits apply DefaultValidationLimits.
+	Limits *ValidationLimits
+
+	// Trust, if set, decides whether the certificate a signature was made
+	// with is trusted, in place of checking that it is one of the
+	// CertificateStore's roots and is valid at the Clock's time. The
+	// CertificateStore may then be nil, unless signatures without a KeyInfo
+	// are to be verified against its only root.
+	Trust TrustFunc
+
+	// CertificateStoreResolver, if set, selects the certificate store for
+	// each document validated, in place of CertificateStore. ValidateStream
+	// does not support it.
+	CertificateStoreResolver CertificateStoreResolver
+
+	// SignerConstraints, if set, restricts which trusted certificates
+	// signatures are accepted from.
+	SignerConstraints *SignerConstraints
+
+	// ClockSkew is the tolerance allowed when checking that the signer
+	// certificate is valid, on either side of its validity period.
+	ClockSkew time.Duration
+
+	// ValidationTime, if set, is the time at which the signer certificate
+	// must be valid, in place of the Clock's time. It allows archived
+	// documents to be verified as of their signing time, such as from a
+	// trusted timestamp, after the certificate has expired.
+	ValidationTime time.Time
+
+	// TimestampCertificateStore holds the TSA certificates, or roots of
+	// their chains, trusted to timestamp signatures.
+	TimestampCertificateStore X509CertificateStore
+
+	// LongTermValidation, if set, verifies the signer certificates of XAdES
+	// signatures offline, against the validation data embedded in them, in
+	// place of Trust. See ValidateXAdES.
+	LongTermValidation bool
 }
 
+// ValidationResult describes a verified signature.
+type ValidationResult struct {
+	// Content is the signed content, as returned by ValidateData.
+	Content *TransformData
+
+	// Certificate is the certificate the signature was verified against.
+	Certificate *x509.Certificate
+
+	// XAdES holds the signed qualifying properties of a XAdES signature.
+	XAdES *XAdESProperties
+
+	// CounterSignatures are the verified counter-signatures of the
+	// signature.
+	CounterSignatures []*CounterSignature
+
+	// Manifests are the outcomes of checking the References of the
+	// Manifests the signature signs, as reported by ValidateWithManifests.
+	Manifests []*ManifestResult
+
+	// SignatureProperties are the SignatureProperty elements the signature
+	// signs, as returned by ValidateWithSignatureProperties.
+	SignatureProperties []*SignatureProperty
+}
+
+// TrustFunc decides whether to trust cert, the certificate a signature was
+// made with. chain holds the further certificates of the Signature's
+// KeyInfo, which is nil if the signature has none. A non-nil error rejects
+// the signature.
+type TrustFunc func(cert *x509.Certificate, chain []*x509.Certificate, keyInfo *types.KeyInfo) error
+
 // NewDefaultValidationContext will create a new context for validation.
 func NewDefaultValidationContext(certificateStore X509CertificateStore) *ValidationContext {
 	return &ValidationContext{
@@ -75,7 +152,7 @@ func mapPathToElement(tree, el *etree.Element) []int {
 	for i, child := range tree.Child {
 		if childElement, ok := child.(*etree.Element); ok {
 			childPath := mapPathToElement(childElement, el)
-			if childElement != nil {
+			if childPath != nil {
 				return append([]int{i}, childPath...)
 			}
 		}
@@ -84,124 +161,236 @@ func mapPathToElement(tree, el *etree.Element) []int {
 	return nil
 }
 
-func removeElementAtPath(el *etree.Element, path []int) bool {
-	if len(path) == 0 {
-		return false
-	}
-
-	if len(el.Child) <= path[0] {
-		return false
-	}
+func elementAtPath(el *etree.Element, path []int) *etree.Element {
+	for _, i := range path {
+		if len(el.Child) <= i {
+			return nil
+		}
 
-	childElement, ok := el.Child[path[0]].(*etree.Element)
-	if !ok {
-		return false
-	}
+		childElement, ok := el.Child[i].(*etree.Element)
+		if !ok {
+			return nil
+		}
 
-	if len(path) == 1 {
-		el.RemoveChild(childElement)
-		return true
+		el = childElement
 	}
 
-	return removeElementAtPath(childElement, path[1:])
+	return el
 }
 
-// Transform returns a new element equivalent to the passed root el, but with
-// the set of transformations described by the ref applied.
-//
-// The functionality of transform is currently very limited and purpose-specific.
+// Transform returns new content equivalent to the passed root el, but with
+// the set of transformations described by the ref applied. The content is
+// either a node-set, along with the canonicalizer to digest it with, or an
+// octet stream. Transforms and canonicalizers are resolved through the
+// transform registry.
 func (ctx *ValidationContext) transform(
 	el *etree.Element,
 	sig *types.Signature,
-	ref *types.Reference) (*etree.Element, Canonicalizer, error) {
+	ref *types.Reference) (*TransformData, Canonicalizer, error) {
 	transforms := ref.Transforms.Transforms
 
-	// if len(transforms) != 2 {
-	// 	return nil, nil, errors.New("Expected Enveloped and C14N transforms")
-	// }
-
 	// map the path to the passed signature relative to the passed root, in
 	// order to enable removal of the signature by an enveloped signature
 	// transform
 	signaturePath := mapPathToElement(el, sig.UnderlyingElement())
 
+	// likewise map the Transform elements, whose parameters may refer to
+	// their own location in the document
+	transformPaths := make([][]int, len(transforms))
+	for i := range transforms {
+		if transformElement := transforms[i].UnderlyingElement(); transformElement != nil {
+			transformPaths[i] = mapPathToElement(el, transformElement)
+		}
+	}
+
 	// make a copy of the passed root
-	el = el.Copy()
+	el, err := detachedCopy(el)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Same-document references select a node-set without comments, even if a
+	// #WithComments canonicalization algorithm is applied to it afterwards.
+	if isSameDocumentReference(ref.URI) {
+		removeComments(el)
+	}
+
+	tctx := &TransformContext{
+		Reference: ref,
+		limits:    ctx.limits(),
+	}
+	if signaturePath != nil {
+		tctx.Signature = elementAtPath(el, signaturePath)
+	}
+
+	transformElements := make([]*etree.Element, len(transforms))
+	for i, path := range transformPaths {
+		if path != nil {
+			transformElements[i] = elementAtPath(el, path)
+		}
+	}
+
+	data := &TransformData{NodeSet: etreeutils.NewNodeSet(el)}
 
 	var canonicalizer Canonicalizer
 
-	for _, transform := range transforms {
-		algo := transform.Algorithm
+	for i := range transforms {
+		transform := &transforms[i]
+		algo := AlgorithmID(transform.Algorithm)
 
-		switch AlgorithmID(algo) {
-		case EnvelopedSignatureAltorithmID:
-			if !removeElementAtPath(el, signaturePath) {
-				return nil, nil, errors.New("Error applying canonicalization transform: Signature not found")
+		if factory, ok := lookupCanonicalizer(algo); ok {
+			c, err := factory(transform)
+			if err != nil {
+				return nil, nil, err
 			}
 
-		case CanonicalXML10ExclusiveAlgorithmID:
-			var prefixList string
-			if transform.InclusiveNamespaces != nil {
-				prefixList = transform.InclusiveNamespaces.PrefixList
-			}
+			canonicalizer = c
 
-			canonicalizer = MakeC14N10ExclusiveCanonicalizerWithPrefixList(prefixList)
+			// Canonicalization parses an octet stream into a node-set.
+			ns, err := data.toNodeSet(tctx.limits)
+			if err != nil {
+				return nil, nil, err
+			}
+			data = &TransformData{NodeSet: ns}
+			continue
+		}
 
-		case CanonicalXML11AlgorithmID:
-			canonicalizer = MakeC14N11Canonicalizer()
+		t, err := NewTransform(transform)
+		if err != nil {
+			return nil, nil, err
+		}
 
-		case CanonicalXML10RecAlgorithmID:
-			canonicalizer = MakeC14N10RecCanonicalizer()
+		tctx.element = transformElements[i]
 
-		case CanonicalXML10CommentAlgorithmID:
-			canonicalizer = MakeC14N10CommentCanonicalizer()
+		data, err = applyTransform(t, data, tctx)
+		if err != nil {
+			return nil, nil, err
+		}
 
-		default:
-			return nil, nil, errors.New("Unknown Transform Algorithm: " + algo)
+		// A canonicalizer applied before the content became an octet
+		// stream has no bearing on how it is digested.
+		if data.IsOctetStream() {
+			canonicalizer = nil
 		}
 	}
 
+	if data.IsOctetStream() {
+		return data, nil, nil
+	}
+
 	if canonicalizer == nil {
 		// If canonicalizer is empty, Instead of sending an error,
 		// return canonicalizer based on CanonicalizationMethod from SignedInfo.
-		algo := sig.SignedInfo.CanonicalizationMethod.Algorithm
+		c, err := NewCanonicalizer(canonicalizationMethodTransform(&sig.SignedInfo.CanonicalizationMethod))
+		if err != nil {
+			return nil, nil, errors.New("Expected canonicalization transform")
+		}
+
+		canonicalizer = c
+	}
+	return data, canonicalizer, nil
+}
 
-		switch AlgorithmID(algo) {
-		case CanonicalXML10ExclusiveAlgorithmID:
-			// CanonicalXML10ExclusiveAlgorithmID with empty prefix list.
-			var prefixList = ""
+// detachedCopy copies el. If el is nested within a document, the namespaces
+// in scope at it are declared on the copy.
+func detachedCopy(el *etree.Element) (*etree.Element, error) {
+	// The root of a document has the document itself as its parent.
+	if parent := el.Parent(); parent == nil || parent.Tag == "" {
+		return el.Copy(), nil
+	}
 
-			canonicalizer = MakeC14N10ExclusiveCanonicalizerWithPrefixList(prefixList)
+	nsCtx, err := etreeutils.NSBuildParentContext(el)
+	if err != nil {
+		return nil, err
+	}
 
-		case CanonicalXML11AlgorithmID:
-			canonicalizer = MakeC14N11Canonicalizer()
+	return etreeutils.NSDetatch(nsCtx, el)
+}
 
-		case CanonicalXML10RecAlgorithmID:
-			canonicalizer = MakeC14N10RecCanonicalizer()
+// dereference returns the element within el which a Reference URI other
+// than that of the first Reference refers to. An empty URI refers to el,
+// and a bare fragment identifier to the element with that ID.
+func dereference(el *etree.Element, uri string) (*etree.Element, error) {
+	if uri == "" {
+		return el, nil
+	}
 
-		case CanonicalXML10CommentAlgorithmID:
-			canonicalizer = MakeC14N10CommentCanonicalizer()
+	if !uriRegexp.MatchString(uri) {
+		return nil, fmt.Errorf("Unsupported Reference URI: %s", uri)
+	}
 
-		default:
-			return nil, nil, errors.New("Expected canonicalization transform")
+	target, err := findElementByID(el, uri[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	if target == nil {
+		return nil, fmt.Errorf("Missing element referenced by %s", uri)
+	}
+
+	return target, nil
+}
+
+// findElementByID returns the element among el and its descendants with an
+// Id, ID or id attribute of id, or nil if there is none. IDs must be unique,
+// lest a different element be verified than the one the signer referenced.
+func findElementByID(el *etree.Element, id string) (*etree.Element, error) {
+	var found *etree.Element
+
+	var find func(el *etree.Element) error
+	find = func(el *etree.Element) error {
+		for _, attr := range el.Attr {
+			if attr.Space != "" || attr.Value != id {
+				continue
+			}
+
+			if attr.Key == IDAttr || attr.Key == "ID" || attr.Key == "id" {
+				if found != nil {
+					return fmt.Errorf("Duplicate ID: %s", id)
+				}
+				found = el
+				break
+			}
 		}
+
+		for _, child := range el.ChildElements() {
+			if err := find(child); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if err := find(el); err != nil {
+		return nil, err
 	}
-	return el, canonicalizer, nil
+
+	return found, nil
+}
+
+// isSameDocumentReference reports whether uri is a bare same-document
+// reference (either empty or a bare fragment identifier). Per XMLDSig these
+// dereference to a node-set from which comments have been removed, unlike
+// the #xpointer(/) and #xpointer(id('ID')) forms.
+func isSameDocumentReference(uri string) bool {
+	return uri == "" || (strings.HasPrefix(uri, "#") && !strings.HasPrefix(uri, "#xpointer("))
 }
 
 func (ctx *ValidationContext) digest(el *etree.Element, digestAlgorithmID string, canonicalizer Canonicalizer) ([]byte, error) {
-	data, err := canonicalizer.Canonicalize(el)
+	return ctx.digestData(&TransformData{NodeSet: etreeutils.NewNodeSet(el)}, digestAlgorithmID, canonicalizer)
+}
+
+// digestData digests transformed content, canonicalizing it first if it is
+// a node-set. The canonical form is streamed into the hash.
+func (ctx *ValidationContext) digestData(transformed *TransformData, digestAlgorithmID string, canonicalizer Canonicalizer) ([]byte, error) {
+	digestAlgorithm, err := ctx.AlgorithmPolicy.digestAlgorithm(digestAlgorithmID)
 	if err != nil {
 		return nil, err
 	}
 
-	digestAlgorithm, ok := digestAlgorithmsByIdentifier[digestAlgorithmID]
-	if !ok {
-		return nil, errors.New("Unknown digest algorithm: " + digestAlgorithmID)
-	}
-
-	hash := digestAlgorithm.New()
-	_, err = hash.Write(data)
+	hash := digestAlgorithm.Hash.New()
+	err = writeCanonicalData(ctx.limits().limitCanonical(hash), canonicalizer, transformed)
 	if err != nil {
 		return nil, err
 	}
@@ -209,7 +398,7 @@ func (ctx *ValidationContext) digest(el *etree.Element, digestAlgorithmID string
 	return hash.Sum(nil), nil
 }
 
-func (ctx *ValidationContext) verifySignedInfo(sig *types.Signature, canonicalizer Canonicalizer, signatureMethodID string, cert *x509.Certificate, decodedSignature []byte) error {
+func (ctx *ValidationContext) verifySignedInfo(sig *types.Signature, signatureMethodID string, cert *x509.Certificate, decodedSignature []byte) error {
 	signatureElement := sig.UnderlyingElement()
 
 	nsCtx, err := etreeutils.NSBuildParentContext(signatureElement)
@@ -226,32 +415,39 @@ func (ctx *ValidationContext) verifySignedInfo(sig *types.Signature, canonicaliz
 		return errors.New("Missing SignedInfo")
 	}
 
-	// Canonicalize the xml
-	canonical, err := canonicalSerialize(signedInfo)
+	sigNSCtx, err := nsCtx.SubContext(signatureElement)
 	if err != nil {
 		return err
 	}
 
-	signatureAlgorithm, ok := signatureMethodsByIdentifier[signatureMethodID]
-	if !ok {
-		return errors.New("Unknown signature method: " + signatureMethodID)
+	// Detatch the SignedInfo from its surrounding document, so that all
+	// namespaces in scope are declared on it.
+	detachedSignedInfo, err := etreeutils.NSDetatch(sigNSCtx, signedInfo)
+	if err != nil {
+		return err
 	}
 
-	hash := signatureAlgorithm.New()
-	_, err = hash.Write(canonical)
+	canonicalizer, err := NewCanonicalizer(canonicalizationMethodTransform(&sig.SignedInfo.CanonicalizationMethod))
 	if err != nil {
 		return err
 	}
 
-	hashed := hash.Sum(nil)
+	signatureAlgorithm, err := ctx.AlgorithmPolicy.signatureAlgorithm(signatureMethodID)
+	if err != nil {
+		return err
+	}
 
-	pubKey, ok := cert.PublicKey.(*rsa.PublicKey)
-	if !ok {
-		return errors.New("Invalid public key")
+	// Canonicalize the xml into the hash
+	hash := signatureAlgorithm.Hash.New()
+	err = canonicalizeTo(ctx.limits().limitCanonical(hash), canonicalizer, detachedSignedInfo)
+	if err != nil {
+		return err
 	}
 
+	hashed := hash.Sum(nil)
+
 	// Verify that the private key matching the public key from the cert was what was used to sign the 'SignedInfo' and produce the 'SignatureValue'
-	err = rsa.VerifyPKCS1v15(pubKey, signatureAlgorithm, hashed[:], decodedSignature)
+	err = signatureAlgorithm.Verifier.Verify(cert.PublicKey, signatureAlgorithm.Hash, hashed[:], decodedSignature)
 	if err != nil {
 		return err
 	}
@@ -259,13 +455,9 @@ func (ctx *ValidationContext) verifySignedInfo(sig *types.Signature, canonicaliz
 	return nil
 }
 
-func (ctx *ValidationContext) validateSignature(el *etree.Element, sig *types.Signature, cert *x509.Certificate) (*etree.Element, error) {
-	var ref *types.Reference
-
-	// Find the first reference which references the top-level element
-
-	ref = &sig.SignedInfo.References[0]
-
+// verifyReference transforms el as ref describes, and checks the digest of
+// the result against ref's DigestValue. It returns the transformed content.
+func (ctx *ValidationContext) verifyReference(el *etree.Element, sig *types.Signature, ref *types.Reference) (*TransformData, error) {
 	// Perform all transformations listed in the 'SignedInfo'
 	// Basically, this means removing the 'SignedInfo'
 	transformed, canonicalizer, err := ctx.transform(el, sig, ref)
@@ -276,12 +468,12 @@ func (ctx *ValidationContext) validateSignature(el *etree.Element, sig *types.Si
 	digestAlgorithm := ref.DigestAlgo.Algorithm
 
 	// Digest the transformed XML and compare it to the 'DigestValue' from the 'SignedInfo'
-	digest, err := ctx.digest(transformed, digestAlgorithm, canonicalizer)
+	digest, err := ctx.digestData(transformed, digestAlgorithm, canonicalizer)
 	if err != nil {
 		return nil, err
 	}
 
-	decodedDigestValue, err := base64.StdEncoding.DecodeString(ref.DigestValue)
+	decodedDigestValue, err := decodeBase64Binary(ref.DigestValue)
 	if err != nil {
 		return nil, err
 	}
@@ -290,15 +482,38 @@ func (ctx *ValidationContext) validateSignature(el *etree.Element, sig *types.Si
 		return nil, errors.New("Signature could not be verified")
 	}
 
-	// Decode the 'SignatureValue' so we can compare against it
-	decodedSignature, err := base64.StdEncoding.DecodeString(sig.SignatureValue.Data)
+	return transformed, nil
+}
+
+func (ctx *ValidationContext) validateSignature(el *etree.Element, sig *types.Signature, cert *x509.Certificate) (*TransformData, error) {
+	references := sig.SignedInfo.References
+	if len(references) == 0 {
+		return nil, errors.New("Missing Reference")
+	}
+
+	// The first reference is taken to reference the top-level element
+	transformed, err := ctx.verifyReference(el, sig, &references[0])
 	if err != nil {
-		return nil, errors.New("Could not decode signature")
+		return nil, err
 	}
 
-	// Actually verify the 'SignedInfo' was signed by a trusted source
-	signatureMethod := sig.SignedInfo.SignatureMethod.Algorithm
-	err = ctx.verifySignedInfo(sig, canonicalizer, signatureMethod, cert, decodedSignature)
+	// Any further references, such as to the qualifying properties of a
+	// XAdES signature, are dereferenced within the top-level element
+	for i := 1; i < len(references); i++ {
+		ref := &references[i]
+
+		target, err := dereference(el, ref.URI)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = ctx.verifyReference(target, sig, ref)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = ctx.verifySignatureValue(sig, cert)
 	if err != nil {
 		return nil, err
 	}
@@ -306,6 +521,20 @@ func (ctx *ValidationContext) validateSignature(el *etree.Element, sig *types.Si
 	return transformed, nil
 }
 
+// verifySignatureValue checks the SignatureValue of sig, made with cert,
+// against its SignedInfo.
+func (ctx *ValidationContext) verifySignatureValue(sig *types.Signature, cert *x509.Certificate) error {
+	// Decode the 'SignatureValue' so we can compare against it
+	decodedSignature, err := decodeBase64Binary(sig.SignatureValue.Data)
+	if err != nil {
+		return errors.New("Could not decode signature")
+	}
+
+	// Actually verify the 'SignedInfo' was signed by a trusted source
+	signatureMethod := sig.SignedInfo.SignatureMethod.Algorithm
+	return ctx.verifySignedInfo(sig, signatureMethod, cert, decodedSignature)
+}
+
 func contains(roots []*x509.Certificate, cert *x509.Certificate) bool {
 	for _, root := range roots {
 		if root.Equal(cert) {
@@ -316,7 +545,9 @@ func contains(roots []*x509.Certificate, cert *x509.Certificate) bool {
 }
 
 // findSignature searches for a Signature element referencing the passed root element.
+// Counter-signatures within it are passed over.
 func (ctx *ValidationContext) findSignature(el *etree.Element) (*types.Signature, error) {
+	root := el
 
 	var sig *types.Signature
 
@@ -326,12 +557,7 @@ func (ctx *ValidationContext) findSignature(el *etree.Element) (*types.Signature
 		found := false
 		err := etreeutils.NSFindChildrenIterateCtx(ctx, el, Namespace, SignedInfoTag,
 			func(ctx etreeutils.NSContext, signedInfo *etree.Element) error {
-				detachedSignedInfo, err := etreeutils.NSDetatch(ctx, signedInfo)
-				if err != nil {
-					return err
-				}
-
-				c14NMethod, err := etreeutils.NSFindOneChildCtx(ctx, detachedSignedInfo, Namespace, CanonicalizationMethodTag)
+				c14NMethod, err := etreeutils.NSFindOneChildCtx(ctx, signedInfo, Namespace, CanonicalizationMethodTag)
 				if err != nil {
 					return err
 				}
@@ -342,37 +568,10 @@ func (ctx *ValidationContext) findSignature(el *etree.Element) (*types.Signature
 
 				c14NAlgorithm := c14NMethod.SelectAttrValue(AlgorithmAttr, "")
 
-				var canonicalSignedInfo *etree.Element
-
-				switch AlgorithmID(c14NAlgorithm) {
-				case CanonicalXML10ExclusiveAlgorithmID:
-					err := etreeutils.TransformExcC14n(detachedSignedInfo, "")
-					if err != nil {
-						return err
-					}
-
-					// NOTE: TransformExcC14n transforms the element in-place,
-					// while canonicalPrep isn't meant to. Once we standardize
-					// this behavior we can drop this, as well as the adding and
-					// removing of elements below.
-					canonicalSignedInfo = detachedSignedInfo
-
-				case CanonicalXML11AlgorithmID:
-					canonicalSignedInfo = canonicalPrep(detachedSignedInfo, map[string]struct{}{})
-
-				case CanonicalXML10RecAlgorithmID:
-					canonicalSignedInfo = canonicalPrep(detachedSignedInfo, map[string]struct{}{})
-
-				case CanonicalXML10CommentAlgorithmID:
-					canonicalSignedInfo = canonicalPrep(detachedSignedInfo, map[string]struct{}{})
-
-				default:
+				if _, ok := lookupCanonicalizer(AlgorithmID(c14NAlgorithm)); !ok {
 					return fmt.Errorf("invalid CanonicalizationMethod on Signature: %s", c14NAlgorithm)
 				}
 
-				el.RemoveChild(signedInfo)
-				el.AddChild(canonicalSignedInfo)
-
 				found = true
 
 				return etreeutils.ErrTraversalHalted
@@ -392,6 +591,18 @@ func (ctx *ValidationContext) findSignature(el *etree.Element) (*types.Signature
 			return err
 		}
 
+		err = bindTransformElements(ctx, el, _sig)
+		if err != nil {
+			return err
+		}
+
+		if el != root {
+			counterSignature, err := isCounterSignature(el, _sig)
+			if err != nil || counterSignature {
+				return err
+			}
+		}
+
 		sig = _sig
 		return nil
 	})
@@ -407,39 +618,104 @@ func (ctx *ValidationContext) findSignature(el *etree.Element) (*types.Signature
 	return sig, nil
 }
 
-func (ctx *ValidationContext) verifyCertificate(sig *types.Signature) (*x509.Certificate, error) {
-	now := ctx.Clock.Now()
+// bindTransformElements records the Transform elements of each Reference on
+// the corresponding unmarshaled types.Transform, so that transforms can read
+// parameters that are not modeled by the types package.
+func bindTransformElements(ctx etreeutils.NSContext, sigEl *etree.Element, sig *types.Signature) error {
+	signedInfo, err := etreeutils.NSFindOneChildCtx(ctx, sigEl, Namespace, SignedInfoTag)
+	if err != nil || signedInfo == nil || sig.SignedInfo == nil {
+		return err
+	}
 
-	roots, err := ctx.CertificateStore.Certificates()
+	sigCtx, err := ctx.SubContext(sigEl)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
-	var cert *x509.Certificate
+	return bindReferenceTransforms(sigCtx, signedInfo, sig.SignedInfo.References)
+}
 
-	if sig.KeyInfo != nil {
-		// If the Signature includes KeyInfo, extract the certificate from there
-		if len(sig.KeyInfo.X509Data.X509Certificates) == 0 || sig.KeyInfo.X509Data.X509Certificates[0].Data == "" {
-			return nil, errors.New("missing X509Certificate within KeyInfo")
+// bindReferenceTransforms records the Transform elements of the Reference
+// children of parent, a SignedInfo or Manifest within the namespace context
+// ctx, on references, which were unmarshaled from them.
+func bindReferenceTransforms(ctx etreeutils.NSContext, parent *etree.Element, references []types.Reference) error {
+	refIndex := 0
+	return etreeutils.NSFindChildrenIterateCtx(ctx, parent, Namespace, ReferenceTag,
+		func(ctx etreeutils.NSContext, reference *etree.Element) error {
+			if refIndex >= len(references) {
+				return etreeutils.ErrTraversalHalted
+			}
+			ref := &references[refIndex]
+			refIndex++
+
+			transforms, err := etreeutils.NSFindOneChildCtx(ctx, reference, Namespace, TransformsTag)
+			if err != nil || transforms == nil {
+				return err
+			}
+
+			refCtx, err := ctx.SubContext(reference)
+			if err != nil {
+				return err
+			}
+
+			transformIndex := 0
+			return etreeutils.NSFindChildrenIterateCtx(refCtx, transforms, Namespace, TransformTag,
+				func(ctx etreeutils.NSContext, transform *etree.Element) error {
+					if transformIndex >= len(ref.Transforms.Transforms) {
+						return etreeutils.ErrTraversalHalted
+					}
+					ref.Transforms.Transforms[transformIndex].SetUnderlyingElement(transform)
+					transformIndex++
+					return nil
+				})
+		})
+}
+
+// validationTime returns the time at which signer certificates must be
+// valid.
+func (ctx *ValidationContext) validationTime() time.Time {
+	if !ctx.ValidationTime.IsZero() {
+		return ctx.ValidationTime
+	}
+	return ctx.Clock.Now()
+}
+
+func (ctx *ValidationContext) verifyCertificate(sig *types.Signature, store X509CertificateStore) (*x509.Certificate, error) {
+	now := ctx.validationTime()
+
+	var roots []*x509.Certificate
+	if store != nil {
+		var err error
+		roots, err = store.Certificates()
+		if err != nil {
+			return nil, err
 		}
+	} else if ctx.Trust == nil {
+		return nil, errors.New("Validation requires a CertificateStore or a Trust callback")
+	}
+
+	cert, err := signerCertificate(sig, roots)
+	if err != nil {
+		return nil, err
+	}
 
-		certData, err := base64.StdEncoding.DecodeString(
-			whiteSpace.ReplaceAllString(sig.KeyInfo.X509Data.X509Certificates[0].Data, ""))
+	if ctx.Trust != nil {
+		chain, err := keyInfoChain(sig.KeyInfo)
 		if err != nil {
-			return nil, errors.New("Failed to parse certificate")
+			return nil, err
 		}
 
-		cert, err = x509.ParseCertificate(certData)
+		err = ctx.Trust(cert, chain, sig.KeyInfo)
 		if err != nil {
 			return nil, err
 		}
-	} else {
-		// If the Signature doesn't have KeyInfo, Use the root certificate if there is only one
-		if len(roots) == 1 {
-			cert = roots[0]
-		} else {
-			return nil, errors.New("Missing x509 Element")
+
+		err = ctx.SignerConstraints.check(cert)
+		if err != nil {
+			return nil, err
 		}
+
+		return cert, nil
 	}
 
 	// Verify that the certificate is one we trust
@@ -447,28 +723,128 @@ func (ctx *ValidationContext) verifyCertificate(sig *types.Signature) (*x509.Cer
 		return nil, errors.New("Could not verify certificate against trusted certs")
 	}
 
-	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+	if now.Before(cert.NotBefore.Add(-ctx.ClockSkew)) || now.After(cert.NotAfter.Add(ctx.ClockSkew)) {
 		return nil, errors.New("Cert is not valid at this time")
 	}
 
+	err = ctx.SignerConstraints.check(cert)
+	if err != nil {
+		return nil, err
+	}
+
 	return cert, nil
 }
 
+// signerCertificate returns the certificate sig was made with: the first of
+// its KeyInfo or, without a KeyInfo, the only one of roots.
+func signerCertificate(sig *types.Signature, roots []*x509.Certificate) (*x509.Certificate, error) {
+	if sig.KeyInfo != nil {
+		// If the Signature includes KeyInfo, extract the certificate from there
+		if len(sig.KeyInfo.X509Data.X509Certificates) == 0 || sig.KeyInfo.X509Data.X509Certificates[0].Data == "" {
+			return nil, errors.New("missing X509Certificate within KeyInfo")
+		}
+
+		certData, err := decodeBase64Binary(sig.KeyInfo.X509Data.X509Certificates[0].Data)
+		if err != nil {
+			return nil, errors.New("Failed to parse certificate")
+		}
+
+		return x509.ParseCertificate(certData)
+	}
+
+	// If the Signature doesn't have KeyInfo, Use the root certificate if there is only one
+	if len(roots) == 1 {
+		return roots[0], nil
+	}
+
+	return nil, errors.New("Missing x509 Element")
+}
+
+// keyInfoChain parses the certificates of keyInfo following the first, which
+// is the one the signature was made with.
+func keyInfoChain(keyInfo *types.KeyInfo) ([]*x509.Certificate, error) {
+	if keyInfo == nil || len(keyInfo.X509Data.X509Certificates) < 2 {
+		return nil, nil
+	}
+
+	var chain []*x509.Certificate
+	for _, encoded := range keyInfo.X509Data.X509Certificates[1:] {
+		certData, err := decodeBase64Binary(encoded.Data)
+		if err != nil {
+			return nil, errors.New("Failed to parse certificate")
+		}
+
+		cert, err := x509.ParseCertificate(certData)
+		if err != nil {
+			return nil, err
+		}
+
+		chain = append(chain, cert)
+	}
+
+	return chain, nil
+}
+
 // Validate verifies that the passed element contains a valid enveloped signature
 // matching a currently-valid certificate in the context's CertificateStore.
+// It returns the signed content, and fails with ErrOctetStreamReference if
+// that content is not XML.
 func (ctx *ValidationContext) Validate(el *etree.Element) (*etree.Element, error) {
-	// Make a copy of the element to avoid mutating the one we were passed.
-	el = el.Copy()
+	transformed, err := ctx.ValidateData(el)
+	if err != nil {
+		return nil, err
+	}
 
-	sig, err := ctx.findSignature(el)
+	if transformed.IsOctetStream() {
+		return nil, ErrOctetStreamReference
+	}
+
+	return transformed.NodeSet.Root, nil
+}
+
+// ValidateData is like Validate, but returns the signed content as it was
+// digested, which is an octet stream for References whose transforms end in
+// one, such as a base64 transform.
+func (ctx *ValidationContext) ValidateData(el *etree.Element) (*TransformData, error) {
+	el, sig, store, err := ctx.findValidationSignature(el)
 	if err != nil {
 		return nil, err
 	}
 
-	cert, err := ctx.verifyCertificate(sig)
+	cert, err := ctx.verifyCertificate(sig, store)
 	if err != nil {
 		return nil, err
 	}
 
 	return ctx.validateSignature(el, sig, cert)
 }
+
+// findValidationSignature checks el against the limits and finds its
+// signature within a copy of el, which it returns along with the certificate
+// store to verify the signature against.
+func (ctx *ValidationContext) findValidationSignature(el *etree.Element) (*etree.Element, *types.Signature, X509CertificateStore, error) {
+	err := ctx.limits().checkDocument(el)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	store, err := ctx.certificateStore(el)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// Make a copy of the element to avoid mutating the one we were passed.
+	el = el.Copy()
+
+	sig, err := ctx.findSignature(el)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	err = ctx.limits().checkSignature(sig)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	return el, sig, store, nil
+}
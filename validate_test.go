@@ -4,11 +4,14 @@ import (
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/beevik/etree"
 	"github.com/stretchr/testify/require"
+	"gitlab.com/moolekkari/goxmldsig/types"
 )
 
 const canonicalResponse = `
@@ -171,7 +174,7 @@ func TestTransform(t *testing.T) {
 	require.IsType(t, &c14N10ExclusiveCanonicalizer{}, canonicalizer)
 
 	doc = etree.NewDocument()
-	doc.SetRoot(transformed)
+	doc.SetRoot(transformed.NodeSet.Root)
 
 	_, err = doc.WriteToString()
 	require.NoError(t, err)
@@ -197,7 +200,7 @@ func TestValidateKYCSignatureRef(t *testing.T) {
 	require.IsType(t, &c14N10RecCanonicalizer{}, canonicalizer)
 
 	doc = etree.NewDocument()
-	doc.SetRoot(transformed)
+	doc.SetRoot(transformed.NodeSet.Root)
 
 	str, err := doc.WriteToString()
 	fmt.Println(str)
@@ -232,3 +235,169 @@ func TestValidateWithEmptySignatureReference(t *testing.T) {
 	require.NoError(t, err)
 	require.NotEmpty(t, el)
 }
+
+func TestValidateTrustFunc(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	signed := signDocumentForTest(t, NewDefaultSigningContext(ks), `<Root><Child/></Root>`).Root()
+
+	// The KeyInfo is not signed, so the chain can be extended.
+	x509Data := signed.FindElement("//" + X509DataTag)
+	intermediate := x509Data.CreateElement(X509CertificateTag)
+	intermediate.Space = x509Data.Space
+	intermediate.SetText(x509Data.SelectElement(X509CertificateTag).Text())
+
+	var trusted *x509.Certificate
+	var trustedChain []*x509.Certificate
+
+	// No CertificateStore is needed when the Trust callback decides.
+	vc := NewDefaultValidationContext(nil)
+	vc.Trust = func(cert *x509.Certificate, chain []*x509.Certificate, keyInfo *types.KeyInfo) error {
+		require.NotNil(t, keyInfo)
+		trusted, trustedChain = cert, chain
+		return nil
+	}
+
+	_, err = vc.Validate(signed)
+	require.NoError(t, err)
+	require.Equal(t, cert, trusted)
+	require.Len(t, trustedChain, 1)
+	require.Equal(t, cert, trustedChain[0])
+
+	vc.Trust = func(*x509.Certificate, []*x509.Certificate, *types.KeyInfo) error {
+		return errors.New("untrusted signer")
+	}
+
+	_, err = vc.Validate(signed)
+	require.EqualError(t, err, "untrusted signer")
+}
+
+func TestValidateCertificateValidityTime(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	signed := signDocumentForTest(t, NewDefaultSigningContext(ks), `<Root><Child/></Root>`).Root()
+
+	vc := NewDefaultValidationContext(certificateStoreForTest(t, ks))
+
+	// A verifier whose clock runs behind the signer's.
+	vc.Clock = NewFakeClockAt(cert.NotBefore.Add(-time.Minute))
+	_, err = vc.Validate(signed)
+	require.EqualError(t, err, "Cert is not valid at this time")
+
+	vc.ClockSkew = 2 * time.Minute
+	_, err = vc.Validate(signed)
+	require.NoError(t, err)
+
+	// An archived document, verified after its certificate has expired.
+	vc.ClockSkew = 0
+	vc.Clock = NewFakeClockAt(cert.NotAfter.Add(24 * time.Hour))
+	_, err = vc.Validate(signed)
+	require.EqualError(t, err, "Cert is not valid at this time")
+
+	vc.ValidationTime = cert.NotAfter.Add(-24 * time.Hour)
+	_, err = vc.Validate(signed)
+	require.NoError(t, err)
+}
+
+func TestMapPathToElement(t *testing.T) {
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(`<Root><A>text</A><B><C/><D><E/></D></B></Root>`))
+
+	root := doc.Root()
+	e := root.FindElement("./B/D/E")
+
+	path := mapPathToElement(root, e)
+	require.Equal(t, []int{1, 1, 0}, path)
+	require.Equal(t, e, elementAtPath(root, path))
+
+	// Elements outside of the tree have no path, rather than that of the
+	// first child searched.
+	require.Nil(t, mapPathToElement(root, etree.NewElement("E")))
+}
+
+func TestTransformNestedEnvelopedSignature(t *testing.T) {
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(`<Root><A>kept</A><B/></Root>`))
+
+	signed, err := NewDefaultSigningContext(RandomKeyStoreForTest()).SignEnveloped(doc.Root())
+	require.NoError(t, err)
+
+	// Move the Signature below the second child, so that it is not found
+	// within the first.
+	sig := signed.FindElement("./ds:Signature")
+	signed.RemoveChild(sig)
+	signed.FindElement("./B").AddChild(sig)
+
+	vc := NewDefaultValidationContext(nil)
+
+	parsed, err := vc.findSignature(signed)
+	require.NoError(t, err)
+
+	transformed, _, err := vc.transform(signed, parsed, &parsed.SignedInfo.References[0])
+	require.NoError(t, err)
+
+	root := transformed.NodeSet.Root
+	require.NotNil(t, root.FindElement("./A"))
+	require.NotNil(t, root.FindElement("./B"))
+	require.Nil(t, root.FindElement("//ds:Signature"))
+}
+
+func TestVerifySignedInfoCanonicalizationMethod(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	ctx := NewDefaultSigningContext(ks)
+	ctx.Canonicalizer = MakeC14N10ExclusiveCanonicalizerWithPrefixList("x")
+
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(`<r:Root xmlns:r="urn:r" xmlns:x="urn:x"><x:Item>1</x:Item></r:Root>`))
+
+	signed, err := ctx.SignEnveloped(doc.Root())
+	require.NoError(t, err)
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+
+	// The SignedInfo is canonicalized as its CanonicalizationMethod says,
+	// PrefixList included, with the namespaces in scope at it.
+	_, err = vc.Validate(signed)
+	require.NoError(t, err)
+
+	tampered := signed.Copy()
+	canonicalizationMethod := tampered.FindElement("./ds:Signature/ds:SignedInfo/ds:CanonicalizationMethod")
+	canonicalizationMethod.RemoveChild(canonicalizationMethod.SelectElement(InclusiveNamespacesTag))
+
+	_, err = vc.Validate(tampered)
+	require.EqualError(t, err, "crypto/rsa: verification error")
+}
+
+func TestFindSignatureLeavesDocumentUnchanged(t *testing.T) {
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(`<Root><A>1</A></Root>`))
+
+	signed, err := NewDefaultSigningContext(RandomKeyStoreForTest()).SignEnveloped(doc.Root())
+	require.NoError(t, err)
+
+	doc.SetRoot(signed)
+	before, err := doc.WriteToString()
+	require.NoError(t, err)
+
+	sig, err := NewDefaultValidationContext(nil).findSignature(signed)
+	require.NoError(t, err)
+
+	// The SignedInfo is neither replaced by a canonicalized copy nor moved
+	// after the other children of the Signature.
+	children := sig.UnderlyingElement().ChildElements()
+	require.Equal(t, SignedInfoTag, children[0].Tag)
+	require.Equal(t, signed.FindElement("./ds:Signature/ds:SignedInfo"), children[0])
+
+	after, err := doc.WriteToString()
+	require.NoError(t, err)
+	require.Equal(t, before, after)
+}
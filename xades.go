@@ -0,0 +1,882 @@
+package dsig
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/beevik/etree"
+	"gitlab.com/moolekkari/goxmldsig/etreeutils"
+	"gitlab.com/moolekkari/goxmldsig/types"
+)
+
+const (
+	// XAdESNamespace is the namespace of XAdES qualifying properties.
+	XAdESNamespace = "http://uri.etsi.org/01903/v1.3.2#"
+
+	// DefaultXAdESPrefix is the namespace prefix of XAdES elements.
+	DefaultXAdESPrefix = "xades"
+
+	// XAdESSignedPropertiesType is the Type of the Reference to the
+	// SignedProperties of a XAdES signature.
+	XAdESSignedPropertiesType = "http://uri.etsi.org/01903#SignedProperties"
+
+	// XAdES141Namespace is the namespace of the XAdES 1.4.1 properties,
+	// such as ArchiveTimeStamp, and DefaultXAdES141Prefix their prefix.
+	XAdES141Namespace     = "http://uri.etsi.org/01903/v1.4.1#"
+	DefaultXAdES141Prefix = "xades141"
+)
+
+// XAdES tags
+const (
+	QualifyingPropertiesTag      = "QualifyingProperties"
+	SignedPropertiesTag          = "SignedProperties"
+	SignedSignaturePropertiesTag = "SignedSignatureProperties"
+	SigningTimeTag               = "SigningTime"
+	SigningCertificateV2Tag      = "SigningCertificateV2"
+	CertTag                      = "Cert"
+	CertDigestTag                = "CertDigest"
+	IssuerSerialV2Tag            = "IssuerSerialV2"
+	SignaturePolicyIdentifierTag = "SignaturePolicyIdentifier"
+	SignaturePolicyIDTag         = "SignaturePolicyId"
+	SigPolicyIDTag               = "SigPolicyId"
+	IdentifierTag                = "Identifier"
+	DescriptionTag               = "Description"
+	SigPolicyHashTag             = "SigPolicyHash"
+	SigPolicyQualifiersTag       = "SigPolicyQualifiers"
+	SigPolicyQualifierTag        = "SigPolicyQualifier"
+	SPURITag                     = "SPURI"
+
+	UnsignedPropertiesTag          = "UnsignedProperties"
+	UnsignedSignaturePropertiesTag = "UnsignedSignatureProperties"
+	SignatureTimeStampTag          = "SignatureTimeStamp"
+	EncapsulatedTimeStampTag       = "EncapsulatedTimeStamp"
+	CertificateValuesTag           = "CertificateValues"
+	EncapsulatedX509CertificateTag = "EncapsulatedX509Certificate"
+	RevocationValuesTag            = "RevocationValues"
+	CRLValuesTag                   = "CRLValues"
+	EncapsulatedCRLValueTag        = "EncapsulatedCRLValue"
+	OCSPValuesTag                  = "OCSPValues"
+	EncapsulatedOCSPValueTag       = "EncapsulatedOCSPValue"
+	ArchiveTimeStampTag            = "ArchiveTimeStamp"
+	CounterSignatureTag            = "CounterSignature"
+)
+
+// TargetAttr is the Target attribute of QualifyingProperties and
+// SignatureProperty elements.
+const TargetAttr = "Target"
+
+// xadesTimeFormat is the xs:dateTime format of SigningTime.
+const xadesTimeFormat = "2006-01-02T15:04:05Z"
+
+// ErrSigningCertificateMismatch indicates that the SigningCertificateV2
+// property of a XAdES signature does not identify the certificate the
+// signature was made with.
+var ErrSigningCertificateMismatch = errors.New("SigningCertificateV2 does not match the signer certificate")
+
+// XAdESSignaturePolicy identifies the signature policy a XAdES-EPES
+// signature is made under.
+type XAdESSignaturePolicy struct {
+	// Identifier is the OID, as a urn:oid: URN, or URI of the policy.
+	Identifier string
+
+	// Description optionally describes the policy.
+	Description string
+
+	// DigestMethod is the URI of the digest algorithm the policy document
+	// was digested with, and Digest is that digest.
+	DigestMethod string
+	Digest       []byte
+
+	// URI optionally locates the policy document.
+	URI string
+}
+
+// XAdESSigningContext signs documents with XAdES-BES signatures, or
+// XAdES-EPES signatures when a SignaturePolicy is set. The signatures carry
+// the signing time and signing certificate as signed properties, and are
+// made XAdES-T by a TimestampAuthority. AddValidationData and
+// AddArchiveTimestamp extend them to XAdES-LT and XAdES-LTA.
+type XAdESSigningContext struct {
+	*SigningContext
+
+	// XAdESPrefix is the namespace prefix of XAdES elements. It must not be
+	// empty.
+	XAdESPrefix string
+
+	// SignatureID is the Id of the Signature element, which the qualifying
+	// properties refer to. A random Id is used when empty.
+	SignatureID string
+
+	// SigningTime is the signing time claimed by the signature. The Clock's
+	// time is used when zero.
+	SigningTime time.Time
+	Clock       *Clock
+
+	// SignaturePolicy, if set, makes signatures XAdES-EPES.
+	SignaturePolicy *XAdESSignaturePolicy
+
+	// TimestampAuthority, if set, timestamps the SignatureValue of
+	// signatures as an unsigned SignatureTimeStamp property, making them
+	// XAdES-T. AddArchiveTimestamp requires it.
+	TimestampAuthority TimestampAuthority
+}
+
+// NewXAdESSigningContext creates a context for XAdES signing on top of ctx.
+func NewXAdESSigningContext(ctx *SigningContext) *XAdESSigningContext {
+	return &XAdESSigningContext{
+		SigningContext: ctx,
+		XAdESPrefix:    DefaultXAdESPrefix,
+	}
+}
+
+// ConstructSignature constructs a XAdES Signature for el, as
+// SigningContext.ConstructSignature does, with a Reference to its
+// SignedProperties in addition to the one to el.
+func (ctx *XAdESSigningContext) ConstructSignature(el *etree.Element, enveloped bool) (*etree.Element, error) {
+	return ctx.constructXAdESSignature(el, enveloped, &signatureContent{})
+}
+
+// constructXAdESSignature constructs a XAdES Signature for el, adding its Id
+// and qualifying properties to content.
+func (ctx *XAdESSigningContext) constructXAdESSignature(el *etree.Element, enveloped bool, content *signatureContent) (*etree.Element, error) {
+	if ctx.XAdESPrefix == "" {
+		return nil, errors.New("XAdES elements require a namespace prefix")
+	}
+
+	_, cert, err := ctx.signer()
+	if err != nil {
+		return nil, err
+	}
+
+	sigID := ctx.SignatureID
+	if sigID == "" {
+		sigID, err = randomID("xmldsig-")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	object, signedProperties, err := ctx.qualifyingPropertiesObject(sigID, cert)
+	if err != nil {
+		return nil, err
+	}
+
+	content.id = sigID
+	content.objects = []signedObject{{
+		object:        object,
+		target:        signedProperties,
+		referenceType: XAdESSignedPropertiesType,
+	}}
+
+	sig, err := ctx.constructSignature(el, enveloped, content)
+	if err != nil {
+		return nil, err
+	}
+
+	// The Id lets counter-signatures refer to the SignatureValue, and is
+	// set before it is timestamped.
+	signatureValue := sig.SelectElement(SignatureValueTag)
+	if signatureValue == nil {
+		return nil, errors.New("Missing SignatureValue")
+	}
+	signatureValue.CreateAttr(IDAttr, sigID+"-SignatureValue")
+
+	if ctx.TimestampAuthority != nil {
+		err = ctx.addSignatureTimestamp(sig, signedProperties.Parent())
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return sig, nil
+}
+
+// SignEnveloped signs el with an enveloped XAdES signature.
+func (ctx *XAdESSigningContext) SignEnveloped(el *etree.Element) (*etree.Element, error) {
+	sig, err := ctx.ConstructSignature(el, true)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := el.Copy()
+	ret.AddChild(sig)
+
+	return ret, nil
+}
+
+// qualifyingPropertiesObject builds the ds:Object holding the
+// QualifyingProperties of the Signature with Id sigID, and returns it along
+// with its SignedProperties.
+func (ctx *XAdESSigningContext) qualifyingPropertiesObject(sigID string, cert *x509.Certificate) (object, signedProperties *etree.Element, err error) {
+	object = &etree.Element{
+		Space: ctx.Prefix,
+		Tag:   ObjectTag,
+	}
+
+	qualifyingProperties := ctx.createXAdESElement(object, QualifyingPropertiesTag)
+	qualifyingProperties.CreateAttr("xmlns:"+ctx.XAdESPrefix, XAdESNamespace)
+	qualifyingProperties.CreateAttr(TargetAttr, "#"+sigID)
+
+	signedProperties = ctx.createXAdESElement(qualifyingProperties, SignedPropertiesTag)
+	signedProperties.CreateAttr(IDAttr, sigID+"-SignedProperties")
+
+	signedSignatureProperties := ctx.createXAdESElement(signedProperties, SignedSignaturePropertiesTag)
+
+	signingTime := ctx.SigningTime
+	if signingTime.IsZero() {
+		signingTime = ctx.Clock.Now()
+	}
+	ctx.createXAdESElement(signedSignatureProperties, SigningTimeTag).SetText(signingTime.UTC().Format(xadesTimeFormat))
+
+	err = ctx.createSigningCertificate(signedSignatureProperties, cert)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if ctx.SignaturePolicy != nil {
+		ctx.createSignaturePolicyIdentifier(signedSignatureProperties, ctx.SignaturePolicy)
+	}
+
+	return object, signedProperties, nil
+}
+
+func (ctx *XAdESSigningContext) createSigningCertificate(parent *etree.Element, cert *x509.Certificate) error {
+	digestAlgorithm, err := ctx.digestAlgorithm()
+	if err != nil {
+		return err
+	}
+
+	issuerSerial, err := marshalIssuerSerial(cert)
+	if err != nil {
+		return err
+	}
+
+	hash := digestAlgorithm.Hash.New()
+	hash.Write(cert.Raw)
+
+	signingCertificate := ctx.createXAdESElement(parent, SigningCertificateV2Tag)
+	certElement := ctx.createXAdESElement(signingCertificate, CertTag)
+
+	certDigest := ctx.createXAdESElement(certElement, CertDigestTag)
+	ctx.createNamespacedElement(certDigest, DigestMethodTag).CreateAttr(AlgorithmAttr, digestAlgorithm.URI)
+	ctx.createNamespacedElement(certDigest, DigestValueTag).SetText(ctx.encodeBase64(hash.Sum(nil)))
+
+	ctx.createXAdESElement(certElement, IssuerSerialV2Tag).SetText(ctx.encodeBase64(issuerSerial))
+
+	return nil
+}
+
+func (ctx *XAdESSigningContext) createSignaturePolicyIdentifier(parent *etree.Element, policy *XAdESSignaturePolicy) {
+	identifier := ctx.createXAdESElement(parent, SignaturePolicyIdentifierTag)
+	policyID := ctx.createXAdESElement(identifier, SignaturePolicyIDTag)
+
+	sigPolicyID := ctx.createXAdESElement(policyID, SigPolicyIDTag)
+	ctx.createXAdESElement(sigPolicyID, IdentifierTag).SetText(policy.Identifier)
+	if policy.Description != "" {
+		ctx.createXAdESElement(sigPolicyID, DescriptionTag).SetText(policy.Description)
+	}
+
+	policyHash := ctx.createXAdESElement(policyID, SigPolicyHashTag)
+	ctx.createNamespacedElement(policyHash, DigestMethodTag).CreateAttr(AlgorithmAttr, policy.DigestMethod)
+	ctx.createNamespacedElement(policyHash, DigestValueTag).SetText(ctx.encodeBase64(policy.Digest))
+
+	if policy.URI != "" {
+		qualifiers := ctx.createXAdESElement(policyID, SigPolicyQualifiersTag)
+		qualifier := ctx.createXAdESElement(qualifiers, SigPolicyQualifierTag)
+		ctx.createXAdESElement(qualifier, SPURITag).SetText(policy.URI)
+	}
+}
+
+// addSignatureTimestamp timestamps the SignatureValue of sig, adding the
+// token to the unsigned properties of qualifyingProperties.
+func (ctx *XAdESSigningContext) addSignatureTimestamp(sig, qualifyingProperties *etree.Element) error {
+	signatureValue := sig.SelectElement(SignatureValueTag)
+	if signatureValue == nil {
+		return errors.New("Missing SignatureValue")
+	}
+
+	// Exclusive canonicalization keeps the digest independent of the
+	// namespaces in scope where the signature is placed.
+	canonicalizer := MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+
+	canonical, err := canonicalizeDetached(canonicalizer, signatureValue)
+	if err != nil {
+		return err
+	}
+
+	timestamp := &etree.Element{Space: ctx.XAdESPrefix, Tag: SignatureTimeStampTag}
+	err = ctx.createTimestamp(timestamp, canonicalizer, canonical)
+	if err != nil {
+		return err
+	}
+
+	ctx.unsignedSignatureProperties(qualifyingProperties).AddChild(timestamp)
+
+	return nil
+}
+
+// createTimestamp timestamps data, the canonical form of the timestamped
+// elements under canonicalizer, and adds the token to timestamp, a XAdES
+// time-stamp property.
+func (ctx *XAdESSigningContext) createTimestamp(timestamp *etree.Element, canonicalizer Canonicalizer, data []byte) error {
+	digestAlgorithm, err := ctx.digestAlgorithm()
+	if err != nil {
+		return err
+	}
+
+	hash := digestAlgorithm.Hash.New()
+	hash.Write(data)
+
+	token, err := ctx.TimestampAuthority.Timestamp(digestAlgorithm.Hash, hash.Sum(nil))
+	if err != nil {
+		return err
+	}
+
+	err = ctx.createCanonicalizationElement(timestamp, CanonicalizationMethodTag, canonicalizer)
+	if err != nil {
+		return err
+	}
+
+	ctx.createXAdESElement(timestamp, EncapsulatedTimeStampTag).SetText(ctx.encodeBase64(token))
+
+	return nil
+}
+
+// unsignedSignatureProperties returns the UnsignedSignatureProperties of
+// qualifyingProperties, creating them if need be.
+func (ctx *XAdESSigningContext) unsignedSignatureProperties(qualifyingProperties *etree.Element) *etree.Element {
+	unsignedProperties := qualifyingProperties.SelectElement(UnsignedPropertiesTag)
+	if unsignedProperties == nil {
+		unsignedProperties = ctx.createXAdESElement(qualifyingProperties, UnsignedPropertiesTag)
+	}
+
+	unsignedSignatureProperties := unsignedProperties.SelectElement(UnsignedSignaturePropertiesTag)
+	if unsignedSignatureProperties == nil {
+		unsignedSignatureProperties = ctx.createXAdESElement(unsignedProperties, UnsignedSignaturePropertiesTag)
+	}
+
+	return unsignedSignatureProperties
+}
+
+// canonicalizeDetached canonicalizes el with all namespaces in scope at it
+// declared.
+func canonicalizeDetached(canonicalizer Canonicalizer, el *etree.Element) ([]byte, error) {
+	nsCtx, err := etreeutils.NSBuildParentContext(el)
+	if err != nil {
+		return nil, err
+	}
+
+	detached, err := etreeutils.NSDetatch(nsCtx, el)
+	if err != nil {
+		return nil, err
+	}
+
+	return canonicalizer.Canonicalize(detached)
+}
+
+func (ctx *XAdESSigningContext) createXAdESElement(el *etree.Element, tag string) *etree.Element {
+	child := el.CreateElement(tag)
+	child.Space = ctx.XAdESPrefix
+	return child
+}
+
+// XAdESProperties are the qualifying properties of a verified XAdES
+// signature.
+type XAdESProperties struct {
+	// SigningTime is the signing time claimed by the signer, which is zero
+	// if the signature claims none.
+	SigningTime time.Time
+
+	// SignaturePolicy is the policy of a XAdES-EPES signature, or nil. The
+	// policy document is not checked against its Digest.
+	SignaturePolicy *XAdESSignaturePolicy
+
+	// SignatureTimestamps are the verified timestamps of the SignatureValue
+	// of a XAdES-T signature.
+	SignatureTimestamps []*Timestamp
+
+	// ValidationData is the validation data embedded in a XAdES-LT
+	// signature, which is empty if it has none. It is only verified by
+	// LongTermValidation.
+	ValidationData *ValidationData
+
+	// ArchiveTimestamps are the verified timestamps of a XAdES-LTA
+	// signature, in the order they were added.
+	ArchiveTimestamps []*Timestamp
+}
+
+// ValidateXAdES verifies the XAdES signature of el as ValidateData does,
+// and returns its signed properties along with the signed content. It also
+// checks that:
+//
+//   - a Reference of the SignedInfo signs the SignedProperties, held in a
+//     ds:Object of the Signature,
+//   - SigningCertificateV2 identifies the certificate the signature was made
+//     with, by digest and, if present, issuer and serial number, and
+//   - any SignatureTimeStamp is a valid timestamp of the SignatureValue by
+//     a TSA of the TimestampCertificateStore,
+//   - any ArchiveTimeStamp is a valid timestamp, by such a TSA, of the
+//     signed content, the Signature and the unsigned properties preceding
+//     it, and
+//   - the certificate was valid at the earliest SignatureTimeStamp, rather
+//     than at the Clock's time, unless ValidationTime is set.
+//
+// The SigningTime is only the signer's claim, so it must not be later than
+// the Clock's time, nor than a SignatureTimeStamp, and does not change the
+// time the certificate is validated at: a signature without a
+// SignatureTimeStamp is validated at the Clock's time, unless ValidationTime
+// is set.
+//
+// With LongTermValidation, the certificate is verified offline against the
+// validation data embedded by AddValidationData: it must chain to a root of
+// the CertificateStore through the embedded certificates or those of the
+// KeyInfo, and the embedded CRLs or OCSP responses must show that neither
+// it nor the certificates of its chain, or of the chains of the
+// SignatureTimeStamp TSAs, had been revoked at the time it was validated
+// as of, or the timestamp time.
+//
+// Any counter-signatures of the signature are verified as
+// ValidateWithCounterSignatures does, their certificates as of the Clock's
+// time or ValidationTime. The References of any Manifests are checked as
+// ValidateWithManifests does, and any SignatureProperties are returned as
+// ValidateWithSignatureProperties does.
+func (ctx *ValidationContext) ValidateXAdES(el *etree.Element) (*ValidationResult, error) {
+	el, sig, store, err := ctx.findValidationSignature(el)
+	if err != nil {
+		return nil, err
+	}
+
+	signedProperties, qualifyingProperties, err := findSignedProperties(el, sig)
+	if err != nil {
+		return nil, err
+	}
+
+	properties, err := parseXAdESProperties(signedProperties)
+	if err != nil {
+		return nil, err
+	}
+
+	unsignedProperties, err := findUnsignedProperties(qualifyingProperties)
+	if err != nil {
+		return nil, err
+	}
+
+	properties.ValidationData, err = parseValidationData(unsignedProperties)
+	if err != nil {
+		return nil, err
+	}
+
+	intermediates := properties.ValidationData.Certificates
+
+	properties.SignatureTimestamps, err = ctx.verifySignatureTimestamps(sig, unsignedProperties, intermediates)
+	if err != nil {
+		return nil, err
+	}
+
+	signingTime := properties.SigningTime
+	if !signingTime.IsZero() && signingTime.After(ctx.Clock.Now().Add(ctx.ClockSkew)) {
+		return nil, errors.New("SigningTime is in the future")
+	}
+
+	// Validate as of the time a TSA vouches the signature existed, with a
+	// copy of the context, leaving ctx unchanged.
+	validationCtx := *ctx
+
+	for _, timestamp := range properties.SignatureTimestamps {
+		if !signingTime.IsZero() && signingTime.After(timestamp.Time.Add(ctx.ClockSkew)) {
+			return nil, errors.New("SigningTime is later than a SignatureTimeStamp")
+		}
+
+		if ctx.ValidationTime.IsZero() && (validationCtx.ValidationTime.IsZero() || timestamp.Time.Before(validationCtx.ValidationTime)) {
+			validationCtx.ValidationTime = timestamp.Time
+		}
+	}
+
+	var cert *x509.Certificate
+	if ctx.LongTermValidation {
+		cert, err = validationCtx.verifyLongTermCertificate(sig, store, properties.ValidationData, properties.SignatureTimestamps)
+	} else {
+		cert, err = validationCtx.verifyCertificate(sig, store)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	transformed, err := validationCtx.validateSignature(el, sig, cert)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ctx.checkSigningCertificate(signedProperties, cert)
+	if err != nil {
+		return nil, err
+	}
+
+	properties.ArchiveTimestamps, err = ctx.verifyArchiveTimestamps(el, sig, qualifyingProperties, intermediates)
+	if err != nil {
+		return nil, err
+	}
+
+	counterSignatures, err := ctx.verifyCounterSignatures(el, sig, store)
+	if err != nil {
+		return nil, err
+	}
+
+	manifests, err := ctx.verifyManifests(el, sig)
+	if err != nil {
+		return nil, err
+	}
+
+	signatureProperties, err := ctx.verifySignatureProperties(el, sig)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ValidationResult{
+		Content:             transformed,
+		Certificate:         cert,
+		XAdES:               properties,
+		CounterSignatures:   counterSignatures,
+		Manifests:           manifests,
+		SignatureProperties: signatureProperties,
+	}, nil
+}
+
+// findSignedProperties returns the SignedProperties which a Reference of
+// sig, a Signature within el, refers to by its XAdESSignedPropertiesType,
+// along with the QualifyingProperties element holding them. They must
+// target sig from a ds:Object of sig, so that properties signed for one
+// signature can not be passed off as those of another.
+func findSignedProperties(el *etree.Element, sig *types.Signature) (*types.SignedProperties, *etree.Element, error) {
+	var ref *types.Reference
+	for i := range sig.SignedInfo.References {
+		if sig.SignedInfo.References[i].Type != XAdESSignedPropertiesType {
+			continue
+		}
+
+		if ref != nil {
+			return nil, nil, errors.New("Multiple SignedProperties References")
+		}
+		ref = &sig.SignedInfo.References[i]
+	}
+
+	if ref == nil {
+		return nil, nil, errors.New("Missing SignedProperties Reference")
+	}
+
+	signedPropertiesElement, err := dereference(el, ref.URI)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sigElement := sig.UnderlyingElement()
+	sigID := sigElement.SelectAttrValue(IDAttr, "")
+
+	qualifyingProperties := signedPropertiesElement.Parent()
+	if qualifyingProperties == nil || qualifyingProperties.Tag != QualifyingPropertiesTag ||
+		qualifyingProperties.Parent() == nil || qualifyingProperties.Parent().Parent() != sigElement {
+		return nil, nil, errors.New("SignedProperties are not qualifying properties of the Signature")
+	}
+
+	qualifyingCtx, err := etreeutils.NSBuildParentContext(qualifyingProperties)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	qualifyingCtx, err = qualifyingCtx.SubContext(qualifyingProperties)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	namespace, err := qualifyingCtx.LookupPrefix(qualifyingProperties.Space)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if namespace != XAdESNamespace {
+		return nil, nil, errors.New("SignedProperties are not qualifying properties of the Signature")
+	}
+
+	if sigID == "" || qualifyingProperties.SelectAttrValue(TargetAttr, "") != "#"+sigID {
+		return nil, nil, errors.New("QualifyingProperties do not target the Signature")
+	}
+
+	nsCtx, err := qualifyingCtx.SubContext(signedPropertiesElement)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	signedProperties := &types.SignedProperties{}
+	err = etreeutils.NSUnmarshalElement(nsCtx, signedPropertiesElement, signedProperties)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if signedProperties.SignedSignatureProperties == nil {
+		return nil, nil, errors.New("Missing SignedSignatureProperties")
+	}
+
+	return signedProperties, qualifyingProperties, nil
+}
+
+func parseXAdESProperties(signedProperties *types.SignedProperties) (*XAdESProperties, error) {
+	signatureProperties := signedProperties.SignedSignatureProperties
+	properties := &XAdESProperties{}
+
+	if signingTime := strings.TrimSpace(signatureProperties.SigningTime); signingTime != "" {
+		t, err := time.Parse(time.RFC3339Nano, signingTime)
+		if err != nil {
+			return nil, errors.New("Invalid SigningTime: " + signingTime)
+		}
+		properties.SigningTime = t
+	}
+
+	identifier := signatureProperties.SignaturePolicyIdentifier
+	if identifier != nil && identifier.SignaturePolicyID != nil {
+		policyID := identifier.SignaturePolicyID
+
+		digest, err := decodeBase64Binary(policyID.SigPolicyHash.DigestValue)
+		if err != nil {
+			return nil, err
+		}
+
+		policy := &XAdESSignaturePolicy{
+			Identifier:   strings.TrimSpace(policyID.SigPolicyID.Identifier),
+			Description:  policyID.SigPolicyID.Description,
+			DigestMethod: policyID.SigPolicyHash.DigestMethod.Algorithm,
+			Digest:       digest,
+		}
+
+		if policyID.SigPolicyQualifiers != nil {
+			for _, qualifier := range policyID.SigPolicyQualifiers.SigPolicyQualifiers {
+				if uri := strings.TrimSpace(qualifier.SPURI); uri != "" {
+					policy.URI = uri
+					break
+				}
+			}
+		}
+
+		properties.SignaturePolicy = policy
+	}
+
+	return properties, nil
+}
+
+// verifySignatureTimestamps verifies the SignatureTimeStamps among
+// unsignedProperties, which belong to sig. The chains of their TSAs may go
+// through intermediates.
+func (ctx *ValidationContext) verifySignatureTimestamps(sig *types.Signature, unsignedProperties *types.UnsignedProperties, intermediates []*x509.Certificate) ([]*Timestamp, error) {
+	if unsignedProperties == nil || unsignedProperties.UnsignedSignatureProperties == nil {
+		return nil, nil
+	}
+
+	signatureTimestamps := unsignedProperties.UnsignedSignatureProperties.SignatureTimeStamps
+	if len(signatureTimestamps) == 0 {
+		return nil, nil
+	}
+
+	sigElement := sig.UnderlyingElement()
+
+	nsCtx, err := etreeutils.NSBuildParentContext(sigElement)
+	if err != nil {
+		return nil, err
+	}
+
+	signatureValue, err := etreeutils.NSFindOneChildCtx(nsCtx, sigElement, Namespace, SignatureValueTag)
+	if err != nil {
+		return nil, err
+	}
+
+	if signatureValue == nil {
+		return nil, errors.New("Missing SignatureValue")
+	}
+
+	timestamped := func(canonicalizer Canonicalizer) ([]byte, error) {
+		return canonicalizeDetached(canonicalizer, signatureValue)
+	}
+
+	var timestamps []*Timestamp
+	for i := range signatureTimestamps {
+		verified, err := ctx.verifyXAdESTimestamp(&signatureTimestamps[i], timestamped, intermediates)
+		if err != nil {
+			return nil, err
+		}
+
+		timestamps = append(timestamps, verified...)
+	}
+
+	return timestamps, nil
+}
+
+// verifyXAdESTimestamp verifies the tokens of the time-stamp property
+// timestamp, which timestamp the data returned by timestamped for its
+// canonicalization method.
+func (ctx *ValidationContext) verifyXAdESTimestamp(timestamp *types.XAdESTimeStamp, timestamped func(Canonicalizer) ([]byte, error), intermediates []*x509.Certificate) ([]*Timestamp, error) {
+	if ctx.TimestampCertificateStore == nil {
+		return nil, errors.New("Timestamps can not be verified without a TimestampCertificateStore")
+	}
+
+	roots, err := ctx.TimestampCertificateStore.Certificates()
+	if err != nil {
+		return nil, err
+	}
+
+	// Inclusive canonicalization is the default of XAdES time-stamps.
+	method := timestamp.CanonicalizationMethod
+	if method == nil {
+		method = &types.CanonicalizationMethod{Algorithm: string(CanonicalXML10RecAlgorithmID)}
+	}
+
+	canonicalizer, err := NewCanonicalizer(canonicalizationMethodTransform(method))
+	if err != nil {
+		return nil, err
+	}
+
+	canonical, err := timestamped(canonicalizer)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(timestamp.EncapsulatedTimeStamps) == 0 {
+		return nil, errors.New("Missing EncapsulatedTimeStamp")
+	}
+
+	var timestamps []*Timestamp
+	for _, encapsulated := range timestamp.EncapsulatedTimeStamps {
+		token, err := decodeBase64Binary(encapsulated.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		verified, err := verifyTimestampToken(token, canonical, roots, intermediates, ctx.AlgorithmPolicy)
+		if err != nil {
+			return nil, err
+		}
+
+		timestamps = append(timestamps, verified)
+	}
+
+	return timestamps, nil
+}
+
+// findUnsignedProperties returns the UnsignedProperties of
+// qualifyingProperties, or nil if there are none.
+func findUnsignedProperties(qualifyingProperties *etree.Element) (*types.UnsignedProperties, error) {
+	nsCtx, err := etreeutils.NSBuildParentContext(qualifyingProperties)
+	if err != nil {
+		return nil, err
+	}
+
+	nsCtx, err = nsCtx.SubContext(qualifyingProperties)
+	if err != nil {
+		return nil, err
+	}
+
+	unsignedPropertiesElement, err := etreeutils.NSFindOneChildCtx(nsCtx, qualifyingProperties, XAdESNamespace, UnsignedPropertiesTag)
+	if err != nil || unsignedPropertiesElement == nil {
+		return nil, err
+	}
+
+	unsignedProperties := &types.UnsignedProperties{}
+	err = etreeutils.NSUnmarshalElement(nsCtx, unsignedPropertiesElement, unsignedProperties)
+	if err != nil {
+		return nil, err
+	}
+
+	return unsignedProperties, nil
+}
+
+// checkSigningCertificate checks that the first Cert of the
+// SigningCertificateV2 property, which identifies the signer's own
+// certificate, identifies cert.
+func (ctx *ValidationContext) checkSigningCertificate(signedProperties *types.SignedProperties, cert *x509.Certificate) error {
+	signingCertificate := signedProperties.SignedSignatureProperties.SigningCertificateV2
+	if signingCertificate == nil || len(signingCertificate.Certs) == 0 {
+		return errors.New("Missing SigningCertificateV2")
+	}
+
+	certRef := signingCertificate.Certs[0]
+
+	digestAlgorithm, err := ctx.AlgorithmPolicy.digestAlgorithm(certRef.CertDigest.DigestMethod.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	digest, err := decodeBase64Binary(certRef.CertDigest.DigestValue)
+	if err != nil {
+		return err
+	}
+
+	hash := digestAlgorithm.Hash.New()
+	hash.Write(cert.Raw)
+
+	if !bytes.Equal(hash.Sum(nil), digest) {
+		return ErrSigningCertificateMismatch
+	}
+
+	if strings.TrimSpace(certRef.IssuerSerialV2) == "" {
+		return nil
+	}
+
+	issuerSerialDER, err := decodeBase64Binary(certRef.IssuerSerialV2)
+	if err != nil {
+		return err
+	}
+
+	var decoded issuerSerial
+	rest, err := asn1.Unmarshal(issuerSerialDER, &decoded)
+	if err != nil || len(rest) != 0 {
+		return errors.New("Invalid IssuerSerialV2")
+	}
+
+	if decoded.SerialNumber == nil || decoded.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		return ErrSigningCertificateMismatch
+	}
+
+	for _, name := range decoded.Issuer {
+		// directoryName [4] Name
+		if name.Class == asn1.ClassContextSpecific && name.Tag == 4 && bytes.Equal(name.Bytes, cert.RawIssuer) {
+			return nil
+		}
+	}
+
+	return ErrSigningCertificateMismatch
+}
+
+// issuerSerial is the IssuerSerial structure of RFC 5035, which identifies a
+// certificate by its issuer's name and its serial number.
+type issuerSerial struct {
+	Issuer       []asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// marshalIssuerSerial returns the DER encoding of the IssuerSerial of cert,
+// the content of a IssuerSerialV2 element.
+func marshalIssuerSerial(cert *x509.Certificate) ([]byte, error) {
+	return asn1.Marshal(issuerSerial{
+		Issuer: []asn1.RawValue{{
+			// directoryName [4] Name
+			Class:      asn1.ClassContextSpecific,
+			Tag:        4,
+			IsCompound: true,
+			Bytes:      cert.RawIssuer,
+		}},
+		SerialNumber: cert.SerialNumber,
+	})
+}
+
+// randomID returns a random XML ID starting with prefix.
+func randomID(prefix string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,378 @@
+package dsig
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+
+	"github.com/beevik/etree"
+	"gitlab.com/moolekkari/goxmldsig/etreeutils"
+	"gitlab.com/moolekkari/goxmldsig/types"
+)
+
+// AddValidationData embeds data in the XAdES signature within el, in place,
+// as CertificateValues and RevocationValues unsigned properties, making it
+// XAdES-LT. The properties follow any existing ones, so that archive
+// timestamps of those remain valid.
+func (ctx *XAdESSigningContext) AddValidationData(el *etree.Element, data *ValidationData) error {
+	extensionCtx, _, unsignedSignatureProperties, err := ctx.extensionContext(el)
+	if err != nil {
+		return err
+	}
+
+	if len(data.Certificates) > 0 {
+		certificateValues := extensionCtx.createXAdESElement(unsignedSignatureProperties, CertificateValuesTag)
+		for _, cert := range data.Certificates {
+			extensionCtx.createXAdESElement(certificateValues, EncapsulatedX509CertificateTag).SetText(extensionCtx.encodeBase64(cert.Raw))
+		}
+	}
+
+	if len(data.CRLs) == 0 && len(data.OCSPResponses) == 0 {
+		return nil
+	}
+
+	revocationValues := extensionCtx.createXAdESElement(unsignedSignatureProperties, RevocationValuesTag)
+
+	if len(data.CRLs) > 0 {
+		crlValues := extensionCtx.createXAdESElement(revocationValues, CRLValuesTag)
+		for _, crl := range data.CRLs {
+			extensionCtx.createXAdESElement(crlValues, EncapsulatedCRLValueTag).SetText(extensionCtx.encodeBase64(crl))
+		}
+	}
+
+	if len(data.OCSPResponses) > 0 {
+		ocspValues := extensionCtx.createXAdESElement(revocationValues, OCSPValuesTag)
+		for _, response := range data.OCSPResponses {
+			extensionCtx.createXAdESElement(ocspValues, EncapsulatedOCSPValueTag).SetText(extensionCtx.encodeBase64(response))
+		}
+	}
+
+	return nil
+}
+
+// AddArchiveTimestamp timestamps the XAdES signature within el, in place,
+// along with its signed content and unsigned properties, as an
+// ArchiveTimeStamp property, making it XAdES-LTA. Adding another archive
+// timestamp before the algorithms or TSA certificate of the last one
+// become unreliable renews the protection of the signature and of the
+// validation data added since.
+func (ctx *XAdESSigningContext) AddArchiveTimestamp(el *etree.Element) error {
+	if ctx.TimestampAuthority == nil {
+		return errors.New("Archive timestamps require a TimestampAuthority")
+	}
+
+	extensionCtx, sig, unsignedSignatureProperties, err := ctx.extensionContext(el)
+	if err != nil {
+		return err
+	}
+
+	canonicalizer := MakeC14N10ExclusiveCanonicalizerWithPrefixList("")
+
+	data, err := (&ValidationContext{}).archiveTimestampData(el, sig, unsignedSignatureProperties, nil, canonicalizer)
+	if err != nil {
+		return err
+	}
+
+	timestamp := &etree.Element{Space: DefaultXAdES141Prefix, Tag: ArchiveTimeStampTag}
+	timestamp.CreateAttr("xmlns:"+DefaultXAdES141Prefix, XAdES141Namespace)
+
+	err = extensionCtx.createTimestamp(timestamp, canonicalizer, data)
+	if err != nil {
+		return err
+	}
+
+	unsignedSignatureProperties.AddChild(timestamp)
+
+	return nil
+}
+
+// extensionContext finds the XAdES signature within el, and returns a copy
+// of ctx which creates elements with the prefixes the signature uses, along
+// with the signature and its UnsignedSignatureProperties, which are created
+// if need be.
+func (ctx *XAdESSigningContext) extensionContext(el *etree.Element) (*XAdESSigningContext, *types.Signature, *etree.Element, error) {
+	sig, err := (&ValidationContext{}).findSignature(el)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	_, qualifyingProperties, err := findSignedProperties(el, sig)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if qualifyingProperties.Space == "" {
+		return nil, nil, nil, errors.New("XAdES elements require a namespace prefix")
+	}
+
+	signingCtx := *ctx.SigningContext
+	signingCtx.Prefix = sig.UnderlyingElement().Space
+
+	extensionCtx := *ctx
+	extensionCtx.SigningContext = &signingCtx
+	extensionCtx.XAdESPrefix = qualifyingProperties.Space
+
+	return &extensionCtx, sig, extensionCtx.unsignedSignatureProperties(qualifyingProperties), nil
+}
+
+// archiveTimestampData returns the data an ArchiveTimeStamp of sig, a XAdES
+// signature within el, timestamps, in the canonical form of canonicalizer.
+// That is the content of each Reference, the SignedInfo, SignatureValue and
+// KeyInfo, the unsigned signature properties preceding the timestamp,
+// before, or all of them if it is nil, and the other ds:Objects of the
+// signature, in that order.
+func (ctx *ValidationContext) archiveTimestampData(el *etree.Element, sig *types.Signature, unsignedSignatureProperties, before *etree.Element, canonicalizer Canonicalizer) ([]byte, error) {
+	var buf bytes.Buffer
+
+	for i := range sig.SignedInfo.References {
+		ref := &sig.SignedInfo.References[i]
+
+		// As in validateSignature, the first Reference applies to el.
+		target := el
+		if i > 0 {
+			var err error
+			target, err = dereference(el, ref.URI)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		transformed, _, err := ctx.transform(target, sig, ref)
+		if err != nil {
+			return nil, err
+		}
+
+		err = writeCanonicalData(&buf, canonicalizer, transformed)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sigElement := sig.UnderlyingElement()
+
+	nsCtx, err := etreeutils.NSBuildParentContext(sigElement)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, tag := range []string{SignedInfoTag, SignatureValueTag, KeyInfoTag} {
+		child, err := etreeutils.NSFindOneChildCtx(nsCtx, sigElement, Namespace, tag)
+		if err != nil {
+			return nil, err
+		}
+
+		if child == nil {
+			if tag == KeyInfoTag {
+				continue
+			}
+			return nil, errors.New("Missing " + tag)
+		}
+
+		err = writeCanonicalElement(&buf, canonicalizer, child)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, property := range unsignedSignatureProperties.ChildElements() {
+		if property == before {
+			break
+		}
+
+		err = writeCanonicalElement(&buf, canonicalizer, property)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// UnsignedSignatureProperties/UnsignedProperties/QualifyingProperties/Object
+	qualifyingPropertiesObject := unsignedSignatureProperties.Parent().Parent().Parent()
+
+	err = etreeutils.NSFindChildrenIterateCtx(nsCtx, sigElement, Namespace, ObjectTag, func(_ etreeutils.NSContext, object *etree.Element) error {
+		if object == qualifyingPropertiesObject {
+			return nil
+		}
+		return writeCanonicalElement(&buf, canonicalizer, object)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeCanonicalElement appends the canonical form of el, with all
+// namespaces in scope at it declared, to buf.
+func writeCanonicalElement(buf *bytes.Buffer, canonicalizer Canonicalizer, el *etree.Element) error {
+	canonical, err := canonicalizeDetached(canonicalizer, el)
+	if err != nil {
+		return err
+	}
+
+	buf.Write(canonical)
+	return nil
+}
+
+// verifyArchiveTimestamps verifies the ArchiveTimeStamps among the unsigned
+// properties of qualifyingProperties, which belong to sig, a XAdES
+// signature within el. The chains of their TSAs may go through
+// intermediates.
+func (ctx *ValidationContext) verifyArchiveTimestamps(el *etree.Element, sig *types.Signature, qualifyingProperties *etree.Element, intermediates []*x509.Certificate) ([]*Timestamp, error) {
+	nsCtx, err := etreeutils.NSBuildParentContext(qualifyingProperties)
+	if err != nil {
+		return nil, err
+	}
+
+	unsignedProperties, err := etreeutils.NSFindOneChildCtx(nsCtx, qualifyingProperties, XAdESNamespace, UnsignedPropertiesTag)
+	if err != nil || unsignedProperties == nil {
+		return nil, err
+	}
+
+	nsCtx, err = nsCtx.SubContext(qualifyingProperties)
+	if err != nil {
+		return nil, err
+	}
+
+	unsignedSignatureProperties, err := etreeutils.NSFindOneChildCtx(nsCtx, unsignedProperties, XAdESNamespace, UnsignedSignaturePropertiesTag)
+	if err != nil || unsignedSignatureProperties == nil {
+		return nil, err
+	}
+
+	nsCtx, err = nsCtx.SubContext(unsignedProperties)
+	if err != nil {
+		return nil, err
+	}
+
+	var timestamps []*Timestamp
+
+	// Each ArchiveTimeStamp covers the properties preceding it, so they are
+	// found in document order.
+	err = etreeutils.NSFindChildrenIterateCtx(nsCtx, unsignedSignatureProperties, XAdES141Namespace, ArchiveTimeStampTag, func(propertyCtx etreeutils.NSContext, property *etree.Element) error {
+		archiveTimestamp := &types.XAdESTimeStamp{}
+		err := etreeutils.NSUnmarshalElement(propertyCtx, property, archiveTimestamp)
+		if err != nil {
+			return err
+		}
+
+		timestamped := func(canonicalizer Canonicalizer) ([]byte, error) {
+			return ctx.archiveTimestampData(el, sig, unsignedSignatureProperties, property, canonicalizer)
+		}
+
+		verified, err := ctx.verifyXAdESTimestamp(archiveTimestamp, timestamped, intermediates)
+		if err != nil {
+			return err
+		}
+
+		timestamps = append(timestamps, verified...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return timestamps, nil
+}
+
+// parseValidationData returns the validation data embedded in the
+// CertificateValues and RevocationValues among unsignedProperties, which may
+// be nil.
+func parseValidationData(unsignedProperties *types.UnsignedProperties) (*ValidationData, error) {
+	data := &ValidationData{}
+	if unsignedProperties == nil || unsignedProperties.UnsignedSignatureProperties == nil {
+		return data, nil
+	}
+
+	properties := unsignedProperties.UnsignedSignatureProperties
+
+	for _, certificateValues := range properties.CertificateValues {
+		for _, encapsulated := range certificateValues.EncapsulatedX509Certificates {
+			der, err := decodeBase64Binary(encapsulated.Data)
+			if err != nil {
+				return nil, err
+			}
+
+			cert, err := x509.ParseCertificate(der)
+			if err != nil {
+				return nil, err
+			}
+
+			data.Certificates = append(data.Certificates, cert)
+		}
+	}
+
+	for _, revocationValues := range properties.RevocationValues {
+		if revocationValues.CRLValues != nil {
+			for _, encapsulated := range revocationValues.CRLValues.EncapsulatedCRLValues {
+				der, err := decodeBase64Binary(encapsulated.Data)
+				if err != nil {
+					return nil, err
+				}
+				data.CRLs = append(data.CRLs, der)
+			}
+		}
+
+		if revocationValues.OCSPValues != nil {
+			for _, encapsulated := range revocationValues.OCSPValues.EncapsulatedOCSPValues {
+				der, err := decodeBase64Binary(encapsulated.Data)
+				if err != nil {
+					return nil, err
+				}
+				data.OCSPResponses = append(data.OCSPResponses, der)
+			}
+		}
+	}
+
+	return data, nil
+}
+
+// verifyLongTermCertificate verifies the certificate sig was made with
+// offline, as of the validation time. It must chain to a root of store
+// through the certificates of data or of the KeyInfo, and data must show
+// that no certificate of its chain had been revoked by then, nor any of the
+// chains of the TSAs of timestamps by their times.
+func (ctx *ValidationContext) verifyLongTermCertificate(sig *types.Signature, store X509CertificateStore, data *ValidationData, timestamps []*Timestamp) (*x509.Certificate, error) {
+	if store == nil {
+		return nil, errors.New("Long-term validation requires a CertificateStore")
+	}
+
+	roots, err := store.Certificates()
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := signerCertificate(sig, roots)
+	if err != nil {
+		return nil, err
+	}
+
+	keyInfoCerts, err := keyInfoChain(sig.KeyInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	now := ctx.validationTime()
+
+	chain, err := verifyChain(cert, roots, append(keyInfoCerts, data.Certificates...), now, x509.ExtKeyUsageAny)
+	if err != nil {
+		return nil, err
+	}
+
+	err = data.checkRevocation(chain, now)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, timestamp := range timestamps {
+		err = data.checkRevocation(timestamp.Chain, timestamp.Time)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = ctx.SignerConstraints.check(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	return cert, nil
+}
@@ -0,0 +1,163 @@
+package dsig
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/stretchr/testify/require"
+)
+
+func TestXAdESLongTermValidation(t *testing.T) {
+	ca := newTestCA(t)
+	ks := ca.issue(t, 2, "Signer")
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	signingTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	ctx := NewXAdESSigningContext(NewDefaultSigningContext(ks))
+	ctx.SigningTime = signingTime
+
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(`<Invoice xmlns="urn:invoice"><Total>100</Total></Invoice>`))
+
+	signed, err := ctx.SignEnveloped(doc.Root())
+	require.NoError(t, err)
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{ca.cert},
+	})
+	vc.LongTermValidation = true
+
+	// Without a SignatureTimeStamp, only the caller can vouch for the time
+	// of signing.
+	vc.ValidationTime = signingTime
+
+	_, err = vc.ValidateXAdES(signed)
+	require.Equal(t, ErrMissingRevocationData{Subject: "CN=Signer"}, err)
+
+	crl := ca.crl(t, signingTime.Add(time.Minute), nil)
+	require.NoError(t, ctx.AddValidationData(signed, &ValidationData{
+		Certificates: []*x509.Certificate{ca.cert},
+		CRLs:         [][]byte{crl},
+	}))
+
+	certificateValues := signed.FindElement("./ds:Signature/ds:Object/xades:QualifyingProperties/xades:UnsignedProperties/xades:UnsignedSignatureProperties/xades:CertificateValues")
+	require.NotNil(t, certificateValues)
+	require.Len(t, certificateValues.SelectElements(EncapsulatedX509CertificateTag), 1)
+
+	// The embedded data survives serialization, and is all that is needed.
+	doc = etree.NewDocument()
+	doc.SetRoot(signed.Copy())
+
+	serialized, err := doc.WriteToString()
+	require.NoError(t, err)
+
+	doc = etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(serialized))
+
+	result, err := vc.ValidateXAdES(doc.Root())
+	require.NoError(t, err)
+	require.True(t, cert.Equal(result.Certificate))
+	require.Len(t, result.XAdES.ValidationData.Certificates, 1)
+	require.Equal(t, [][]byte{crl}, result.XAdES.ValidationData.CRLs)
+
+	// The signer certificate is not itself trusted.
+	vc.LongTermValidation = false
+	_, err = vc.ValidateXAdES(signed)
+	require.EqualError(t, err, "Could not verify certificate against trusted certs")
+
+	// A revocation before the signing time invalidates the signature, even
+	// if other data does not show it.
+	vc.LongTermValidation = true
+	revokedAt := signingTime.Add(-time.Minute)
+	require.NoError(t, ctx.AddValidationData(signed, &ValidationData{
+		OCSPResponses: [][]byte{ca.ocspResponse(t, cert, signingTime.Add(2*time.Minute), revokedAt)},
+	}))
+
+	_, err = vc.ValidateXAdES(signed)
+	require.Equal(t, ErrCertificateRevoked{Subject: "CN=Signer", RevocationTime: revokedAt.UTC()}, err)
+}
+
+func TestXAdESArchiveTimestamp(t *testing.T) {
+	ca := newTestCA(t)
+	ks := ca.issue(t, 2, "Signer")
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	tsa, err := newFakeTimestampAuthority()
+	require.NoError(t, err)
+	tsa.Clock = NewFakeClockAt(time.Now().Add(-time.Hour).UTC().Truncate(time.Second))
+
+	ctx := NewXAdESSigningContext(NewDefaultSigningContext(ks))
+	ctx.SigningTime = tsa.Clock.Now().Add(-time.Minute)
+
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(`<Invoice xmlns="urn:invoice"><Total>100</Total></Invoice>`))
+
+	signed, err := ctx.SignEnveloped(doc.Root())
+	require.NoError(t, err)
+
+	require.EqualError(t, ctx.AddArchiveTimestamp(signed), "Archive timestamps require a TimestampAuthority")
+
+	ctx.TimestampAuthority = tsa
+
+	require.NoError(t, ctx.AddValidationData(signed, &ValidationData{
+		Certificates: []*x509.Certificate{ca.cert},
+		CRLs:         [][]byte{ca.crl(t, tsa.Clock.Now(), nil)},
+	}))
+	require.NoError(t, ctx.AddArchiveTimestamp(signed))
+
+	archiveTimestamp := signed.FindElement("./ds:Signature/ds:Object/xades:QualifyingProperties/xades:UnsignedProperties/xades:UnsignedSignatureProperties/xades141:ArchiveTimeStamp")
+	require.NotNil(t, archiveTimestamp)
+	require.Equal(t, XAdES141Namespace, archiveTimestamp.SelectAttrValue("xmlns:"+DefaultXAdES141Prefix, ""))
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{ca.cert},
+	})
+	vc.TimestampCertificateStore = &MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{tsa.Certificate()},
+	}
+	vc.LongTermValidation = true
+	vc.ValidationTime = ctx.SigningTime
+
+	result, err := vc.ValidateXAdES(signed)
+	require.NoError(t, err)
+	require.True(t, cert.Equal(result.Certificate))
+	require.Len(t, result.XAdES.ArchiveTimestamps, 1)
+
+	// Renewal adds a timestamp over the previous one, leaving it valid.
+	tsa.Clock = NewFakeClockAt(tsa.Clock.Now().Add(30 * time.Minute))
+	require.NoError(t, ctx.AddValidationData(signed, &ValidationData{
+		CRLs: [][]byte{ca.crl(t, tsa.Clock.Now(), nil)},
+	}))
+	require.NoError(t, ctx.AddArchiveTimestamp(signed))
+
+	result, err = vc.ValidateXAdES(signed)
+	require.NoError(t, err)
+	require.Len(t, result.XAdES.ArchiveTimestamps, 2)
+	require.True(t, result.XAdES.ArchiveTimestamps[0].Time.Before(result.XAdES.ArchiveTimestamps[1].Time))
+
+	// The timestamps cover the embedded validation data.
+	tampered := signed.Copy()
+	tampered.FindElement("//xades:EncapsulatedCRLValue").SetText(
+		tampered.FindElements("//xades:EncapsulatedCRLValue")[1].Text())
+
+	_, err = vc.ValidateXAdES(tampered)
+	require.EqualError(t, err, "Timestamp token does not match the timestamped data")
+
+	// And the signed content, whose References no longer match either.
+	tampered = signed.Copy()
+	tampered.FindElement("./Total").SetText("1000")
+
+	sig, err := vc.findSignature(tampered)
+	require.NoError(t, err)
+
+	_, qualifyingProperties, err := findSignedProperties(tampered, sig)
+	require.NoError(t, err)
+
+	_, err = vc.verifyArchiveTimestamps(tampered, sig, qualifyingProperties, nil)
+	require.EqualError(t, err, "Timestamp token does not match the timestamped data")
+}
@@ -0,0 +1,319 @@
+package dsig
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/beevik/etree"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/moolekkari/goxmldsig/etreeutils"
+)
+
+func TestXAdESSignEnveloped(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	signingTime := time.Date(2026, 3, 1, 10, 30, 0, 0, time.FixedZone("IST", 5*3600+1800))
+
+	ctx := NewXAdESSigningContext(NewDefaultSigningContext(ks))
+	ctx.SignatureID = "sig-1"
+	ctx.SigningTime = signingTime
+	ctx.SignaturePolicy = &XAdESSignaturePolicy{
+		Identifier:   "urn:oid:2.16.356.100.1.1",
+		Description:  "Invoice signing policy",
+		DigestMethod: SHA256DigestMethod,
+		Digest:       []byte("0123456789abcdef0123456789abcdef"),
+		URI:          "https://example.com/policy.pdf",
+	}
+
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(`<Invoice xmlns="urn:invoice"><Total>100</Total></Invoice>`))
+
+	signed, err := ctx.SignEnveloped(doc.Root())
+	require.NoError(t, err)
+
+	sig := signed.FindElement("./ds:Signature")
+	require.NotNil(t, sig)
+	require.Equal(t, "sig-1", sig.SelectAttrValue(IDAttr, ""))
+
+	qualifyingProperties := sig.FindElement("./ds:Object/xades:QualifyingProperties")
+	require.NotNil(t, qualifyingProperties)
+	require.Equal(t, "#sig-1", qualifyingProperties.SelectAttrValue(TargetAttr, ""))
+
+	properties := qualifyingProperties.FindElement("./xades:SignedProperties/xades:SignedSignatureProperties")
+	require.NotNil(t, properties)
+	require.Equal(t, "2026-03-01T05:00:00Z", properties.FindElement("./xades:SigningTime").Text())
+	require.Equal(t, "urn:oid:2.16.356.100.1.1",
+		properties.FindElement("./xades:SignaturePolicyIdentifier/xades:SignaturePolicyId/xades:SigPolicyId/xades:Identifier").Text())
+
+	// The signing certificate is identified by its digest and issuer-serial.
+	certElement := properties.FindElement("./xades:SigningCertificateV2/xades:Cert")
+	certDigest := sha256.Sum256(cert.Raw)
+	require.Equal(t, base64.StdEncoding.EncodeToString(certDigest[:]),
+		certElement.FindElement("./xades:CertDigest/ds:DigestValue").Text())
+
+	issuerSerialDER, err := base64.StdEncoding.DecodeString(certElement.FindElement("./xades:IssuerSerialV2").Text())
+	require.NoError(t, err)
+
+	var decoded issuerSerial
+	_, err = asn1.Unmarshal(issuerSerialDER, &decoded)
+	require.NoError(t, err)
+	require.Equal(t, cert.RawIssuer, decoded.Issuer[0].Bytes)
+	require.Equal(t, 0, cert.SerialNumber.Cmp(decoded.SerialNumber))
+
+	// The SignedProperties are signed through a second, typed, Reference.
+	references := sig.FindElements("./ds:SignedInfo/ds:Reference")
+	require.Len(t, references, 2)
+	require.Equal(t, "#sig-1-SignedProperties", references[1].SelectAttrValue(URIAttr, ""))
+	require.Equal(t, XAdESSignedPropertiesType, references[1].SelectAttrValue(TypeAttr, ""))
+
+	signedProperties := qualifyingProperties.FindElement("./xades:SignedProperties")
+	nsCtx, err := etreeutils.NSBuildParentContext(signedProperties)
+	require.NoError(t, err)
+	detached, err := etreeutils.NSDetatch(nsCtx, signedProperties)
+	require.NoError(t, err)
+	canonical, err := MakeC14N11Canonicalizer().Canonicalize(detached)
+	require.NoError(t, err)
+
+	digest := sha256.Sum256(canonical)
+	require.Equal(t, base64.StdEncoding.EncodeToString(digest[:]), references[1].FindElement("./ds:DigestValue").Text())
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+	_, err = vc.Validate(signed)
+	require.NoError(t, err)
+}
+
+func TestXAdESRandomSignatureID(t *testing.T) {
+	ctx := NewXAdESSigningContext(NewDefaultSigningContext(RandomKeyStoreForTest()))
+
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(`<Root/>`))
+
+	first, err := ctx.ConstructSignature(doc.Root(), true)
+	require.NoError(t, err)
+	second, err := ctx.ConstructSignature(doc.Root(), true)
+	require.NoError(t, err)
+
+	require.NotEqual(t, first.SelectAttrValue(IDAttr, ""), second.SelectAttrValue(IDAttr, ""))
+
+	ctx.XAdESPrefix = ""
+	_, err = ctx.ConstructSignature(doc.Root(), true)
+	require.Error(t, err)
+}
+
+func TestValidateXAdES(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	ctx := NewXAdESSigningContext(NewDefaultSigningContext(ks))
+	ctx.SignatureID = "sig-1"
+	ctx.SigningTime = cert.NotBefore.Add(time.Minute).Truncate(time.Second)
+	ctx.SignaturePolicy = &XAdESSignaturePolicy{
+		Identifier:   "urn:oid:2.16.356.100.1.1",
+		DigestMethod: SHA256DigestMethod,
+		Digest:       []byte("0123456789abcdef0123456789abcdef"),
+		URI:          "https://example.com/policy.pdf",
+	}
+
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(`<Invoice xmlns="urn:invoice"><Total>100</Total></Invoice>`))
+
+	signed, err := ctx.SignEnveloped(doc.Root())
+	require.NoError(t, err)
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+
+	result, err := vc.ValidateXAdES(signed)
+	require.NoError(t, err)
+	require.True(t, cert.Equal(result.Certificate))
+	require.Equal(t, "Invoice", result.Content.NodeSet.Root.Tag)
+	require.True(t, ctx.SigningTime.Equal(result.XAdES.SigningTime))
+	require.Equal(t, ctx.SignaturePolicy, result.XAdES.SignaturePolicy)
+
+	// Once the signer certificate has expired, the SigningTime, being only
+	// the signer's claim, does not make the signature valid.
+	vc.Clock = NewFakeClockAt(cert.NotAfter.Add(24 * time.Hour))
+	_, err = vc.ValidateXAdES(signed)
+	require.EqualError(t, err, "Cert is not valid at this time")
+
+	// Unless the caller chooses to validate as of that time.
+	vc.ValidationTime = ctx.SigningTime
+	_, err = vc.ValidateXAdES(signed)
+	require.NoError(t, err)
+	vc.ValidationTime = time.Time{}
+
+	// A SigningTime later than the Clock's time is rejected.
+	vc.Clock = NewFakeClockAt(ctx.SigningTime.Add(-time.Minute))
+	_, err = vc.ValidateXAdES(signed)
+	require.EqualError(t, err, "SigningTime is in the future")
+	vc.Clock = nil
+
+	// The SignedProperties are covered by the signature.
+	tampered := signed.Copy()
+	tampered.FindElement("//xades:SigningTime").SetText("2000-01-01T00:00:00Z")
+	_, err = vc.ValidateXAdES(tampered)
+	require.Error(t, err)
+
+	// A plain signature has no SignedProperties.
+	plain, err := NewDefaultSigningContext(ks).SignEnveloped(doc.Root())
+	require.NoError(t, err)
+	_, err = vc.ValidateXAdES(plain)
+	require.EqualError(t, err, "Missing SignedProperties Reference")
+
+	// The QualifyingProperties must be those of XAdES, not merely named so.
+	tampered = signed.Copy()
+	qualifyingProperties := tampered.FindElement("./ds:Signature/ds:Object/xades:QualifyingProperties")
+	qualifyingProperties.CreateAttr("xmlns:other", "urn:other")
+	qualifyingProperties.Space = "other"
+	_, err = vc.ValidateXAdES(tampered)
+	require.EqualError(t, err, "SignedProperties are not qualifying properties of the Signature")
+}
+
+func TestValidateXAdESSigningCertificateMismatch(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	_, otherCert, err := RandomKeyStoreForTest().GetKeyPair()
+	require.NoError(t, err)
+
+	ctx := NewXAdESSigningContext(NewDefaultSigningContext(ks))
+
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(`<Root><Child/></Root>`))
+
+	// Sign properties naming a certificate other than the signer's.
+	object, signedProperties, err := ctx.qualifyingPropertiesObject("sig-1", otherCert)
+	require.NoError(t, err)
+
+	sig, err := ctx.constructSignature(doc.Root(), true, &signatureContent{
+		id: "sig-1",
+		objects: []signedObject{{
+			object:        object,
+			target:        signedProperties,
+			referenceType: XAdESSignedPropertiesType,
+		}},
+	})
+	require.NoError(t, err)
+
+	signed := doc.Root().Copy()
+	signed.AddChild(sig)
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+
+	// The signature itself is valid.
+	_, err = vc.Validate(signed)
+	require.NoError(t, err)
+
+	_, err = vc.ValidateXAdES(signed)
+	require.Equal(t, ErrSigningCertificateMismatch, err)
+}
+
+func TestValidateStreamXAdES(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(`<Root><Child/></Root>`))
+
+	signed, err := NewXAdESSigningContext(NewDefaultSigningContext(ks)).SignEnveloped(doc.Root())
+	require.NoError(t, err)
+
+	signedDoc := etree.NewDocument()
+	signedDoc.SetRoot(signed)
+	data, err := signedDoc.WriteToString()
+	require.NoError(t, err)
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+
+	_, err = vc.ValidateStream(strings.NewReader(data))
+	require.NoError(t, err)
+
+	tampered := strings.Replace(data, "<xades:SigningTime>", "<xades:SigningTime>1", 1)
+	_, err = vc.ValidateStream(bytes.NewReader([]byte(tampered)))
+	require.Error(t, err)
+}
+
+func TestXAdESSignatureTimestamp(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	tsa, err := newFakeTimestampAuthority()
+	require.NoError(t, err)
+	server := httptest.NewServer(tsa)
+	defer server.Close()
+
+	ctx := NewXAdESSigningContext(NewDefaultSigningContext(ks))
+	ctx.TimestampAuthority = &HTTPTimestampAuthority{URL: server.URL}
+
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(`<Invoice xmlns="urn:invoice"><Total>100</Total></Invoice>`))
+
+	signed, err := ctx.SignEnveloped(doc.Root())
+	require.NoError(t, err)
+
+	timestampElement := signed.FindElement("./ds:Signature/ds:Object/xades:QualifyingProperties/xades:UnsignedProperties/xades:UnsignedSignatureProperties/xades:SignatureTimeStamp")
+	require.NotNil(t, timestampElement)
+	require.Equal(t, string(CanonicalXML10ExclusiveAlgorithmID),
+		timestampElement.FindElement("./ds:CanonicalizationMethod").SelectAttrValue(AlgorithmAttr, ""))
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+
+	_, err = vc.ValidateXAdES(signed)
+	require.EqualError(t, err, "Timestamps can not be verified without a TimestampCertificateStore")
+
+	vc.TimestampCertificateStore = &MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{tsa.Certificate()},
+	}
+
+	// The signer certificate has expired by the Clock's time, but was valid
+	// when the signature was timestamped.
+	vc.Clock = NewFakeClockAt(cert.NotAfter.Add(24 * time.Hour))
+
+	result, err := vc.ValidateXAdES(signed)
+	require.NoError(t, err)
+	require.Len(t, result.XAdES.SignatureTimestamps, 1)
+	require.True(t, tsa.Certificate().Equal(result.XAdES.SignatureTimestamps[0].Certificate))
+	require.False(t, result.XAdES.SignatureTimestamps[0].Time.Before(result.XAdES.SigningTime))
+
+	// A token over other data is rejected.
+	otherDigest := sha256.Sum256([]byte("other data"))
+	otherToken, err := tsa.Timestamp(crypto.SHA256, otherDigest[:])
+	require.NoError(t, err)
+
+	tampered := signed.Copy()
+	tampered.FindElement("//xades:EncapsulatedTimeStamp").SetText(base64.StdEncoding.EncodeToString(otherToken))
+	_, err = vc.ValidateXAdES(tampered)
+	require.EqualError(t, err, "Timestamp token does not match the timestamped data")
+
+	// The signer can not claim to have signed after the timestamp.
+	ctx.SigningTime = time.Now().Add(time.Hour)
+	signed, err = ctx.SignEnveloped(doc.Root())
+	require.NoError(t, err)
+
+	vc.Clock = NewFakeClockAt(time.Now().Add(2 * time.Hour))
+	_, err = vc.ValidateXAdES(signed)
+	require.EqualError(t, err, "SigningTime is later than a SignatureTimeStamp")
+}
@@ -1,7 +1,5 @@
 package dsig
 
-import "crypto"
-
 const (
 	// DefaultPrefix for generating signs
 	DefaultPrefix = "ds"
@@ -28,6 +26,11 @@ const (
 	X509SubjectNameTag        = "X509SubjectName"
 	X509CertificateTag        = "X509Certificate"
 	InclusiveNamespacesTag    = "InclusiveNamespaces"
+	XPathTag                  = "XPath"
+	ObjectTag                 = "Object"
+	ManifestTag               = "Manifest"
+	SignaturePropertiesTag    = "SignatureProperties"
+	SignaturePropertyTag      = "SignatureProperty"
 )
 
 const (
@@ -37,6 +40,19 @@ const (
 	URIAttr = "URI"
 	// PrefixListAttr is PrefixListAttribute.
 	PrefixListAttr = "PrefixList"
+	// FilterAttr is the Filter attribute of an XPath Filter 2.0 XPath element.
+	FilterAttr = "Filter"
+	// IDAttr is the Id attribute of Signature, Object and Reference elements.
+	IDAttr = "Id"
+	// TypeAttr is the Type attribute of a Reference.
+	TypeAttr = "Type"
+)
+
+// XPath Filter 2.0 set operations.
+const (
+	XPathFilterIntersect = "intersect"
+	XPathFilterSubtract  = "subtract"
+	XPathFilterUnion     = "union"
 )
 
 // AlgorithmID as custom type out of string.
@@ -49,44 +65,75 @@ func (id AlgorithmID) String() string {
 const (
 	// RSASHA1SignatureMethod is a signature method.
 	RSASHA1SignatureMethod = "http://www.w3.org/2000/09/xmldsig#rsa-sha1"
+	// RSASHA224SignatureMethod is a signature method
+	RSASHA224SignatureMethod = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha224"
 	// RSASHA256SignatureMethod is a signature method
 	RSASHA256SignatureMethod = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	// RSASHA384SignatureMethod is a signature method
+	RSASHA384SignatureMethod = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha384"
 	// RSASHA512SignatureMethod is a signature method
 	RSASHA512SignatureMethod = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+
+	// SHA3_256RSAMGF1SignatureMethod is an RSASSA-PSS signature method.
+	SHA3_256RSAMGF1SignatureMethod = "http://www.w3.org/2007/05/xmldsig-more#sha3-256-rsa-MGF1"
+	// SHA3_384RSAMGF1SignatureMethod is an RSASSA-PSS signature method.
+	SHA3_384RSAMGF1SignatureMethod = "http://www.w3.org/2007/05/xmldsig-more#sha3-384-rsa-MGF1"
+	// SHA3_512RSAMGF1SignatureMethod is an RSASSA-PSS signature method.
+	SHA3_512RSAMGF1SignatureMethod = "http://www.w3.org/2007/05/xmldsig-more#sha3-512-rsa-MGF1"
+
+	// ECDSASHA1SignatureMethod is a signature method.
+	ECDSASHA1SignatureMethod = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha1"
+	// ECDSASHA224SignatureMethod is a signature method.
+	ECDSASHA224SignatureMethod = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha224"
+	// ECDSASHA256SignatureMethod is a signature method.
+	ECDSASHA256SignatureMethod = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256"
+	// ECDSASHA384SignatureMethod is a signature method.
+	ECDSASHA384SignatureMethod = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha384"
+	// ECDSASHA512SignatureMethod is a signature method.
+	ECDSASHA512SignatureMethod = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha512"
+)
+
+const (
+	// SHA1DigestMethod is a digest method.
+	SHA1DigestMethod = "http://www.w3.org/2000/09/xmldsig#sha1"
+	// SHA224DigestMethod is a digest method.
+	SHA224DigestMethod = "http://www.w3.org/2001/04/xmldsig-more#sha224"
+	// SHA256DigestMethod is a digest method.
+	SHA256DigestMethod = "http://www.w3.org/2001/04/xmlenc#sha256"
+	// SHA384DigestMethod is a digest method.
+	SHA384DigestMethod = "http://www.w3.org/2001/04/xmldsig-more#sha384"
+	// SHA512DigestMethod is a digest method.
+	SHA512DigestMethod = "http://www.w3.org/2001/04/xmlenc#sha512"
+	// SHA3_256DigestMethod is a digest method.
+	SHA3_256DigestMethod = "http://www.w3.org/2007/05/xmldsig-more#sha3-256"
+	// SHA3_384DigestMethod is a digest method.
+	SHA3_384DigestMethod = "http://www.w3.org/2007/05/xmldsig-more#sha3-384"
+	// SHA3_512DigestMethod is a digest method.
+	SHA3_512DigestMethod = "http://www.w3.org/2007/05/xmldsig-more#sha3-512"
 )
 
 //Well-known signature algorithms
 const (
 	// Supported canonicalization algorithms.
-	CanonicalXML10ExclusiveAlgorithmID AlgorithmID = "http://www.w3.org/2001/10/xml-exc-c14n#"
-	CanonicalXML11AlgorithmID          AlgorithmID = "http://www.w3.org/2006/12/xml-c14n11"
+	CanonicalXML10ExclusiveAlgorithmID             AlgorithmID = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	CanonicalXML10ExclusiveWithCommentsAlgorithmID AlgorithmID = "http://www.w3.org/2001/10/xml-exc-c14n#WithComments"
+	CanonicalXML11AlgorithmID                      AlgorithmID = "http://www.w3.org/2006/12/xml-c14n11"
+	CanonicalXML11WithCommentsAlgorithmID          AlgorithmID = "http://www.w3.org/2006/12/xml-c14n11#WithComments"
 
 	CanonicalXML10RecAlgorithmID     AlgorithmID = "http://www.w3.org/TR/2001/REC-xml-c14n-20010315"
 	CanonicalXML10CommentAlgorithmID AlgorithmID = "http://www.w3.org/TR/2001/REC-xml-c14n-20010315#WithComments"
 
 	EnvelopedSignatureAltorithmID AlgorithmID = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
-)
 
-var digestAlgorithmIdentifiers = map[crypto.Hash]string{
-	crypto.SHA1:   "http://www.w3.org/2000/09/xmldsig#sha1",
-	crypto.SHA256: "http://www.w3.org/2001/04/xmlenc#sha256",
-	crypto.SHA512: "http://www.w3.org/2001/04/xmlenc#sha512",
-}
+	// Base64AlgorithmID is the base64 decoding transform, whose output is an
+	// octet stream.
+	Base64AlgorithmID AlgorithmID = "http://www.w3.org/2000/09/xmldsig#base64"
 
-var digestAlgorithmsByIdentifier = map[string]crypto.Hash{}
-var signatureMethodsByIdentifier = map[string]crypto.Hash{}
+	// XPathAlgorithmID is the XPath transform, which keeps the nodes of its
+	// input for which an XPath expression is true.
+	XPathAlgorithmID AlgorithmID = "http://www.w3.org/TR/1999/REC-xpath-19991116"
 
-func init() {
-	for hash, id := range digestAlgorithmIdentifiers {
-		digestAlgorithmsByIdentifier[id] = hash
-	}
-	for hash, id := range signatureMethodIdentifiers {
-		signatureMethodsByIdentifier[id] = hash
-	}
-}
-
-var signatureMethodIdentifiers = map[crypto.Hash]string{
-	crypto.SHA1:   RSASHA1SignatureMethod,
-	crypto.SHA256: RSASHA256SignatureMethod,
-	crypto.SHA512: RSASHA512SignatureMethod,
-}
+	// XPathFilter2AlgorithmID is the XPath Filter 2.0 transform. It is also the
+	// namespace of the transform's XPath parameter elements.
+	XPathFilter2AlgorithmID AlgorithmID = "http://www.w3.org/2002/06/xmldsig-filter2"
+)
@@ -0,0 +1,364 @@
+package dsig
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/beevik/etree"
+	"gitlab.com/moolekkari/goxmldsig/etreeutils"
+	"gitlab.com/moolekkari/goxmldsig/types"
+)
+
+// NewXPathTransform returns an XPath Transform which keeps the nodes for
+// which xpath is true, for use in SigningContext.Transforms. namespaces maps
+// the prefixes used in xpath to namespace names; the "ds" prefix is always
+// bound to the XML Signature namespace. For example, a transform equivalent
+// to the enveloped signature transform is
+//
+//	NewXPathTransform("not(ancestor-or-self::ds:Signature)", nil)
+func NewXPathTransform(xpath string, namespaces map[string]string) types.Transform {
+	el := etree.NewElement(TransformTag)
+
+	xpathElement := el.CreateElement(XPathTag)
+	xpathElement.Space = DefaultPrefix
+	declareNamespaces(xpathElement, namespaces)
+	xpathElement.CreateAttr("xmlns:"+DefaultPrefix, Namespace)
+	xpathElement.SetText(xpath)
+
+	transform := types.Transform{Algorithm: XPathAlgorithmID.String()}
+	transform.SetUnderlyingElement(el)
+
+	return transform
+}
+
+// declareNamespaces declares namespaces on el, in a stable order.
+func declareNamespaces(el *etree.Element, namespaces map[string]string) {
+	prefixes := make([]string, 0, len(namespaces))
+	for prefix := range namespaces {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	for _, prefix := range prefixes {
+		el.CreateAttr("xmlns:"+prefix, namespaces[prefix])
+	}
+}
+
+// xpathTransform implements the XPath transform. The expression is
+// evaluated with each node of the input node-set as the context node, and
+// the node is kept if the result is true.
+type xpathTransform struct {
+	xpath *etreeutils.XPath
+
+	// index is the position of the XPath element within the Transform
+	// element, used to locate it for here().
+	index int
+}
+
+func newXPathTransform(t *types.Transform) (Transform, error) {
+	el := t.UnderlyingElement()
+	if el == nil {
+		return nil, errors.New("XPath transform is missing its XPath element")
+	}
+
+	nsCtx, err := etreeutils.NSBuildParentContext(el)
+	if err != nil {
+		return nil, err
+	}
+
+	var transform *xpathTransform
+
+	err = etreeutils.NSFindChildrenIterateCtx(nsCtx, el, Namespace, XPathTag,
+		func(nsCtx etreeutils.NSContext, xpathElement *etree.Element) error {
+			xpathCtx, err := nsCtx.SubContext(xpathElement)
+			if err != nil {
+				return err
+			}
+
+			xpath, err := etreeutils.CompileXPath(xpathCtx, xpathElement.Text())
+			if err != nil {
+				return err
+			}
+
+			transform = &xpathTransform{
+				xpath: xpath,
+				index: xpathElement.Index(),
+			}
+			return etreeutils.ErrTraversalHalted
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	if transform == nil {
+		return nil, errors.New("XPath transform is missing its XPath element")
+	}
+
+	return transform, nil
+}
+
+// Transform applies the expression to el. It fails if the result is not a
+// single subtree; use TransformNodeSet to obtain arbitrary results.
+func (t *xpathTransform) Transform(el *etree.Element, tctx *TransformContext) (*etree.Element, error) {
+	ns, err := t.TransformNodeSet(etreeutils.NewNodeSet(el), tctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ns.IsSubtree() {
+		return nil, errors.New("XPath transform result is not a single element")
+	}
+
+	return ns.Root, nil
+}
+
+// TransformNodeSet keeps the nodes of ns for which the expression is true.
+func (t *xpathTransform) TransformNodeSet(ns *etreeutils.NodeSet, tctx *TransformContext) (*etreeutils.NodeSet, error) {
+	if ns.Root == nil {
+		return ns, nil
+	}
+
+	if err := tctx.limits.checkXPath(t.xpath); err != nil {
+		return nil, err
+	}
+
+	doc, err := newXPathDocument(ns, tctx.limits)
+	if err != nil {
+		return nil, err
+	}
+
+	doc.SetHere(tctx.parameterElement(t.index))
+
+	err = ns.Retain(func(n etreeutils.XPathNode) (bool, error) {
+		return t.xpath.Boolean(doc, n)
+	})
+	if err != nil {
+		return nil, tctx.limits.xpathError(err)
+	}
+
+	return ns, nil
+}
+
+// XPathFilter is a single XPath element of an XPath Filter 2.0 transform.
+type XPathFilter struct {
+	// Filter is the set operation applied with the selected subtrees: one of
+	// XPathFilterIntersect, XPathFilterSubtract or XPathFilterUnion.
+	Filter string
+
+	// XPath selects the nodes whose subtrees the filter applies to.
+	XPath string
+
+	// Namespaces maps the namespace prefixes used in XPath to namespace
+	// names.
+	Namespaces map[string]string
+}
+
+// NewXPathFilter2Transform returns an XPath Filter 2.0 Transform which
+// applies filters in order, for use in SigningContext.Transforms.
+func NewXPathFilter2Transform(filters ...XPathFilter) types.Transform {
+	el := etree.NewElement(TransformTag)
+
+	for _, filter := range filters {
+		xpath := el.CreateElement(XPathTag)
+		xpath.Space = "dsig-xpath"
+		xpath.CreateAttr("xmlns:dsig-xpath", XPathFilter2AlgorithmID.String())
+		declareNamespaces(xpath, filter.Namespaces)
+
+		xpath.CreateAttr(FilterAttr, filter.Filter)
+		xpath.SetText(filter.XPath)
+	}
+
+	transform := types.Transform{Algorithm: XPathFilter2AlgorithmID.String()}
+	transform.SetUnderlyingElement(el)
+
+	return transform
+}
+
+type xpathFilter struct {
+	filter string
+	xpath  *etreeutils.XPath
+
+	// index is the position of the XPath element within the Transform
+	// element, used to locate it for here().
+	index int
+}
+
+// xpathFilter2Transform implements the XPath Filter 2.0 transform. Each
+// filter selects a set of subtrees of the input document, which are
+// intersected with, subtracted from or added to the result of the filters
+// before it. The output is the part of the input node-set which is in the
+// final result.
+type xpathFilter2Transform struct {
+	filters []xpathFilter
+}
+
+func newXPathFilter2Transform(t *types.Transform) (Transform, error) {
+	el := t.UnderlyingElement()
+	if el == nil {
+		return nil, errors.New("XPath Filter 2.0 transform is missing its XPath elements")
+	}
+
+	nsCtx, err := etreeutils.NSBuildParentContext(el)
+	if err != nil {
+		return nil, err
+	}
+
+	var filters []xpathFilter
+
+	err = etreeutils.NSFindChildrenIterateCtx(nsCtx, el, XPathFilter2AlgorithmID.String(), XPathTag,
+		func(nsCtx etreeutils.NSContext, xpathElement *etree.Element) error {
+			filter := xpathElement.SelectAttrValue(FilterAttr, "")
+			switch filter {
+			case XPathFilterIntersect, XPathFilterSubtract, XPathFilterUnion:
+			default:
+				return fmt.Errorf("invalid XPath Filter: %s", filter)
+			}
+
+			xpathCtx, err := nsCtx.SubContext(xpathElement)
+			if err != nil {
+				return err
+			}
+
+			xpath, err := etreeutils.CompileXPath(xpathCtx, xpathElement.Text())
+			if err != nil {
+				return err
+			}
+
+			filters = append(filters, xpathFilter{
+				filter: filter,
+				xpath:  xpath,
+				index:  xpathElement.Index(),
+			})
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(filters) == 0 {
+		return nil, errors.New("XPath Filter 2.0 transform is missing its XPath elements")
+	}
+
+	return &xpathFilter2Transform{filters: filters}, nil
+}
+
+// Transform applies the filters to el. It fails if the result is not a
+// single subtree; use TransformNodeSet to obtain arbitrary results.
+func (t *xpathFilter2Transform) Transform(el *etree.Element, tctx *TransformContext) (*etree.Element, error) {
+	ns, err := t.TransformNodeSet(etreeutils.NewNodeSet(el), tctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ns.IsSubtree() {
+		return nil, errors.New("XPath Filter 2.0 result is not a single element")
+	}
+
+	return ns.Root, nil
+}
+
+// TransformNodeSet applies the filters to ns.
+func (t *xpathFilter2Transform) TransformNodeSet(ns *etreeutils.NodeSet, tctx *TransformContext) (*etreeutils.NodeSet, error) {
+	if ns.Root == nil {
+		return ns, nil
+	}
+
+	for _, filter := range t.filters {
+		if err := tctx.limits.checkXPath(filter.xpath); err != nil {
+			return nil, err
+		}
+	}
+
+	doc, err := newXPathDocument(ns, tctx.limits)
+	if err != nil {
+		return nil, err
+	}
+
+	selections := make([]map[etreeutils.XPathNode]bool, len(t.filters))
+
+	for i, filter := range t.filters {
+		doc.SetHere(tctx.parameterElement(filter.index))
+
+		nodes, err := filter.xpath.Select(doc, doc.Root())
+		if err != nil {
+			return nil, tctx.limits.xpathError(err)
+		}
+
+		selections[i] = make(map[etreeutils.XPathNode]bool, len(nodes))
+		for _, n := range nodes {
+			selections[i][n] = true
+		}
+	}
+
+	// A node is in the subtree of a selected node if it, or any of its
+	// ancestors, was selected.
+	inSubtrees := func(selected map[etreeutils.XPathNode]bool, n etreeutils.XPathNode) bool {
+		for {
+			if selected[n] {
+				return true
+			}
+
+			parent, ok := doc.Parent(n)
+			if !ok {
+				return false
+			}
+			n = parent
+		}
+	}
+
+	err = ns.Retain(func(n etreeutils.XPathNode) (bool, error) {
+		in := true
+
+		for i, filter := range t.filters {
+			switch filter.filter {
+			case XPathFilterIntersect:
+				in = in && inSubtrees(selections[i], n)
+			case XPathFilterSubtract:
+				in = in && !inSubtrees(selections[i], n)
+			case XPathFilterUnion:
+				in = in || inSubtrees(selections[i], n)
+			}
+		}
+
+		return in, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return ns, nil
+}
+
+// newXPathDocument indexes the tree of ns for XPath evaluation, bounded by
+// limits.
+func newXPathDocument(ns *etreeutils.NodeSet, limits *ValidationLimits) (*etreeutils.XPathDocument, error) {
+	nsCtx, err := etreeutils.NSBuildParentContext(ns.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	doc, err := etreeutils.NewXPathDocument(nsCtx, ns.Root)
+	if err != nil {
+		return nil, err
+	}
+
+	limits.limitXPath(doc)
+	return doc, nil
+}
+
+// parameterElement returns the child element at index of the Transform
+// element being applied, or nil if it is not available.
+func (tctx *TransformContext) parameterElement(index int) *etree.Element {
+	if tctx.element == nil || index >= len(tctx.element.Child) {
+		return nil
+	}
+
+	el, _ := tctx.element.Child[index].(*etree.Element)
+	return el
+}
+
+func init() {
+	RegisterTransform(XPathAlgorithmID, newXPathTransform)
+	RegisterTransform(XPathFilter2AlgorithmID, newXPathFilter2Transform)
+}
@@ -0,0 +1,196 @@
+package dsig
+
+import (
+	"crypto/x509"
+	"strings"
+	"testing"
+
+	"github.com/beevik/etree"
+	"github.com/stretchr/testify/require"
+	"gitlab.com/moolekkari/goxmldsig/etreeutils"
+	"gitlab.com/moolekkari/goxmldsig/types"
+)
+
+func TestXPathFilter2Transform(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	ctx := NewDefaultSigningContext(ks)
+	ctx.Transforms = []types.Transform{
+		NewXPathFilter2Transform(XPathFilter{
+			Filter:     XPathFilterSubtract,
+			XPath:      "//ex:Volatile",
+			Namespaces: map[string]string{"ex": "urn:example"},
+		}),
+	}
+
+	doc := etree.NewDocument()
+	err = doc.ReadFromString(`<Root xmlns:ex="urn:example"><Stable>a</Stable><ex:Volatile>b</ex:Volatile></Root>`)
+	require.NoError(t, err)
+
+	signed, err := ctx.SignEnveloped(doc.Root())
+	require.NoError(t, err)
+
+	xpath := signed.FindElement("./Signature/SignedInfo/Reference/Transforms/Transform[2]/XPath")
+	require.NotNil(t, xpath)
+	require.Equal(t, XPathFilterSubtract, xpath.SelectAttrValue(FilterAttr, ""))
+	require.Equal(t, "urn:example", xpath.SelectAttrValue("xmlns:ex", ""))
+
+	// Round trip through serialization, so that the XPath parameters are
+	// read back from the document.
+	doc = etree.NewDocument()
+	doc.SetRoot(signed)
+	str, err := doc.WriteToString()
+	require.NoError(t, err)
+
+	doc = etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(str))
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+
+	doc.Root().FindElement("./ex:Volatile").SetText("changed")
+	_, err = vc.Validate(doc.Root())
+	require.NoError(t, err)
+
+	doc.Root().FindElement("./Stable").SetText("changed")
+	_, err = vc.Validate(doc.Root())
+	require.Error(t, err)
+}
+
+func TestXPathFilter2NodeSet(t *testing.T) {
+	doc := etree.NewDocument()
+	err := doc.ReadFromString(`<Root xmlns:p="urn:p"><Body>one</Body><p:Skip><Body>two</Body></p:Skip><Body>three</Body><Other/></Root>`)
+	require.NoError(t, err)
+
+	namespaces := map[string]string{"p": "urn:p"}
+	transform := NewXPathFilter2Transform(
+		XPathFilter{Filter: XPathFilterIntersect, XPath: "//Body | //p:Skip", Namespaces: namespaces},
+		XPathFilter{Filter: XPathFilterSubtract, XPath: "//p:Skip", Namespaces: namespaces},
+		XPathFilter{Filter: XPathFilterUnion, XPath: "//p:Skip/Body", Namespaces: namespaces},
+	)
+
+	filter, err := NewTransform(&transform)
+	require.NoError(t, err)
+
+	ns, err := filter.(NodeSetTransform).TransformNodeSet(etreeutils.NewNodeSet(doc.Root()), &TransformContext{})
+	require.NoError(t, err)
+
+	canonical, err := canonicalizeNodeSet(MakeC14N11Canonicalizer(), ns)
+	require.NoError(t, err)
+	require.Equal(t, `<Body xmlns:p="urn:p">one</Body><Body xmlns:p="urn:p">two</Body><Body xmlns:p="urn:p">three</Body>`, string(canonical))
+
+	canonical, err = canonicalizeNodeSet(MakeC14N10ExclusiveCanonicalizerWithPrefixList(""), ns)
+	require.NoError(t, err)
+	require.Equal(t, `<Body>one</Body><Body>two</Body><Body>three</Body>`, string(canonical))
+
+	// The result cannot be represented as a single element.
+	_, err = filter.Transform(doc.Root(), &TransformContext{})
+	require.Error(t, err)
+}
+
+func TestXPathFilter2InvalidParameters(t *testing.T) {
+	_, err := NewTransform(&types.Transform{Algorithm: XPathFilter2AlgorithmID.String()})
+	require.Error(t, err)
+
+	transform := NewXPathFilter2Transform(XPathFilter{Filter: "exclude", XPath: "/"})
+	_, err = NewTransform(&transform)
+	require.EqualError(t, err, "invalid XPath Filter: exclude")
+
+	transform = NewXPathFilter2Transform(XPathFilter{Filter: XPathFilterSubtract, XPath: "//undeclared:Item"})
+	_, err = NewTransform(&transform)
+	require.Error(t, err)
+}
+
+func TestXPathTransform(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	ctx := NewDefaultSigningContext(ks)
+	ctx.Transforms = []types.Transform{
+		NewXPathTransform("not(ancestor-or-self::ex:Volatile)", map[string]string{"ex": "urn:example"}),
+	}
+
+	doc := etree.NewDocument()
+	err = doc.ReadFromString(`<Root xmlns:ex="urn:example"><Stable>a</Stable><ex:Volatile>b</ex:Volatile></Root>`)
+	require.NoError(t, err)
+
+	signed, err := ctx.SignEnveloped(doc.Root())
+	require.NoError(t, err)
+
+	xpath := signed.FindElement("./Signature/SignedInfo/Reference/Transforms/Transform[2]/XPath")
+	require.NotNil(t, xpath)
+	require.Equal(t, "not(ancestor-or-self::ex:Volatile)", xpath.Text())
+
+	doc = etree.NewDocument()
+	doc.SetRoot(signed)
+	str, err := doc.WriteToString()
+	require.NoError(t, err)
+
+	doc = etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(str))
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+
+	doc.Root().FindElement("./ex:Volatile").SetText("changed")
+	_, err = vc.Validate(doc.Root())
+	require.NoError(t, err)
+
+	doc.Root().FindElement("./Stable").SetText("changed")
+	_, err = vc.Validate(doc.Root())
+	require.Error(t, err)
+}
+
+func TestXPathTransformExcludesSignature(t *testing.T) {
+	ks := RandomKeyStoreForTest()
+	_, cert, err := ks.GetKeyPair()
+	require.NoError(t, err)
+
+	// The XPath idiom is used in place of the enveloped signature transform.
+	ctx := NewDefaultSigningContext(ks)
+	ctx.Transforms = []types.Transform{
+		NewXPathTransform("not(ancestor-or-self::ds:Signature)", nil),
+	}
+
+	doc := etree.NewDocument()
+	require.NoError(t, doc.ReadFromString(`<Root><Body>content</Body></Root>`))
+
+	sig, err := ctx.ConstructSignature(doc.Root(), false)
+	require.NoError(t, err)
+
+	signed := doc.Root().Copy()
+	signed.AddChild(sig)
+
+	transforms := signed.FindElements("./Signature/SignedInfo/Reference/Transforms/Transform")
+	require.Len(t, transforms, 2)
+	require.Equal(t, XPathAlgorithmID.String(), transforms[0].SelectAttrValue(AlgorithmAttr, ""))
+
+	vc := NewDefaultValidationContext(&MemoryX509CertificateStore{
+		Roots: []*x509.Certificate{cert},
+	})
+
+	_, err = vc.Validate(signed)
+	require.NoError(t, err)
+
+	signed.FindElement("./Body").SetText("changed")
+	_, err = vc.Validate(signed)
+	require.Error(t, err)
+}
+
+func TestXPathTransformInvalidParameters(t *testing.T) {
+	_, err := NewTransform(&types.Transform{Algorithm: XPathAlgorithmID.String()})
+	require.Error(t, err)
+
+	transform := NewXPathTransform("//undeclared:Item", nil)
+	_, err = NewTransform(&transform)
+	require.Error(t, err)
+
+	transform = NewXPathTransform(strings.Repeat("(", 100)+"1"+strings.Repeat(")", 100), nil)
+	_, err = NewTransform(&transform)
+	require.Equal(t, etreeutils.ErrXPathTooComplex, err)
+}